@@ -0,0 +1,107 @@
+// Команда rehash-passwords один раз хеширует пароли, записанные открытым
+// текстом, текущим алгоритмом сервиса. Ее нужно запустить до миграции
+// 20250820100000_hashing_plaintext_passwords, которая запрещает такие пароли.
+// Хешем считается только значение, которое разбирает хешер сервиса: строка,
+// лишь похожая на хеш по префиксу, хешируется как открытый текст.
+// Повторный запуск безопасен: уже захешированные пароли не трогаются
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rx3lixir/user-service/internal/config"
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/pkg/logger"
+	"github.com/rx3lixir/user-service/pkg/password"
+)
+
+// batchSize сколько паролей читается за один запрос
+const batchSize = 500
+
+type listFunc func(ctx context.Context, afterID, limit int) ([]*db.StoredPassword, error)
+
+type replaceFunc func(ctx context.Context, id int, plain, hash string) (bool, error)
+
+func main() {
+	c, err := config.New()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка загрузки конфигурации: %v\n", err)
+		os.Exit(1)
+	}
+
+	logger.Init(c.Service.Env)
+	defer logger.Close()
+
+	log := logger.NewLogger()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pool, err := db.CreatePostgresPool(ctx, c.DB.DSN())
+	if err != nil {
+		log.Error("Failed to create postgres pool", "error", err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+
+	storer := db.NewPosgresStore(pool)
+	hasher := c.PasswordHashing.Hashers()
+
+	users, err := rehash(ctx, hasher, storer.ListStoredPasswords, storer.ReplacePlaintextPassword)
+	if err != nil {
+		log.Error("Failed to hash user passwords", "hashed", users, "error", err)
+		os.Exit(1)
+	}
+	log.Info("User passwords hashed", "hashed", users)
+
+	history, err := rehash(ctx, hasher, storer.ListStoredPasswordHistory, storer.ReplacePlaintextPasswordHistory)
+	if err != nil {
+		log.Error("Failed to hash password history", "hashed", history, "error", err)
+		os.Exit(1)
+	}
+	log.Info("Password history hashed", "hashed", history)
+}
+
+// rehash проходит по сохраненным паролям пачками по id и заменяет хешем каждый,
+// который не является корректным хешем. Возвращает число замененных паролей
+func rehash(ctx context.Context, hasher *password.Hashers, list listFunc, replace replaceFunc) (int, error) {
+	hashed := 0
+	afterID := 0
+
+	for {
+		batch, err := list(ctx, afterID, batchSize)
+		if err != nil {
+			return hashed, err
+		}
+
+		for _, p := range batch {
+			afterID = p.Id
+
+			if hasher.Check(p.Value) == nil {
+				continue
+			}
+
+			hash, err := hasher.Hash(p.Value)
+			if err != nil {
+				return hashed, fmt.Errorf("failed to hash password %d: %w", p.Id, err)
+			}
+
+			// Пароль могли сменить, пока он хешировался: тогда он уже хеш
+			ok, err := replace(ctx, p.Id, p.Value, hash)
+			if err != nil {
+				return hashed, err
+			}
+			if ok {
+				hashed++
+			}
+		}
+
+		if len(batch) < batchSize {
+			return hashed, nil
+		}
+	}
+}
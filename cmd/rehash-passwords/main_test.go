@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/pkg/password"
+)
+
+func TestRehashSkipsOnlyValidHashes(t *testing.T) {
+	hasher := password.NewHashers(password.NewBcrypt(4), password.LegacyHashers()...)
+
+	valid, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := map[int]string{
+		1: valid,
+		2: "plaintext",
+		3: "$1$looks-like-md5crypt",
+		4: "$2a$not-a-bcrypt-hash",
+	}
+
+	list := func(ctx context.Context, afterID, limit int) ([]*db.StoredPassword, error) {
+		var batch []*db.StoredPassword
+		for id := afterID + 1; id <= len(stored) && len(batch) < limit; id++ {
+			batch = append(batch, &db.StoredPassword{Id: id, Value: stored[id]})
+		}
+		return batch, nil
+	}
+
+	replaced := map[int]string{}
+	replace := func(ctx context.Context, id int, plain, hash string) (bool, error) {
+		if stored[id] != plain {
+			return false, nil
+		}
+		replaced[id] = hash
+		return true, nil
+	}
+
+	hashed, err := rehash(context.Background(), hasher, list, replace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hashed != 3 {
+		t.Errorf("hashed %d passwords, want 3", hashed)
+	}
+
+	if _, ok := replaced[1]; ok {
+		t.Error("valid hash was rehashed")
+	}
+
+	for _, id := range []int{2, 3, 4} {
+		if ok, err := hasher.Verify(stored[id], replaced[id]); err != nil || !ok {
+			t.Errorf("password %d: replacement does not verify (%v)", id, err)
+		}
+	}
+}
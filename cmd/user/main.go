@@ -11,8 +11,14 @@ import (
 
 	"github.com/rx3lixir/user-service/internal/config"
 	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/federation"
+	"github.com/rx3lixir/user-service/internal/keys"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/internal/token"
 	"github.com/rx3lixir/user-service/pkg/health"
 	"github.com/rx3lixir/user-service/pkg/logger"
+	"github.com/rx3lixir/user-service/pkg/password"
+	"github.com/rx3lixir/user-service/pkg/secret"
 	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
 	"github.com/rx3lixir/user-service/user-grpc/server"
 
@@ -63,11 +69,105 @@ func main() {
 
 	// Создаем хранилище и gRPC сервер
 	storer := db.NewPosgresStore(pool)
-	srv := server.NewServer(storer, log)
+
+	// Ключ шифрования секретов, хранящихся в базе
+	box, err := secret.NewBoxFromBase64(c.Auth.EncryptionKey)
+	if err != nil {
+		log.Error("Failed to create encryption box", "error", err)
+		os.Exit(1)
+	}
+
+	// Менеджер ключей подписи: создает начальные ключи и выполняет ротацию
+	keyManager := keys.NewManager(storer, box, log,
+		keys.WithRotationInterval(c.Auth.KeyRotationInterval),
+		keys.WithRetention(c.Auth.KeyRetention),
+	)
+	if err := keyManager.Init(ctx); err != nil {
+		log.Error("Failed to initialize signing keys", "error", err)
+		os.Exit(1)
+	}
+	go keyManager.Run(ctx)
+
+	issuer := token.NewIssuer(
+		keyManager,
+		c.Auth.Issuer,
+		c.Auth.AccessTokenTTL,
+		c.Auth.RefreshTokenTTL,
+	)
+
+	// Письма отправляются через SMTP, если он настроен, иначе пишутся в лог
+	var mail mailer.Mailer = mailer.NewLogMailer(log)
+	if c.Mail.SMTPHost != "" {
+		mail = mailer.NewSMTPMailer(
+			c.Mail.SMTPHost,
+			c.Mail.SMTPPort,
+			c.Mail.SMTPUsername,
+			c.Mail.SMTPPassword,
+			c.Mail.From,
+		)
+	}
+
+	// Старые и импортированные хеши обновляются при входе
+	hasher := c.PasswordHashing.Hashers()
+
+	opts := []server.Option{
+		server.WithMailer(mail),
+		server.WithAppBaseURL(c.Mail.AppBaseURL),
+		server.WithPasswordResetTTL(c.Auth.PasswordResetTTL),
+		server.WithEmailVerificationTTL(c.Auth.EmailVerificationTTL),
+		server.WithSecretBox(box),
+		// Issuer теперь URL провайдера, а в приложении-аутентификаторе нужно имя сервиса
+		server.WithTOTPIssuer("user-service"),
+		server.WithChallengeTTL(c.Auth.ChallengeTTL),
+		server.WithAuthorizationCodeTTL(c.Auth.AuthorizationCodeTTL),
+		server.WithImpersonationTTL(c.Auth.ImpersonationTTL),
+		server.WithPermissionCacheTTL(c.Auth.PermissionCacheTTL),
+		server.WithLockout(c.Lockout.MaxAttempts, c.Lockout.BaseDelay, c.Lockout.MaxDelay),
+		server.WithPasswordPolicy(password.Policy{
+			MinLength:      c.PasswordPolicy.MinLength,
+			MaxLength:      c.PasswordPolicy.MaxLength,
+			RequireUpper:   c.PasswordPolicy.RequireUpper,
+			RequireLower:   c.PasswordPolicy.RequireLower,
+			RequireDigit:   c.PasswordPolicy.RequireDigit,
+			RequireSymbol:  c.PasswordPolicy.RequireSymbol,
+			ForbidPersonal: c.PasswordPolicy.ForbidPersonal,
+			ForbidCommon:   c.PasswordPolicy.ForbidCommon,
+		}),
+		server.WithPasswordHasher(hasher),
+		server.WithPasswordHistory(c.PasswordPolicy.HistoryDepth, c.PasswordPolicy.HistoryRetention),
+		server.WithPasswordMaxAge(c.PasswordPolicy.AdminMaxAge, c.PasswordPolicy.UserMaxAge),
+		server.WithFederatedLoginTTL(c.Federation.StateTTL),
+		server.WithMagicLinkTTL(c.MagicLink.TTL),
+		server.WithMagicLinkRateLimit(c.MagicLink.MaxPerEmail, c.MagicLink.MaxPerIP, c.MagicLink.Window),
+		server.WithMagicLinkForAdmins(c.MagicLink.AllowAdmins),
+		server.WithClientIPHeader(c.Server.ClientIPHeader),
+	}
+
+	// Вышестоящие провайдеры для входа через корпоративный или внешний аккаунт
+	for _, p := range c.Federation.Providers {
+		provider := federation.NewOIDCProvider(federation.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}, nil)
+		opts = append(opts, server.WithFederatedProvider(provider, p.AllowSignup, p.LinkByEmail))
+	}
+
+	srv := server.NewServer(storer, issuer, log, opts...)
 
 	// Настраиваем gRPC сервер
 	grpcServer := grpc.NewServer(
-	// Здесь можно добавить перехватчики (interceptors) для логирования, трассировки и т.д.
+		// Перехватчики проверяют API-ключи и access-токены из заголовка authorization
+		// и политику доступа каждого метода
+		grpc.ChainUnaryInterceptor(
+			srv.UnaryAuthInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			srv.StreamAuthInterceptor(),
+		),
 	)
 	pb.RegisterUserServiceServer(grpcServer, srv)
 
@@ -83,13 +183,44 @@ func main() {
 
 	log.Info("gRPC server is listening", "address", c.Server.Address)
 
+	// Провайдер OpenID Connect для веб-приложений обслуживается HTTP сервером вместе с JWKS
+	oidcHandler := srv.OIDCHandler()
+
 	// Создаем HealthCheck сервер
 	healthServer := health.NewServer(pool, log,
 		health.WithServiceName("user-service"),
 		health.WithVersion("1.0.0"),
 		health.WithPort(":8083"),
 		health.WithTimeout(5*time.Second),
-		health.WithRequiredTables("users"),
+		health.WithRequiredTables(
+			"users",
+			"refresh_tokens",
+			"signing_keys",
+			"password_reset_tokens",
+			"email_verification_tokens",
+			"user_totp",
+			"challenge_failures",
+			"recovery_codes",
+			"audit_log",
+			"api_keys",
+			"password_history",
+			"oauth_clients",
+			"oauth_authorization_codes",
+			"user_identities",
+			"magic_link_tokens",
+			"magic_link_requests",
+			"impersonation_sessions",
+			"roles",
+			"permissions",
+			"role_permissions",
+			"user_roles",
+			"organizations",
+			"groups",
+			"group_members",
+		),
+		health.WithHandler("/.well-known/jwks.json", keyManager.Handler()),
+		health.WithHandler(server.OIDCDiscoveryPath, oidcHandler),
+		health.WithHandler(server.OAuthPathPrefix, oidcHandler),
 	)
 
 	// Запускаем серверы
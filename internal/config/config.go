@@ -6,27 +6,81 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/rx3lixir/user-service/pkg/password"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
 )
 
 // Константы для ключей конфигурации
 const (
-	envKey            = "service_params.env"
-	usernameKey       = "db_params.username"
-	passwordKey       = "db_params.password"
-	dbNameKey         = "db_params.db_name"
-	hostKey           = "db_params.host"
-	portKey           = "db_params.port"
-	connectTimeoutKey = "db_params.connect_timeout"
-	serviceAddress    = "server_params.address"
+	envKey               = "service_params.env"
+	usernameKey          = "db_params.username"
+	passwordKey          = "db_params.password"
+	dbNameKey            = "db_params.db_name"
+	hostKey              = "db_params.host"
+	portKey              = "db_params.port"
+	connectTimeoutKey    = "db_params.connect_timeout"
+	serviceAddress       = "server_params.address"
+	encryptionKey        = "auth_params.encryption_key"
+	jwtIssuerKey         = "auth_params.issuer"
+	accessTTLKey         = "auth_params.access_token_ttl"
+	refreshTTLKey        = "auth_params.refresh_token_ttl"
+	keyRotationKey       = "auth_params.key_rotation_interval"
+	keyRetentionKey      = "auth_params.key_retention"
+	resetTTLKey          = "auth_params.password_reset_ttl"
+	verifyTTLKey         = "auth_params.email_verification_ttl"
+	challengeTTLKey      = "auth_params.challenge_ttl"
+	authCodeTTLKey       = "auth_params.authorization_code_ttl"
+	impersonationTTLKey  = "auth_params.impersonation_ttl"
+	permissionCacheKey   = "auth_params.permission_cache_ttl"
+	smtpHostKey          = "mail_params.smtp_host"
+	smtpPortKey          = "mail_params.smtp_port"
+	smtpUsernameKey      = "mail_params.smtp_username"
+	smtpPasswordKey      = "mail_params.smtp_password"
+	mailFromKey          = "mail_params.from"
+	appBaseURLKey        = "mail_params.app_base_url"
+	maxAttemptsKey       = "lockout_params.max_attempts"
+	baseDelayKey         = "lockout_params.base_delay"
+	maxDelayKey          = "lockout_params.max_delay"
+	pwMinLengthKey       = "password_policy.min_length"
+	pwMaxLengthKey       = "password_policy.max_length"
+	pwUpperKey           = "password_policy.require_upper"
+	pwLowerKey           = "password_policy.require_lower"
+	pwDigitKey           = "password_policy.require_digit"
+	pwSymbolKey          = "password_policy.require_symbol"
+	pwPersonalKey        = "password_policy.forbid_personal"
+	pwCommonKey          = "password_policy.forbid_common"
+	hashAlgorithmKey     = "password_hashing.algorithm"
+	bcryptCostKey        = "password_hashing.bcrypt_cost"
+	argon2MemoryKey      = "password_hashing.argon2_memory"
+	argon2IterationsKey  = "password_hashing.argon2_iterations"
+	argon2ParallelismKey = "password_hashing.argon2_parallelism"
+	historyDepthKey      = "password_policy.history_depth"
+	historyRetentionKey  = "password_policy.history_retention"
+	adminMaxAgeKey       = "password_policy.admin_max_age"
+	userMaxAgeKey        = "password_policy.user_max_age"
+	federationStateTTL   = "federation.state_ttl"
+	magicLinkTTLKey      = "magic_link_params.ttl"
+	magicLinkWindowKey   = "magic_link_params.window"
+	magicLinkEmailKey    = "magic_link_params.max_per_email"
+	magicLinkIPKey       = "magic_link_params.max_per_ip"
+	magicLinkAdminsKey   = "magic_link_params.allow_admins"
+	clientIPHeaderKey    = "server_params.client_ip_header"
 )
 
 // AppConfig представляет конфигурацию всего приложения
 type AppConfig struct {
-	Service ServiceParams `mapstructure:"service_params" validate:"required"`
-	DB      DBParams      `mapstructure:"db_params" validate:"required"`
-	Server  ServerParams  `mapstructure:"server_params" validate:"required"`
+	Service         ServiceParams         `mapstructure:"service_params" validate:"required"`
+	DB              DBParams              `mapstructure:"db_params" validate:"required"`
+	Server          ServerParams          `mapstructure:"server_params" validate:"required"`
+	Auth            AuthParams            `mapstructure:"auth_params" validate:"required"`
+	Mail            MailParams            `mapstructure:"mail_params" validate:"required"`
+	Lockout         LockoutParams         `mapstructure:"lockout_params" validate:"required"`
+	PasswordPolicy  PasswordPolicyParams  `mapstructure:"password_policy" validate:"required"`
+	PasswordHashing PasswordHashingParams `mapstructure:"password_hashing" validate:"required"`
+	Federation      FederationParams      `mapstructure:"federation" validate:"required"`
+	MagicLink       MagicLinkParams       `mapstructure:"magic_link_params" validate:"required"`
 }
 
 // ApplicationParams содержит общие параметры приложения
@@ -36,6 +90,139 @@ type ServiceParams struct {
 
 type ServerParams struct {
 	Address string `mapstructure:"address" validate:"required"`
+	// ClientIPHeader заголовок с адресом клиента, который выставляет прокси перед сервисом
+	ClientIPHeader string `mapstructure:"client_ip_header"`
+}
+
+// AuthParams содержит параметры выпуска токенов и управления ключами подписи
+type AuthParams struct {
+	// EncryptionKey ключ AES-256 в base64, которым шифруются секреты в базе.
+	// Может быть пустым только в окружении dev
+	EncryptionKey string `mapstructure:"encryption_key" validate:"omitempty,base64"`
+	// Issuer одновременно адрес провайдера OpenID Connect: от него строятся
+	// адреса в discovery, поэтому это должен быть внешний URL HTTP сервера
+	Issuer              string        `mapstructure:"issuer" validate:"required,url"`
+	AccessTokenTTL      time.Duration `mapstructure:"access_token_ttl" validate:"required,min=1"`
+	RefreshTokenTTL     time.Duration `mapstructure:"refresh_token_ttl" validate:"required,gtfield=AccessTokenTTL"`
+	KeyRotationInterval time.Duration `mapstructure:"key_rotation_interval" validate:"required,gtfield=AccessTokenTTL"`
+	// KeyRetention должен превышать AccessTokenTTL, иначе выведенный ключ
+	// пропадет из JWKS раньше, чем истекут подписанные им токены
+	KeyRetention         time.Duration `mapstructure:"key_retention" validate:"required,gtfield=AccessTokenTTL"`
+	PasswordResetTTL     time.Duration `mapstructure:"password_reset_ttl" validate:"required,min=1"`
+	EmailVerificationTTL time.Duration `mapstructure:"email_verification_ttl" validate:"required,min=1"`
+	// ChallengeTTL сколько времени дается на ввод второго фактора после пароля
+	ChallengeTTL time.Duration `mapstructure:"challenge_ttl" validate:"required,min=1"`
+	// AuthorizationCodeTTL сколько живет код авторизации OpenID Connect
+	AuthorizationCodeTTL time.Duration `mapstructure:"authorization_code_ttl" validate:"required,min=1"`
+	// ImpersonationTTL сколько длится вход администратора под пользователем
+	ImpersonationTTL time.Duration `mapstructure:"impersonation_ttl" validate:"required,min=1"`
+	// PermissionCacheTTL сколько CheckPermission помнит роли пользователя. Кеш
+	// свой у каждой реплики, поэтому это и задержка, с которой другие реплики
+	// видят изменение ролей
+	PermissionCacheTTL time.Duration `mapstructure:"permission_cache_ttl" validate:"required,min=1"`
+}
+
+// MailParams содержит параметры отправки писем. Если SMTPHost пустой,
+// письма только пишутся в лог
+type MailParams struct {
+	SMTPHost     string `mapstructure:"smtp_host"`
+	SMTPPort     int    `mapstructure:"smtp_port" validate:"required_with=SMTPHost,omitempty,min=1,max=65535"`
+	SMTPUsername string `mapstructure:"smtp_username"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+	From         string `mapstructure:"from" validate:"required,email"`
+	// AppBaseURL адрес фронтенда, на который ведут ссылки из писем
+	AppBaseURL string `mapstructure:"app_base_url" validate:"required,url"`
+}
+
+// LockoutParams задает блокировку входа после неудачных попыток. Первая блокировка
+// наступает после MaxAttempts ошибок подряд и длится BaseDelay, каждая следующая
+// ошибка удваивает время блокировки, но не больше MaxDelay
+type LockoutParams struct {
+	MaxAttempts int           `mapstructure:"max_attempts" validate:"required,min=1"`
+	BaseDelay   time.Duration `mapstructure:"base_delay" validate:"required,min=1"`
+	MaxDelay    time.Duration `mapstructure:"max_delay" validate:"required,gtefield=BaseDelay"`
+}
+
+// PasswordPolicyParams требования к паролям пользователей. MaxLength не может
+// превышать 72: bcrypt молча отбрасывает все, что длиннее
+type PasswordPolicyParams struct {
+	MinLength      int  `mapstructure:"min_length" validate:"required,min=1"`
+	MaxLength      int  `mapstructure:"max_length" validate:"required,gtefield=MinLength,max=72"`
+	RequireUpper   bool `mapstructure:"require_upper"`
+	RequireLower   bool `mapstructure:"require_lower"`
+	RequireDigit   bool `mapstructure:"require_digit"`
+	RequireSymbol  bool `mapstructure:"require_symbol"`
+	ForbidPersonal bool `mapstructure:"forbid_personal"`
+	ForbidCommon   bool `mapstructure:"forbid_common"`
+	// HistoryDepth сколько последних паролей нельзя использовать повторно, 0 отключает проверку
+	HistoryDepth int `mapstructure:"history_depth" validate:"min=0"`
+	// HistoryRetention сколько хранятся старые пароли
+	HistoryRetention time.Duration `mapstructure:"history_retention" validate:"required_unless=HistoryDepth 0"`
+	// AdminMaxAge и UserMaxAge срок действия пароля администратора и обычного пользователя, 0 - бессрочно
+	AdminMaxAge time.Duration `mapstructure:"admin_max_age" validate:"min=0"`
+	UserMaxAge  time.Duration `mapstructure:"user_max_age" validate:"min=0"`
+}
+
+// PasswordHashingParams задает алгоритм для новых паролей и его параметры.
+// Хеши другим алгоритмом или с более слабыми параметрами обновляются при входе
+type PasswordHashingParams struct {
+	Algorithm  string `mapstructure:"algorithm" validate:"required,oneof=argon2id bcrypt"`
+	BcryptCost int    `mapstructure:"bcrypt_cost" validate:"required,min=10,max=16"`
+	// Argon2Memory объем памяти в KiB
+	Argon2Memory      uint32 `mapstructure:"argon2_memory" validate:"required,min=8192,max=1048576"`
+	Argon2Iterations  uint32 `mapstructure:"argon2_iterations" validate:"required,min=1,max=16"`
+	Argon2Parallelism uint8  `mapstructure:"argon2_parallelism" validate:"required,min=1,max=16"`
+}
+
+// Hashers собирает хешеры паролей: новые пароли хешируются выбранным
+// алгоритмом, остальные нужны для проверки старых и импортированных хешей
+func (p PasswordHashingParams) Hashers() *password.Hashers {
+	bcryptHasher := password.NewBcrypt(p.BcryptCost)
+
+	argon2Params := password.DefaultArgon2Params()
+	argon2Params.Memory = p.Argon2Memory
+	argon2Params.Iterations = p.Argon2Iterations
+	argon2Params.Parallelism = p.Argon2Parallelism
+	argon2Hasher := password.NewArgon2id(argon2Params)
+
+	if p.Algorithm == "bcrypt" {
+		return password.NewHashers(bcryptHasher, append([]password.Hasher{argon2Hasher}, password.LegacyHashers()...)...)
+	}
+
+	return password.NewHashers(argon2Hasher, append([]password.Hasher{bcryptHasher}, password.LegacyHashers()...)...)
+}
+
+// MagicLinkParams настройки входа по одноразовой ссылке из письма.
+// MaxPerEmail и MaxPerIP считаются за Window, 0 снимает ограничение.
+// AllowAdmins разрешает входить по ссылке администраторам
+type MagicLinkParams struct {
+	TTL         time.Duration `mapstructure:"ttl" validate:"required,min=1"`
+	Window      time.Duration `mapstructure:"window" validate:"required,min=1"`
+	MaxPerEmail int           `mapstructure:"max_per_email" validate:"min=0"`
+	MaxPerIP    int           `mapstructure:"max_per_ip" validate:"min=0"`
+	AllowAdmins bool          `mapstructure:"allow_admins"`
+}
+
+// FederationParams вышестоящие провайдеры OpenID Connect, через которых можно войти
+type FederationParams struct {
+	// StateTTL сколько времени дается на вход у провайдера
+	StateTTL  time.Duration             `mapstructure:"state_ttl" validate:"required,min=1"`
+	Providers []FederatedProviderParams `mapstructure:"providers" validate:"dive"`
+}
+
+// FederatedProviderParams параметры клиента у вышестоящего провайдера. RedirectURL
+// должен вести на фронтенд, который передаст code и state в CompleteFederatedLogin
+type FederatedProviderParams struct {
+	Name         string   `mapstructure:"name" validate:"required,alphanum"`
+	Issuer       string   `mapstructure:"issuer" validate:"required,url"`
+	ClientID     string   `mapstructure:"client_id" validate:"required"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url" validate:"required,url"`
+	Scopes       []string `mapstructure:"scopes"`
+	// AllowSignup создавать пользователя при первом входе
+	AllowSignup bool `mapstructure:"allow_signup"`
+	// LinkByEmail привязывать к пользователю с тем же подтвержденным email
+	LinkByEmail bool `mapstructure:"link_by_email"`
 }
 
 // DBParams содержит параметры подключения к базе данных
@@ -76,14 +263,59 @@ func (db *DBParams) DSN() string {
 // EnvBindings возвращает мапу ключей конфигурации и соответствующих им переменных окружения
 func envBindings() map[string]string {
 	return map[string]string{
-		envKey:            "SERVICE_KEY",
-		hostKey:           "DB_HOST",
-		portKey:           "DB_PORT",
-		usernameKey:       "DB_USERNAME",
-		passwordKey:       "DB_PASSWORD",
-		dbNameKey:         "DB_NAME",
-		connectTimeoutKey: "DB_CONNECT_TIMEOUT",
-		serviceAddress:    "SERVICE_ADDRESS",
+		envKey:               "SERVICE_KEY",
+		hostKey:              "DB_HOST",
+		portKey:              "DB_PORT",
+		usernameKey:          "DB_USERNAME",
+		passwordKey:          "DB_PASSWORD",
+		dbNameKey:            "DB_NAME",
+		connectTimeoutKey:    "DB_CONNECT_TIMEOUT",
+		serviceAddress:       "SERVICE_ADDRESS",
+		encryptionKey:        "ENCRYPTION_KEY",
+		jwtIssuerKey:         "JWT_ISSUER",
+		accessTTLKey:         "ACCESS_TOKEN_TTL",
+		refreshTTLKey:        "REFRESH_TOKEN_TTL",
+		keyRotationKey:       "KEY_ROTATION_INTERVAL",
+		keyRetentionKey:      "KEY_RETENTION",
+		resetTTLKey:          "PASSWORD_RESET_TTL",
+		verifyTTLKey:         "EMAIL_VERIFICATION_TTL",
+		challengeTTLKey:      "CHALLENGE_TTL",
+		authCodeTTLKey:       "AUTHORIZATION_CODE_TTL",
+		impersonationTTLKey:  "IMPERSONATION_TTL",
+		permissionCacheKey:   "PERMISSION_CACHE_TTL",
+		smtpHostKey:          "SMTP_HOST",
+		smtpPortKey:          "SMTP_PORT",
+		smtpUsernameKey:      "SMTP_USERNAME",
+		smtpPasswordKey:      "SMTP_PASSWORD",
+		mailFromKey:          "MAIL_FROM",
+		appBaseURLKey:        "APP_BASE_URL",
+		maxAttemptsKey:       "LOCKOUT_MAX_ATTEMPTS",
+		baseDelayKey:         "LOCKOUT_BASE_DELAY",
+		maxDelayKey:          "LOCKOUT_MAX_DELAY",
+		pwMinLengthKey:       "PASSWORD_MIN_LENGTH",
+		pwMaxLengthKey:       "PASSWORD_MAX_LENGTH",
+		pwUpperKey:           "PASSWORD_REQUIRE_UPPER",
+		pwLowerKey:           "PASSWORD_REQUIRE_LOWER",
+		pwDigitKey:           "PASSWORD_REQUIRE_DIGIT",
+		pwSymbolKey:          "PASSWORD_REQUIRE_SYMBOL",
+		pwPersonalKey:        "PASSWORD_FORBID_PERSONAL",
+		pwCommonKey:          "PASSWORD_FORBID_COMMON",
+		hashAlgorithmKey:     "PASSWORD_HASH_ALGORITHM",
+		bcryptCostKey:        "BCRYPT_COST",
+		argon2MemoryKey:      "ARGON2_MEMORY",
+		argon2IterationsKey:  "ARGON2_ITERATIONS",
+		argon2ParallelismKey: "ARGON2_PARALLELISM",
+		historyDepthKey:      "PASSWORD_HISTORY_DEPTH",
+		historyRetentionKey:  "PASSWORD_HISTORY_RETENTION",
+		adminMaxAgeKey:       "ADMIN_PASSWORD_MAX_AGE",
+		userMaxAgeKey:        "USER_PASSWORD_MAX_AGE",
+		federationStateTTL:   "FEDERATION_STATE_TTL",
+		magicLinkTTLKey:      "MAGIC_LINK_TTL",
+		magicLinkWindowKey:   "MAGIC_LINK_WINDOW",
+		magicLinkEmailKey:    "MAGIC_LINK_MAX_PER_EMAIL",
+		magicLinkIPKey:       "MAGIC_LINK_MAX_PER_IP",
+		magicLinkAdminsKey:   "MAGIC_LINK_ALLOW_ADMINS",
+		clientIPHeaderKey:    "CLIENT_IP_HEADER",
 	}
 }
 
@@ -132,5 +364,10 @@ func New() (*AppConfig, error) {
 		return nil, fmt.Errorf("ошибка валидации конфигурации: %w", err)
 	}
 
+	// Ключ шифрования не хранится в репозитории, вне dev его нужно задать явно
+	if config.Auth.EncryptionKey == "" && config.Service.Env != "dev" {
+		return nil, fmt.Errorf("ошибка валидации конфигурации: не задан ключ шифрования (ENCRYPTION_KEY) для окружения %s", config.Service.Env)
+	}
+
 	return &config, nil
 }
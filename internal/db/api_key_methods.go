@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyColumns список колонок api_keys в порядке, в котором их читает scanAPIKey
+const apiKeyColumns = "id, user_id, name, prefix, secret_hash, scopes, last_used_at, expires_at, revoked_at, created_at, " +
+	"(SELECT organization_id FROM users WHERE users.id = api_keys.user_id) AS organization_id"
+
+func (s *PostgresStore) CreateAPIKey(parentCtx context.Context, key *APIKey) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, secret_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		key.UserId,
+		key.Name,
+		key.Prefix,
+		key.SecretHash,
+		key.Scopes,
+		key.ExpiresAt,
+	).Scan(&key.Id, &key.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create api key for user %d: %w", key.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetAPIKeyByID(parentCtx context.Context, id int) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1", id)
+
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("api key %d: %w", id, ErrAPIKeyNotFound)
+		}
+		return nil, fmt.Errorf("failed to get api key %d: %w", id, err)
+	}
+
+	return key, nil
+}
+
+func (s *PostgresStore) GetAPIKeyByPrefix(parentCtx context.Context, prefix string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix)
+
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get api key by prefix: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *PostgresStore) ListAPIKeys(parentCtx context.Context, userID int) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api key rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UpdateAPIKey меняет название и области доступа ключа. Секрет, срок действия
+// и владелец после создания не меняются
+func (s *PostgresStore) UpdateAPIKey(parentCtx context.Context, key *APIKey) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "UPDATE api_keys SET name = $1, scopes = $2 WHERE id = $3", key.Name, key.Scopes, key.Id)
+	if err != nil {
+		return fmt.Errorf("failed to update api key %d: %w", key.Id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("api key %d: %w", key.Id, ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+// RevokeAPIKey отзывает ключ. Возвращает false, если ключ уже был отозван
+func (s *PostgresStore) RevokeAPIKey(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key %d: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// TouchAPIKey запоминает время последнего использования ключа
+func (s *PostgresStore) TouchAPIKey(parentCtx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to touch api key %d: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteAPIKey(parentCtx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM api_keys WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete api key %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("api key %d: %w", id, ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+// scanAPIKey читает ключ из строки, выбранной по apiKeyColumns
+func scanAPIKey(row pgx.Row) (*APIKey, error) {
+	key := new(APIKey)
+
+	err := row.Scan(
+		&key.Id,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		&key.SecretHash,
+		&key.Scopes,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+		&key.OrganizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
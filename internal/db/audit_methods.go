@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func (s *PostgresStore) CreateAuditEntry(parentCtx context.Context, entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	details := entry.Details
+	if details == nil {
+		details = map[string]any{}
+	}
+
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	query := `
+		INSERT INTO audit_log (user_id, actor_id, action, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err = s.db.QueryRow(
+		ctx,
+		query,
+		entry.UserId,
+		entry.ActorId,
+		entry.Action,
+		detailsJSON,
+	).Scan(&entry.Id, &entry.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry %s: %w", entry.Action, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateEmailVerificationToken(parentCtx context.Context, token *EmailVerificationToken) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		token.UserId,
+		token.Email,
+		token.TokenHash,
+		token.ExpiresAt,
+	).Scan(&token.Id, &token.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create email verification token for user %d: %w", token.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetEmailVerificationTokenByHash(parentCtx context.Context, hash string) (*EmailVerificationToken, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.user_id, u.organization_id, t.email, t.token_hash, t.expires_at, t.used_at, t.created_at
+		FROM email_verification_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+	`
+
+	token := new(EmailVerificationToken)
+	err := s.db.QueryRow(ctx, query, hash).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.OrganizationId,
+		&token.Email,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get email verification token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkEmailVerificationTokenUsed атомарно помечает токен использованным.
+// Возвращает false, если токен уже использован или истек
+func (s *PostgresStore) MarkEmailVerificationTokenUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE email_verification_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark email verification token %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// MarkEmailVerified отмечает email подтвержденным, только если у пользователя
+// до сих пор тот же адрес, на который отправлялось письмо
+func (s *PostgresStore) MarkEmailVerified(parentCtx context.Context, userID int, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email = $2
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, userID, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark email of user %d verified: %w", userID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,335 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// groupColumns список колонок groups в порядке, в котором их читает scanGroup
+const groupColumns = "id, organization_id, name, description, created_at, updated_at"
+
+func (s *PostgresStore) CreateGroup(parentCtx context.Context, group *Group) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO groups (organization_id, name, description)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, group.OrganizationId, group.Name, group.Description).Scan(&group.Id, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("group %v: %w", group.Name, ErrGroupTaken)
+		}
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetGroup(parentCtx context.Context, orgID, id int) (*Group, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+groupColumns+" FROM groups WHERE id = $1 AND organization_id = $2", id, orgID)
+
+	group, err := scanGroup(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("group %d: %w", id, ErrGroupNotFound)
+		}
+		return nil, fmt.Errorf("failed to get group %d: %w", id, err)
+	}
+
+	return group, nil
+}
+
+func (s *PostgresStore) ListGroups(parentCtx context.Context, orgID, after, limit int) ([]*Group, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := "SELECT " + groupColumns + " FROM groups WHERE organization_id = $1 AND id > $2 ORDER BY id LIMIT $3"
+
+	rows, err := s.db.Query(ctx, query, orgID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+
+	return collectGroups(rows)
+}
+
+// UpdateGroup меняет название и описание группы
+func (s *PostgresStore) UpdateGroup(parentCtx context.Context, group *Group) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE groups
+		SET name = $1, description = $2, updated_at = NOW()
+		WHERE id = $3 AND organization_id = $4
+		RETURNING updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, group.Name, group.Description, group.Id, group.OrganizationId).Scan(&group.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("group %d: %w", group.Id, ErrGroupNotFound)
+		}
+
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("group %v: %w", group.Name, ErrGroupTaken)
+		}
+		return fmt.Errorf("failed to update group %d: %w", group.Id, err)
+	}
+
+	return nil
+}
+
+// DeleteGroup удаляет группу. Участие в ней пользователей и вложенных групп
+// удаляется каскадно, сами пользователи и группы остаются
+func (s *PostgresStore) DeleteGroup(parentCtx context.Context, orgID, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM groups WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to delete group %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("group %d: %w", id, ErrGroupNotFound)
+	}
+
+	return nil
+}
+
+// AddGroupUser добавляет пользователя в группу. Возвращает false, если он уже в ней
+func (s *PostgresStore) AddGroupUser(parentCtx context.Context, groupID, userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO group_members (group_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, groupID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to add user %d to group %d: %w", userID, groupID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) RemoveGroupUser(parentCtx context.Context, groupID, userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove user %d from group %d: %w", userID, groupID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// AddSubgroup вкладывает группу memberGroupID в groupID: группу нельзя вложить
+// в саму себя или в ее потомка. Вложения в одной организации выполняются по
+// очереди под advisory-блокировкой, иначе параллельные A в B и B в A не увидели
+// бы друг друга и замкнули цикл. Возвращает false, если группа уже вложена
+func (s *PostgresStore) AddSubgroup(parentCtx context.Context, groupID, memberGroupID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin nesting group %d in group %d: %w", memberGroupID, groupID, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Проверка на цикл идет следующим запросом, поэтому видит вложения,
+	// сделанные до получения блокировки
+	lock := `
+		SELECT pg_advisory_xact_lock(hashtext('group_nesting'), organization_id)
+		FROM groups
+		WHERE id = $1
+	`
+
+	if _, err := tx.Exec(ctx, lock, groupID); err != nil {
+		return false, fmt.Errorf("failed to lock group nesting of group %d: %w", groupID, err)
+	}
+
+	query := `
+		WITH RECURSIVE descendants(id) AS (
+			SELECT $2::INTEGER
+			UNION
+			SELECT gm.member_group_id
+			FROM group_members gm
+			JOIN descendants d ON gm.group_id = d.id
+			WHERE gm.member_group_id IS NOT NULL
+		), cycle AS (
+			SELECT EXISTS (SELECT 1 FROM descendants WHERE id = $1) AS found
+		), inserted AS (
+			INSERT INTO group_members (group_id, member_group_id)
+			SELECT $1, $2 FROM cycle WHERE NOT cycle.found
+			ON CONFLICT DO NOTHING
+			RETURNING id
+		)
+		SELECT (SELECT found FROM cycle), EXISTS (SELECT 1 FROM inserted)
+	`
+
+	var cycle, added bool
+	if err := tx.QueryRow(ctx, query, groupID, memberGroupID).Scan(&cycle, &added); err != nil {
+		return false, fmt.Errorf("failed to add group %d to group %d: %w", memberGroupID, groupID, err)
+	}
+
+	if cycle {
+		return false, fmt.Errorf("group %d in group %d: %w", memberGroupID, groupID, ErrGroupCycle)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit nesting group %d in group %d: %w", memberGroupID, groupID, err)
+	}
+
+	return added, nil
+}
+
+func (s *PostgresStore) RemoveSubgroup(parentCtx context.Context, groupID, memberGroupID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM group_members WHERE group_id = $1 AND member_group_id = $2", groupID, memberGroupID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove group %d from group %d: %w", memberGroupID, groupID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// ListGroupMembers возвращает прямых участников группы в порядке добавления.
+// after - id записи участия, на которой закончилась предыдущая страница
+func (s *PostgresStore) ListGroupMembers(parentCtx context.Context, groupID, after, limit int) ([]*GroupMember, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT gm.id, gm.group_id, gm.user_id, gm.member_group_id,
+			COALESCE(u.name, g.name), COALESCE(u.email, ''), gm.created_at
+		FROM group_members gm
+		LEFT JOIN users u ON u.id = gm.user_id
+		LEFT JOIN groups g ON g.id = gm.member_group_id
+		WHERE gm.group_id = $1 AND gm.id > $2
+		ORDER BY gm.id
+		LIMIT $3
+	`
+
+	rows, err := s.db.Query(ctx, query, groupID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of group %d: %w", groupID, err)
+	}
+	defer rows.Close()
+
+	members := []*GroupMember{}
+
+	for rows.Next() {
+		member := new(GroupMember)
+
+		err := rows.Scan(
+			&member.Id,
+			&member.GroupId,
+			&member.UserId,
+			&member.MemberGroupId,
+			&member.Name,
+			&member.Email,
+			&member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating group member rows: %w", err)
+	}
+
+	return members, nil
+}
+
+// ListUserGroups возвращает группы пользователя вместе с теми, в которые
+// они вложены на любую глубину. after - id последней группы предыдущей страницы
+func (s *PostgresStore) ListUserGroups(parentCtx context.Context, orgID, userID, after, limit int) ([]*Group, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH RECURSIVE user_groups(id) AS (
+			SELECT group_id FROM group_members WHERE user_id = $1
+			UNION
+			SELECT gm.group_id
+			FROM group_members gm
+			JOIN user_groups ug ON gm.member_group_id = ug.id
+		)
+		SELECT ` + groupColumns + `
+		FROM groups
+		WHERE id IN (SELECT id FROM user_groups) AND organization_id = $2 AND id > $3
+		ORDER BY id
+		LIMIT $4
+	`
+
+	rows, err := s.db.Query(ctx, query, userID, orgID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups of user %d: %w", userID, err)
+	}
+
+	return collectGroups(rows)
+}
+
+// collectGroups читает все строки, выбранные по groupColumns, и закрывает rows
+func collectGroups(rows pgx.Rows) ([]*Group, error) {
+	defer rows.Close()
+
+	groups := []*Group{}
+
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating group rows: %w", err)
+	}
+
+	return groups, nil
+}
+
+func scanGroup(row pgx.Row) (*Group, error) {
+	group := new(Group)
+
+	err := row.Scan(
+		&group.Id,
+		&group.OrganizationId,
+		&group.Name,
+		&group.Description,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
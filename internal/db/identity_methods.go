@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// identityColumns список колонок user_identities в порядке, в котором их читает scanIdentity
+const identityColumns = "id, user_id, provider, subject, email, last_login_at, created_at"
+
+// CreateUserIdentity привязывает внешний аккаунт к пользователю. Если этот аккаунт
+// уже привязан, возвращает ErrIdentityTaken
+func (s *PostgresStore) CreateUserIdentity(parentCtx context.Context, identity *UserIdentity) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		identity.UserId,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(&identity.Id, &identity.CreatedAt)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("identity %s/%s: %w", identity.Provider, identity.Subject, ErrIdentityTaken)
+		}
+		return fmt.Errorf("failed to create identity for user %d: %w", identity.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetUserIdentity(parentCtx context.Context, provider, subject string) (*UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+identityColumns+" FROM user_identities WHERE provider = $1 AND subject = $2", provider, subject)
+
+	identity, err := scanIdentity(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, fmt.Errorf("failed to get identity %s/%s: %w", provider, subject, err)
+	}
+
+	return identity, nil
+}
+
+func (s *PostgresStore) ListUserIdentities(parentCtx context.Context, userID int) ([]*UserIdentity, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+identityColumns+" FROM user_identities WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating identity rows: %w", err)
+	}
+
+	return identities, nil
+}
+
+// TouchUserIdentity запоминает время входа и актуальный email у провайдера
+func (s *PostgresStore) TouchUserIdentity(parentCtx context.Context, id int, email string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "UPDATE user_identities SET last_login_at = NOW(), email = $1 WHERE id = $2", email, id); err != nil {
+		return fmt.Errorf("failed to touch identity %d: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteUserIdentity(parentCtx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM user_identities WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("identity %d: %w", id, ErrIdentityNotFound)
+	}
+
+	return nil
+}
+
+// scanIdentity читает привязку из строки, выбранной по identityColumns
+func scanIdentity(row pgx.Row) (*UserIdentity, error) {
+	identity := new(UserIdentity)
+
+	err := row.Scan(
+		&identity.Id,
+		&identity.UserId,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LastLoginAt,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateImpersonationSession(parentCtx context.Context, session *ImpersonationSession) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO impersonation_sessions (session_id, user_id, actor_id, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		session.SessionId,
+		session.UserId,
+		session.ActorId,
+		session.Reason,
+		session.ExpiresAt,
+	).Scan(&session.Id, &session.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create impersonation session for user %d: %w", session.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetImpersonationSession(parentCtx context.Context, sessionID string) (*ImpersonationSession, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, session_id, user_id, actor_id, reason, expires_at, ended_at, created_at
+		FROM impersonation_sessions
+		WHERE session_id = $1
+	`
+
+	session := new(ImpersonationSession)
+	err := s.db.QueryRow(ctx, query, sessionID).Scan(
+		&session.Id,
+		&session.SessionId,
+		&session.UserId,
+		&session.ActorId,
+		&session.Reason,
+		&session.ExpiresAt,
+		&session.EndedAt,
+		&session.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrImpersonationNotFound
+		}
+		return nil, fmt.Errorf("failed to get impersonation session: %w", err)
+	}
+
+	return session, nil
+}
+
+// EndImpersonationSession завершает сессию досрочно.
+// Возвращает false, если сессия уже завершена или истекла
+func (s *PostgresStore) EndImpersonationSession(parentCtx context.Context, sessionID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE impersonation_sessions
+		SET ended_at = NOW()
+		WHERE session_id = $1 AND ended_at IS NULL AND expires_at > NOW()
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to end impersonation session %s: %w", sessionID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
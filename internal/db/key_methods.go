@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (s *PostgresStore) CreateSigningKey(parentCtx context.Context, key *SigningKey) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, public_key, status, activated_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $5 = 'active' THEN NOW() END)
+		RETURNING id, created_at, activated_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		key.Kid,
+		key.Algorithm,
+		key.PrivateKey,
+		key.PublicKey,
+		key.Status,
+	).Scan(&key.Id, &key.CreatedAt, &key.ActivatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create signing key %s: %w", key.Kid, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetSigningKeys(parentCtx context.Context) ([]*SigningKey, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, kid, algorithm, private_key, public_key, status, created_at, activated_at, retired_at
+		FROM signing_keys
+		ORDER BY created_at
+	`
+
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*SigningKey{}
+
+	for rows.Next() {
+		key := new(SigningKey)
+		err := rows.Scan(
+			&key.Id,
+			&key.Kid,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.PublicKey,
+			&key.Status,
+			&key.CreatedAt,
+			&key.ActivatedAt,
+			&key.RetiredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating signing key rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RotateSigningKeys одним запросом выводит из работы активный ключ, активирует
+// следующий и добавляет новый next. Ротация выполняется, только если активный
+// ключ активирован раньше activatedBefore, поэтому при нескольких репликах
+// ротацию выполнит ровно одна из них. Возвращает true, если ротация произошла
+func (s *PostgresStore) RotateSigningKeys(parentCtx context.Context, next *SigningKey, activatedBefore time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH retired AS (
+			UPDATE signing_keys
+			SET status = 'retired', retired_at = NOW()
+			WHERE status = 'active' AND activated_at < $5
+			RETURNING id
+		), activated AS (
+			UPDATE signing_keys
+			SET status = 'active', activated_at = NOW()
+			WHERE status = 'next' AND EXISTS (SELECT 1 FROM retired)
+			RETURNING id
+		)
+		INSERT INTO signing_keys (kid, algorithm, private_key, public_key, status)
+		SELECT $1, $2, $3, $4, 'next'
+		WHERE EXISTS (SELECT 1 FROM activated)
+		RETURNING id, created_at
+	`
+
+	rows, err := s.db.Query(
+		ctx,
+		query,
+		next.Kid,
+		next.Algorithm,
+		next.PrivateKey,
+		next.PublicKey,
+		activatedBefore,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to rotate signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	rotated := false
+	for rows.Next() {
+		if err := rows.Scan(&next.Id, &next.CreatedAt); err != nil {
+			return false, err
+		}
+		next.Status = KeyStatusNext
+		rotated = true
+	}
+	if err = rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to rotate signing keys: %w", err)
+	}
+
+	return rotated, nil
+}
+
+func (s *PostgresStore) DeleteRetiredSigningKeys(parentCtx context.Context, retiredBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(
+		ctx,
+		"DELETE FROM signing_keys WHERE status = 'retired' AND retired_at < $1",
+		retiredBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete retired signing keys: %w", err)
+	}
+
+	return cmdTag.RowsAffected(), nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RecordFailedLogin атомарно увеличивает счетчик неудачных попыток входа
+// и возвращает его новое значение
+func (s *PostgresStore) RecordFailedLogin(parentCtx context.Context, orgID, id int) (int, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET failed_login_attempts = failed_login_attempts + 1
+		WHERE id = $1 AND organization_id = $2
+		RETURNING failed_login_attempts
+	`
+
+	var attempts int
+	if err := s.db.QueryRow(ctx, query, id, orgID).Scan(&attempts); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+		}
+		return 0, fmt.Errorf("failed to record failed login of user %d: %w", id, err)
+	}
+
+	return attempts, nil
+}
+
+// LockUser запрещает вход до момента until. Более долгую блокировку,
+// выставленную параллельным запросом, не сокращает
+func (s *PostgresStore) LockUser(parentCtx context.Context, orgID, id int, until time.Time) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET locked_until = GREATEST(COALESCE(locked_until, $2), $2)
+		WHERE id = $1 AND organization_id = $3
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id, until, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to lock user %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// ResetFailedLogins сбрасывает счетчик неудачных попыток и снимает блокировку
+func (s *PostgresStore) ResetFailedLogins(parentCtx context.Context, orgID, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1 AND organization_id = $2", id, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to reset failed logins of user %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("user %d: %w", id, ErrUserNotFound)
+	}
+
+	return nil
+}
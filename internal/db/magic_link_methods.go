@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateMagicLinkToken(parentCtx context.Context, token *MagicLinkToken) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO magic_link_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		token.UserId,
+		token.Email,
+		token.TokenHash,
+		token.ExpiresAt,
+	).Scan(&token.Id, &token.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create magic link token for user %d: %w", token.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetMagicLinkTokenByHash(parentCtx context.Context, hash string) (*MagicLinkToken, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.user_id, u.organization_id, t.email, t.token_hash, t.expires_at, t.used_at, t.created_at
+		FROM magic_link_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+	`
+
+	token := new(MagicLinkToken)
+	err := s.db.QueryRow(ctx, query, hash).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.OrganizationId,
+		&token.Email,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get magic link token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkMagicLinkTokenUsed атомарно помечает ссылку использованной.
+// Возвращает false, если ссылка уже использована или истекла
+func (s *PostgresStore) MarkMagicLinkTokenUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE magic_link_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark magic link token %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// InvalidateMagicLinkTokens гасит все неиспользованные ссылки пользователя
+func (s *PostgresStore) InvalidateMagicLinkTokens(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE magic_link_tokens
+		SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to invalidate magic link tokens of user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) RecordMagicLinkRequest(parentCtx context.Context, email, ip string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "INSERT INTO magic_link_requests (email, ip) VALUES ($1, $2)", email, ip); err != nil {
+		return fmt.Errorf("failed to record magic link request: %w", err)
+	}
+
+	return nil
+}
+
+// CountMagicLinkRequests считает запросы ссылок на email и с ip начиная с since
+func (s *PostgresStore) CountMagicLinkRequests(parentCtx context.Context, email, ip string, since time.Time) (int, int, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE email = $1),
+			COUNT(*) FILTER (WHERE ip = $2)
+		FROM magic_link_requests
+		WHERE (email = $1 OR ip = $2) AND created_at >= $3
+	`
+
+	var byEmail, byIP int
+	if err := s.db.QueryRow(ctx, query, email, ip, since).Scan(&byEmail, &byIP); err != nil {
+		return 0, 0, fmt.Errorf("failed to count magic link requests: %w", err)
+	}
+
+	return byEmail, byIP, nil
+}
+
+// PruneMagicLinkRequests удаляет записи о запросах старше before
+func (s *PostgresStore) PruneMagicLinkRequests(parentCtx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM magic_link_requests WHERE created_at < $1", before); err != nil {
+		return fmt.Errorf("failed to prune magic link requests: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) NOT NULL UNIQUE,
+    algorithm VARCHAR(16) NOT NULL,
+    -- Приватный ключ хранится зашифрованным ключом сервиса (AES-GCM)
+    private_key BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('next', 'active', 'retired')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    activated_at TIMESTAMP WITH TIME ZONE,
+    retired_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_signing_keys_status ON signing_keys(status);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    -- Секрет зашифрован ключом сервиса (AES-GCM)
+    secret BYTEA NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    -- Последний принятый временной шаг: код нельзя использовать повторно
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
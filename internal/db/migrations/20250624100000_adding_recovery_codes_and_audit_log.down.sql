@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_audit_log_created_at;
+DROP INDEX IF EXISTS idx_audit_log_user_id;
+DROP TABLE IF EXISTS audit_log;
+DROP INDEX IF EXISTS idx_recovery_codes_user_id;
+DROP TABLE IF EXISTS recovery_codes;
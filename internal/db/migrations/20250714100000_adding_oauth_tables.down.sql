@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_oauth_authorization_codes_user_id;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_clients;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_magic_link_requests_ip;
+DROP INDEX IF EXISTS idx_magic_link_requests_email;
+DROP TABLE IF EXISTS magic_link_requests;
+DROP INDEX IF EXISTS idx_magic_link_tokens_user_id;
+DROP TABLE IF EXISTS magic_link_tokens;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS magic_link_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    -- Адрес, на который ушла ссылка. Если email пользователя сменится, ссылка перестанет работать
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_link_tokens_user_id ON magic_link_tokens(user_id);
+
+-- Каждый запрос ссылки, в том числе на незарегистрированный email, для ограничения частоты
+CREATE TABLE IF NOT EXISTS magic_link_requests (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_magic_link_requests_email ON magic_link_requests(email, created_at);
+CREATE INDEX IF NOT EXISTS idx_magic_link_requests_ip ON magic_link_requests(ip, created_at);
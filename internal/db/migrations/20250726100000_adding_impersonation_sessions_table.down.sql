@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_impersonation_sessions_actor_id;
+DROP INDEX IF EXISTS idx_impersonation_sessions_user_id;
+DROP TABLE IF EXISTS impersonation_sessions;
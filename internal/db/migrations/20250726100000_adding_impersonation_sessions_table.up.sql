@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS impersonation_sessions (
+    id SERIAL PRIMARY KEY,
+    session_id VARCHAR(64) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    actor_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_user_id ON impersonation_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_impersonation_sessions_actor_id ON impersonation_sessions(actor_id);
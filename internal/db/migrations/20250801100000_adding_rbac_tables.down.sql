@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users
+SET is_admin = TRUE
+WHERE id IN (
+    SELECT ur.user_id
+    FROM user_roles ur
+    JOIN roles r ON r.id = ur.role_id
+    WHERE r.name = 'admin'
+);
+
+DROP INDEX IF EXISTS idx_user_roles_role_id;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,70 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'Просмотр пользователей'),
+    ('users:write', 'Создание, изменение и удаление пользователей'),
+    ('users:unlock', 'Снятие блокировки входа'),
+    ('users:impersonate', 'Вход под пользователем'),
+    ('roles:manage', 'Управление ролями и правами'),
+    ('api_keys:manage', 'Управление API-ключами'),
+    ('oauth_clients:manage', 'Управление OAuth-клиентами'),
+    ('tokens:introspect', 'Проверка токенов'),
+    ('audit:read', 'Просмотр журнала аудита'),
+    ('billing:read', 'Просмотр платежей'),
+    ('billing:write', 'Управление платежами')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Полный доступ'),
+    ('support', 'Поддержка пользователей'),
+    ('billing', 'Платежи'),
+    ('auditor', 'Только чтение и журнал аудита')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON
+    r.name = 'admin'
+    OR (r.name = 'support' AND p.name IN ('users:read', 'users:unlock', 'users:impersonate'))
+    OR (r.name = 'billing' AND p.name IN ('users:read', 'billing:read', 'billing:write'))
+    OR (r.name = 'auditor' AND p.name IN ('users:read', 'audit:read'))
+ON CONFLICT DO NOTHING;
+
+-- Администраторы получают роль admin, после чего флаг больше не нужен
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id
+FROM users u
+JOIN roles r ON r.name = 'admin'
+WHERE u.is_admin
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
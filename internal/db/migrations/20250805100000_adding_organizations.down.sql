@@ -0,0 +1,10 @@
+DELETE FROM permissions WHERE name = 'organizations:manage';
+
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS organization_id;
+
+-- Вернуть глобальную уникальность можно, только если адреса не повторяются
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_organization_email_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN IF EXISTS organization_id;
+
+DROP TABLE IF EXISTS organizations;
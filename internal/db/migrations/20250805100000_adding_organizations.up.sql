@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Организация по умолчанию: в ней все существующие пользователи и клиенты
+-- и все запросы, в которых организация не указана
+INSERT INTO organizations (id, slug, name) VALUES (1, 'default', 'Default')
+ON CONFLICT (id) DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('organizations', 'id'), GREATEST((SELECT MAX(id) FROM organizations), 1));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE users ALTER COLUMN organization_id DROP DEFAULT;
+
+-- Email уникален в пределах организации
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_organization_email_key UNIQUE (organization_id, email);
+
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations(id);
+ALTER TABLE oauth_clients ALTER COLUMN organization_id DROP DEFAULT;
+
+INSERT INTO permissions (name, description) VALUES
+    ('organizations:manage', 'Управление организациями')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'organizations:manage'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE name = 'groups:manage';
+
+DROP INDEX IF EXISTS idx_group_members_member_group_id;
+DROP INDEX IF EXISTS idx_group_members_user_id;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
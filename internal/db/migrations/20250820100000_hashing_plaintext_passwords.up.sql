@@ -0,0 +1,7 @@
+-- Пароли, записанные открытым текстом, хеширует команда
+-- go run ./cmd/rehash-passwords, ее нужно запустить до этой миграции.
+-- Команда хеширует их текущим алгоритмом сервиса с его параметрами.
+-- Хешем считается строка с префиксом одного из поддерживаемых форматов, пустой
+-- пароль остается у сервисных аккаунтов и пользователей внешних провайдеров
+ALTER TABLE users ADD CONSTRAINT users_password_hashed
+    CHECK (password = '' OR password ~ '^\$(argon2id|2[aby]|sha256-salted|pbkdf2-sha256|1)\$');
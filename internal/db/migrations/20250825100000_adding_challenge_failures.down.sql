@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_challenge_failures_expires_at;
+DROP TABLE IF EXISTS challenge_failures;
@@ -0,0 +1,10 @@
+-- Неверные коды второго фактора по каждому challenge-токену. После нескольких
+-- ошибок challenge перестает приниматься, и вход нужно начинать заново
+CREATE TABLE IF NOT EXISTS challenge_failures (
+    challenge_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    failures INTEGER NOT NULL DEFAULT 1,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_challenge_failures_expires_at ON challenge_failures(expires_at);
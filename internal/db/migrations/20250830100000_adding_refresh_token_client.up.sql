@@ -0,0 +1,3 @@
+-- Refresh-токены, выданные через OpenID Connect, обменивает только тот клиент,
+-- которому они выданы. У токенов обычного входа клиента нет
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE;
@@ -0,0 +1,10 @@
+-- Организация сессии запоминается при входе, чтобы продление не зависело
+-- от заголовка x-organization в запросе
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS organization_id INTEGER REFERENCES organizations(id);
+
+UPDATE refresh_tokens rt
+SET organization_id = u.organization_id
+FROM users u
+WHERE u.id = rt.user_id AND rt.organization_id IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN organization_id SET NOT NULL;
@@ -0,0 +1,3 @@
+-- Области доступа, выданные OAuth-клиенту, переходят в access-токены при
+-- каждом продлении сессии. У токенов обычного входа областей нет
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
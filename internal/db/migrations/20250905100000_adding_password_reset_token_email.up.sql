@@ -0,0 +1,5 @@
+-- Адрес, на который ушла ссылка сброса. Если email пользователя сменится,
+-- ссылка перестанет работать. У выданных ранее токенов адреса нет, и они
+-- больше не принимаются
+ALTER TABLE password_reset_tokens ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE password_reset_tokens ALTER COLUMN email DROP DEFAULT;
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// oauthClientColumns список колонок oauth_clients в порядке, в котором их читает scanOAuthClient
+const oauthClientColumns = "id, client_id, name, organization_id, secret_hash, redirect_uris, created_at, updated_at"
+
+func (s *PostgresStore) CreateOAuthClient(parentCtx context.Context, client *OAuthClient) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO oauth_clients (client_id, name, organization_id, secret_hash, redirect_uris)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		client.ClientId,
+		client.Name,
+		client.OrganizationId,
+		client.SecretHash,
+		client.RedirectURIs,
+	).Scan(&client.Id, &client.CreatedAt, &client.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create oauth client %q: %w", client.ClientId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetOAuthClient(parentCtx context.Context, clientID string) (*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE client_id = $1", clientID)
+
+	client, err := scanOAuthClient(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth client %q: %w", clientID, err)
+	}
+
+	return client, nil
+}
+
+func (s *PostgresStore) ListOAuthClients(parentCtx context.Context, orgID int) ([]*OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE organization_id = $1 ORDER BY id", orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth clients: %w", err)
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating oauth client rows: %w", err)
+	}
+
+	return clients, nil
+}
+
+// UpdateOAuthClient меняет название и разрешенные redirect_uri. Секрет после
+// создания не меняется
+func (s *PostgresStore) UpdateOAuthClient(parentCtx context.Context, client *OAuthClient) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE oauth_clients
+		SET name = $1, redirect_uris = $2, updated_at = NOW()
+		WHERE client_id = $3
+		RETURNING updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, client.Name, client.RedirectURIs, client.ClientId).Scan(&client.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("oauth client %q: %w", client.ClientId, ErrOAuthClientNotFound)
+		}
+		return fmt.Errorf("failed to update oauth client %q: %w", client.ClientId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteOAuthClient(parentCtx context.Context, clientID string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM oauth_clients WHERE client_id = $1", clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth client %q: %w", clientID, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("oauth client %q: %w", clientID, ErrOAuthClientNotFound)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) CreateAuthorizationCode(parentCtx context.Context, code *AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO oauth_authorization_codes
+			(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		code.CodeHash,
+		code.ClientId,
+		code.UserId,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.AuthTime,
+		code.ExpiresAt,
+	).Scan(&code.Id, &code.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create authorization code for user %d: %w", code.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetAuthorizationCodeByHash(parentCtx context.Context, hash string) (*AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+			auth_time, expires_at, used_at, created_at
+		FROM oauth_authorization_codes
+		WHERE code_hash = $1
+	`
+
+	code := new(AuthorizationCode)
+	err := s.db.QueryRow(ctx, query, hash).Scan(
+		&code.Id,
+		&code.CodeHash,
+		&code.ClientId,
+		&code.UserId,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&code.UsedAt,
+		&code.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	return code, nil
+}
+
+// MarkAuthorizationCodeUsed атомарно помечает код использованным.
+// Возвращает false, если код уже обменян или истек
+func (s *PostgresStore) MarkAuthorizationCodeUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE oauth_authorization_codes
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark authorization code %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// scanOAuthClient читает клиента из строки, выбранной по oauthClientColumns
+func scanOAuthClient(row pgx.Row) (*OAuthClient, error) {
+	client := new(OAuthClient)
+
+	err := row.Scan(
+		&client.Id,
+		&client.ClientId,
+		&client.Name,
+		&client.OrganizationId,
+		&client.SecretHash,
+		&client.RedirectURIs,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
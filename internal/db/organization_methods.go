@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// organizationColumns список колонок organizations в порядке, в котором их читает scanOrganization
+const organizationColumns = "id, slug, name, created_at, updated_at"
+
+func (s *PostgresStore) CreateOrganization(parentCtx context.Context, org *Organization) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO organizations (slug, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, org.Slug, org.Name).Scan(&org.Id, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("organization %v: %w", org.Slug, ErrOrganizationTaken)
+		}
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetOrganization(parentCtx context.Context, id int) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+organizationColumns+" FROM organizations WHERE id = $1", id)
+
+	org, err := scanOrganization(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("organization %d: %w", id, ErrOrganizationNotFound)
+		}
+		return nil, fmt.Errorf("failed to get organization %d: %w", id, err)
+	}
+
+	return org, nil
+}
+
+func (s *PostgresStore) GetOrganizationBySlug(parentCtx context.Context, slug string) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+organizationColumns+" FROM organizations WHERE slug = $1", slug)
+
+	org, err := scanOrganization(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("organization %v: %w", slug, ErrOrganizationNotFound)
+		}
+		return nil, fmt.Errorf("failed to get organization %v: %w", slug, err)
+	}
+
+	return org, nil
+}
+
+func (s *PostgresStore) ListOrganizations(parentCtx context.Context) ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+organizationColumns+" FROM organizations ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating organization rows: %w", err)
+	}
+
+	return orgs, nil
+}
+
+// UpdateOrganization обновляет название организации. Slug не меняется:
+// по нему клиенты указывают организацию в запросах
+func (s *PostgresStore) UpdateOrganization(parentCtx context.Context, org *Organization) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE organizations
+		SET name = $1, updated_at = NOW()
+		WHERE id = $2
+		RETURNING updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, org.Name, org.Id).Scan(&org.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("organization %d: %w", org.Id, ErrOrganizationNotFound)
+		}
+		return fmt.Errorf("failed to update organization %d: %w", org.Id, err)
+	}
+
+	return nil
+}
+
+// DeleteOrganization удаляет пустую организацию. Пользователей и клиентов
+// нужно удалить заранее, иначе вернется ErrOrganizationNotEmpty
+func (s *PostgresStore) DeleteOrganization(parentCtx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM organizations WHERE id = $1", id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("organization %d: %w", id, ErrOrganizationNotEmpty)
+		}
+		return fmt.Errorf("failed to delete organization %d: %w", id, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("organization %d: %w", id, ErrOrganizationNotFound)
+	}
+
+	return nil
+}
+
+func scanOrganization(row pgx.Row) (*Organization, error) {
+	org := new(Organization)
+
+	err := row.Scan(
+		&org.Id,
+		&org.Slug,
+		&org.Name,
+		&org.CreatedAt,
+		&org.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func (s *PostgresStore) AddPasswordHistory(parentCtx context.Context, userID int, hash string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO password_history (user_id, password_hash)
+		VALUES ($1, $2)
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID, hash); err != nil {
+		return fmt.Errorf("failed to add password history of user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+// GetPasswordHistory возвращает хеши не более limit последних паролей, заданных после since
+func (s *PostgresStore) GetPasswordHistory(parentCtx context.Context, userID, limit int, since time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT password_hash
+		FROM password_history
+		WHERE user_id = $1 AND created_at > $3
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.Query(ctx, query, userID, limit, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password history of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating password history rows: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// PrunePasswordHistory оставляет не более keep последних записей и удаляет записи старше before
+func (s *PostgresStore) PrunePasswordHistory(parentCtx context.Context, userID, keep int, before time.Time) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND (
+			created_at < $3 OR id NOT IN (
+				SELECT id FROM password_history
+				WHERE user_id = $1
+				ORDER BY created_at DESC, id DESC
+				LIMIT $2
+			)
+		)
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID, keep, before); err != nil {
+		return fmt.Errorf("failed to prune password history of user %d: %w", userID, err)
+	}
+
+	return nil
+}
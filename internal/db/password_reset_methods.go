@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreatePasswordResetToken(parentCtx context.Context, token *PasswordResetToken) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO password_reset_tokens (user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		token.UserId,
+		token.Email,
+		token.TokenHash,
+		token.ExpiresAt,
+	).Scan(&token.Id, &token.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create password reset token for user %d: %w", token.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetPasswordResetTokenByHash(parentCtx context.Context, hash string) (*PasswordResetToken, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.user_id, u.organization_id, t.email, t.token_hash, t.expires_at, t.used_at, t.created_at
+		FROM password_reset_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+	`
+
+	token := new(PasswordResetToken)
+	err := s.db.QueryRow(ctx, query, hash).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.OrganizationId,
+		&token.Email,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get password reset token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkPasswordResetTokenUsed атомарно помечает токен использованным.
+// Возвращает false, если токен уже использован или истек
+func (s *PostgresStore) MarkPasswordResetTokenUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark password reset token %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// InvalidatePasswordResetTokens гасит все неиспользованные токены пользователя
+func (s *PostgresStore) InvalidatePasswordResetTokens(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to invalidate password reset tokens of user %d: %w", userID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplaceRecoveryCodes одним запросом удаляет старые коды пользователя и сохраняет новые
+func (s *PostgresStore) ReplaceRecoveryCodes(parentCtx context.Context, userID int, hashes []string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH deleted AS (
+			DELETE FROM recovery_codes WHERE user_id = $1
+		)
+		INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID, hashes); err != nil {
+		return fmt.Errorf("failed to replace recovery codes of user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetUnusedRecoveryCodes(parentCtx context.Context, userID int) ([]*RecoveryCode, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, code_hash, used_at, created_at
+		FROM recovery_codes
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recovery codes of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	codes := []*RecoveryCode{}
+
+	for rows.Next() {
+		code := new(RecoveryCode)
+		if err := rows.Scan(&code.Id, &code.UserId, &code.CodeHash, &code.UsedAt, &code.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recovery code rows: %w", err)
+	}
+
+	return codes, nil
+}
+
+func (s *PostgresStore) CountUnusedRecoveryCodes(parentCtx context.Context, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRow(
+		ctx,
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes of user %d: %w", userID, err)
+	}
+
+	return count, nil
+}
+
+// MarkRecoveryCodeUsed атомарно гасит код. Возвращает false, если код уже использован
+func (s *PostgresStore) MarkRecoveryCodeUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "UPDATE recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark recovery code %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) DeleteRecoveryCodes(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes of user %d: %w", userID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// roleColumns список колонок roles в порядке, в котором их читает scanRole
+const roleColumns = `id, name, description, created_at, updated_at,
+	ARRAY(
+		SELECT p.name FROM role_permissions rp JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role_id = roles.id ORDER BY p.name) AS permissions`
+
+func (s *PostgresStore) CreateRole(parentCtx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, role.Name, role.Description).Scan(&role.Id, &role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("role %v: %w", role.Name, ErrRoleTaken)
+		}
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return s.SetRolePermissions(parentCtx, role.Id, role.Permissions)
+}
+
+func (s *PostgresStore) GetRole(parentCtx context.Context, name string) (*Role, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	row := s.db.QueryRow(ctx, "SELECT "+roleColumns+" FROM roles WHERE name = $1", name)
+
+	role, err := scanRole(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("role %v: %w", name, ErrRoleNotFound)
+		}
+		return nil, fmt.Errorf("failed to get role %v: %w", name, err)
+	}
+
+	return role, nil
+}
+
+func (s *PostgresStore) ListRoles(parentCtx context.Context) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+roleColumns+" FROM roles ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role rows: %w", err)
+	}
+
+	return roles, nil
+}
+
+// UpdateRole обновляет описание роли. Права меняются через SetRolePermissions
+func (s *PostgresStore) UpdateRole(parentCtx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE roles
+		SET description = $1, updated_at = NOW()
+		WHERE id = $2
+		RETURNING updated_at
+	`
+
+	err := s.db.QueryRow(ctx, query, role.Description, role.Id).Scan(&role.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("role %d: %w", role.Id, ErrRoleNotFound)
+		}
+		return fmt.Errorf("failed to update role %d: %w", role.Id, err)
+	}
+
+	return nil
+}
+
+// SetRolePermissions заменяет права роли. Неизвестные права пропускаются,
+// поэтому их нужно проверить заранее
+func (s *PostgresStore) SetRolePermissions(parentCtx context.Context, roleID int, permissions []string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH wanted AS (
+			SELECT id FROM permissions WHERE name = ANY($2)
+		), removed AS (
+			DELETE FROM role_permissions
+			WHERE role_id = $1 AND permission_id NOT IN (SELECT id FROM wanted)
+		)
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, id FROM wanted
+		ON CONFLICT DO NOTHING
+	`
+
+	if _, err := s.db.Exec(ctx, query, roleID, permissions); err != nil {
+		return fmt.Errorf("failed to set permissions of role %d: %w", roleID, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) DeleteRole(parentCtx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM roles WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role %v: %w", name, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("role %v: %w", name, ErrRoleNotFound)
+	}
+
+	return nil
+}
+
+// CreatePermission добавляет право и сразу выдает его роли admin
+func (s *PostgresStore) CreatePermission(parentCtx context.Context, permission *Permission) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH new_permission AS (
+			INSERT INTO permissions (name, description)
+			VALUES ($1, $2)
+			RETURNING id, created_at
+		), admin_grant AS (
+			INSERT INTO role_permissions (role_id, permission_id)
+			SELECT roles.id, new_permission.id FROM roles, new_permission
+			WHERE roles.name = $3
+		)
+		SELECT id, created_at FROM new_permission
+	`
+
+	err := s.db.QueryRow(ctx, query, permission.Name, permission.Description, RoleAdmin).Scan(&permission.Id, &permission.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("permission %v: %w", permission.Name, ErrPermissionTaken)
+		}
+		return fmt.Errorf("failed to create permission: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) ListPermissions(parentCtx context.Context) ([]*Permission, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT id, name, description, created_at FROM permissions ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := []*Permission{}
+
+	for rows.Next() {
+		permission := new(Permission)
+		if err := rows.Scan(&permission.Id, &permission.Name, &permission.Description, &permission.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating permission rows: %w", err)
+	}
+
+	return permissions, nil
+}
+
+func (s *PostgresStore) DeletePermission(parentCtx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM permissions WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete permission %v: %w", name, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return fmt.Errorf("permission %v: %w", name, ErrPermissionNotFound)
+	}
+
+	return nil
+}
+
+// AssignRole назначает роль пользователю. Повторное назначение ничего не меняет
+func (s *PostgresStore) AssignRole(parentCtx context.Context, userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID, roleID); err != nil {
+		return fmt.Errorf("failed to assign role %d to user %d: %w", roleID, userID, err)
+	}
+
+	return nil
+}
+
+// UnassignRole снимает роль с пользователя. Возвращает false, если роли у него не было
+func (s *PostgresStore) UnassignRole(parentCtx context.Context, userID, roleID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, roleID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unassign role %d from user %d: %w", roleID, userID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+// UnassignRoleKeepingLast снимает роль, только если у нее останутся другие
+// обладатели. Строки роли блокируются, поэтому два параллельных снятия не
+// могут оставить роль пустой. Возвращает false, если роли у пользователя не было,
+// и ErrLastRoleMember, если он последний
+func (s *PostgresStore) UnassignRoleKeepingLast(parentCtx context.Context, userID, roleID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		WITH members AS (
+			SELECT user_id FROM user_roles
+			WHERE role_id = $2
+			ORDER BY user_id
+			FOR UPDATE
+		), deleted AS (
+			DELETE FROM user_roles
+			WHERE user_id = $1 AND role_id = $2
+				AND (SELECT COUNT(*) FROM members) > 1
+			RETURNING user_id
+		)
+		SELECT
+			EXISTS (SELECT 1 FROM members WHERE user_id = $1),
+			EXISTS (SELECT 1 FROM deleted)
+	`
+
+	var member, removed bool
+	if err := s.db.QueryRow(ctx, query, userID, roleID).Scan(&member, &removed); err != nil {
+		return false, fmt.Errorf("failed to unassign role %d from user %d: %w", roleID, userID, err)
+	}
+
+	if member && !removed {
+		return false, fmt.Errorf("role %d of user %d: %w", roleID, userID, ErrLastRoleMember)
+	}
+
+	return removed, nil
+}
+
+// ListUserRoles возвращает роли пользователя вместе с их правами
+func (s *PostgresStore) ListUserRoles(parentCtx context.Context, userID int) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT ` + roleColumns + `
+		FROM roles
+		WHERE id IN (SELECT role_id FROM user_roles WHERE user_id = $1)
+		ORDER BY name
+	`
+
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user role rows: %w", err)
+	}
+
+	return roles, nil
+}
+
+// scanRole читает роль из строки, выбранной по roleColumns
+func scanRole(row pgx.Row) (*Role, error) {
+	role := new(Role)
+
+	err := row.Scan(
+		&role.Id,
+		&role.Name,
+		&role.Description,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+		&role.Permissions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
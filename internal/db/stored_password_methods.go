@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StoredPassword значение пароля в том виде, в каком оно лежит в базе:
+// хеш или, у старых записей, открытый текст
+type StoredPassword struct {
+	Id    int
+	Value string
+}
+
+// ListStoredPasswords возвращает не более limit непустых паролей пользователей
+// с id больше afterID. Отличить хеш от открытого текста может только хешер
+func (s *PostgresStore) ListStoredPasswords(parentCtx context.Context, afterID, limit int) ([]*StoredPassword, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, password
+		FROM users
+		WHERE id > $1 AND password <> ''
+		ORDER BY id
+		LIMIT $2
+	`
+
+	return s.listStoredPasswords(ctx, query, afterID, limit)
+}
+
+// ReplacePlaintextPassword заменяет пароль хешем, если он не изменился с
+// момента чтения. Возвращает false, если пароль уже другой
+func (s *PostgresStore) ReplacePlaintextPassword(parentCtx context.Context, id int, plain, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password = $3
+		WHERE id = $1 AND password = $2
+	`
+
+	tag, err := s.db.Exec(ctx, query, id, plain, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash password of user %d: %w", id, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListStoredPasswordHistory возвращает не более limit записей истории паролей
+// с id больше afterID
+func (s *PostgresStore) ListStoredPasswordHistory(parentCtx context.Context, afterID, limit int) ([]*StoredPassword, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, password_hash
+		FROM password_history
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	return s.listStoredPasswords(ctx, query, afterID, limit)
+}
+
+// ReplacePlaintextPasswordHistory заменяет запись истории хешем, если она не
+// изменилась с момента чтения
+func (s *PostgresStore) ReplacePlaintextPasswordHistory(parentCtx context.Context, id int, plain, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE password_history
+		SET password_hash = $3
+		WHERE id = $1 AND password_hash = $2
+	`
+
+	tag, err := s.db.Exec(ctx, query, id, plain, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash password history entry %d: %w", id, err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) listStoredPasswords(ctx context.Context, query string, afterID, limit int) ([]*StoredPassword, error) {
+	rows, err := s.db.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored passwords: %w", err)
+	}
+	defer rows.Close()
+
+	passwords := []*StoredPassword{}
+
+	for rows.Next() {
+		p := &StoredPassword{}
+		if err := rows.Scan(&p.Id, &p.Value); err != nil {
+			return nil, err
+		}
+
+		passwords = append(passwords, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stored password rows: %w", err)
+	}
+
+	return passwords, nil
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -9,11 +10,55 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	// ErrUserNotFound возвращается, когда запрашиваемый пользователь отсутствует в базе
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailTaken возвращается, когда пользователь с таким email уже существует
+	ErrEmailTaken = errors.New("email already taken")
+	// ErrTokenNotFound возвращается, когда токен с указанным хешем не найден
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTOTPNotFound возвращается, когда пользователь не начинал подключение 2FA
+	ErrTOTPNotFound = errors.New("totp not found")
+	// ErrAPIKeyNotFound возвращается, когда API-ключ не найден
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrOAuthClientNotFound возвращается, когда OAuth-клиент не зарегистрирован
+	ErrOAuthClientNotFound = errors.New("oauth client not found")
+	// ErrIdentityNotFound возвращается, когда внешний аккаунт не привязан ни к одному пользователю
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityTaken возвращается, когда внешний аккаунт уже привязан
+	ErrIdentityTaken = errors.New("identity already linked")
+	// ErrImpersonationNotFound возвращается, когда сессия входа под пользователем не найдена
+	ErrImpersonationNotFound = errors.New("impersonation session not found")
+	// ErrRoleNotFound возвращается, когда роль не найдена
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleTaken возвращается, когда роль с таким именем уже существует
+	ErrRoleTaken = errors.New("role already exists")
+	// ErrPermissionNotFound возвращается, когда право не найдено
+	ErrPermissionNotFound = errors.New("permission not found")
+	// ErrPermissionTaken возвращается, когда право с таким именем уже существует
+	ErrPermissionTaken = errors.New("permission already exists")
+	// ErrOrganizationNotFound возвращается, когда организация не найдена
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrOrganizationTaken возвращается, когда организация с таким slug уже существует
+	ErrOrganizationTaken = errors.New("organization already exists")
+	// ErrOrganizationNotEmpty возвращается при удалении организации, в которой есть пользователи или клиенты
+	ErrOrganizationNotEmpty = errors.New("organization is not empty")
+	// ErrLastRoleMember возвращается, когда роль снимается с последнего ее обладателя
+	ErrLastRoleMember = errors.New("last member of role")
+	// ErrGroupNotFound возвращается, когда группа не найдена
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupTaken возвращается, когда группа с таким именем уже есть в организации
+	ErrGroupTaken = errors.New("group already exists")
+	// ErrGroupCycle возвращается, когда вложение группы замкнуло бы цикл
+	ErrGroupCycle = errors.New("group nesting cycle")
+)
+
 // Интерфейс для абстракции методов базы данных от pgxpool
 type DBTX interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
 // PostgresStore реализует EventStore с использованием PostgreSQL.
@@ -28,14 +73,212 @@ func NewPosgresStore(pool DBTX) *PostgresStore {
 	}
 }
 
-// UserStore определяет методы для работы с хранилищем пользователей
+// UserStore определяет методы для работы с хранилищем пользователей.
+// Все запросы ограничены организацией: CreateUser и UpdateUser берут ее
+// из User.OrganizationId, остальные методы получают orgID явно
 type UserStore interface {
 	CreateUser(ctx context.Context, user *User) error
 	UpdateUser(ctx context.Context, user *User) error
-	GetUsers(ctx context.Context) ([]*User, error)
-	GetUserByID(ctx context.Context, id int) (*User, error)
-	GetUserByEmail(parentCtx context.Context, email string) (*User, error)
-	DeleteUser(ctx context.Context, id int) error
+	UpdatePassword(ctx context.Context, user *User) error
+	ReplacePasswordHash(ctx context.Context, orgID, id int, oldHash, newHash string) (bool, error)
+	GetUsers(ctx context.Context, orgID int) ([]*User, error)
+	GetUserByID(ctx context.Context, orgID, id int) (*User, error)
+	GetUserByEmail(parentCtx context.Context, orgID int, email string) (*User, error)
+	DeleteUser(ctx context.Context, orgID, id int) error
+	RecordFailedLogin(ctx context.Context, orgID, id int) (int, error)
+	LockUser(ctx context.Context, orgID, id int, until time.Time) error
+	ResetFailedLogins(ctx context.Context, orgID, id int) error
+}
+
+// TokenStore определяет методы для работы с refresh-токенами
+type TokenStore interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID int) error
+	IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+}
+
+// KeyStore определяет методы для работы с ключами подписи
+type KeyStore interface {
+	CreateSigningKey(ctx context.Context, key *SigningKey) error
+	GetSigningKeys(ctx context.Context) ([]*SigningKey, error)
+	RotateSigningKeys(ctx context.Context, next *SigningKey, activatedBefore time.Time) (bool, error)
+	DeleteRetiredSigningKeys(ctx context.Context, retiredBefore time.Time) (int64, error)
+}
+
+// PasswordResetStore определяет методы для работы с токенами сброса пароля
+type PasswordResetStore interface {
+	CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error
+	GetPasswordResetTokenByHash(ctx context.Context, hash string) (*PasswordResetToken, error)
+	MarkPasswordResetTokenUsed(ctx context.Context, id int) (bool, error)
+	InvalidatePasswordResetTokens(ctx context.Context, userID int) error
+}
+
+// EmailVerificationStore определяет методы для подтверждения email
+type EmailVerificationStore interface {
+	CreateEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error
+	GetEmailVerificationTokenByHash(ctx context.Context, hash string) (*EmailVerificationToken, error)
+	MarkEmailVerificationTokenUsed(ctx context.Context, id int) (bool, error)
+	MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error)
+}
+
+// TOTPStore определяет методы для работы с двухфакторной аутентификацией
+type TOTPStore interface {
+	UpsertTOTP(ctx context.Context, totp *UserTOTP) error
+	GetTOTP(ctx context.Context, userID int) (*UserTOTP, error)
+	ConfirmTOTP(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	DeleteTOTP(ctx context.Context, userID int) error
+	CountChallengeFailures(ctx context.Context, challengeID string) (int, error)
+	RecordChallengeFailure(ctx context.Context, challengeID string, userID int, expiresAt time.Time) (int, error)
+	PruneChallengeFailures(ctx context.Context, before time.Time) error
+}
+
+// RecoveryCodeStore определяет методы для работы с кодами восстановления
+type RecoveryCodeStore interface {
+	ReplaceRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	GetUnusedRecoveryCodes(ctx context.Context, userID int) ([]*RecoveryCode, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userID int) (int, error)
+	MarkRecoveryCodeUsed(ctx context.Context, id int) (bool, error)
+	DeleteRecoveryCodes(ctx context.Context, userID int) error
+}
+
+// AuditStore определяет методы для работы с журналом аудита
+type AuditStore interface {
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+}
+
+// APIKeyStore определяет методы для работы с API-ключами сервисных аккаунтов
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKeyByID(ctx context.Context, id int) (*APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, userID int) ([]*APIKey, error)
+	UpdateAPIKey(ctx context.Context, key *APIKey) error
+	RevokeAPIKey(ctx context.Context, id int) (bool, error)
+	TouchAPIKey(ctx context.Context, id int) error
+	DeleteAPIKey(ctx context.Context, id int) error
+}
+
+// PasswordHistoryStore определяет методы для работы с историей паролей
+type PasswordHistoryStore interface {
+	AddPasswordHistory(ctx context.Context, userID int, hash string) error
+	GetPasswordHistory(ctx context.Context, userID, limit int, since time.Time) ([]string, error)
+	PrunePasswordHistory(ctx context.Context, userID, keep int, before time.Time) error
+}
+
+// PlaintextPasswordStore определяет методы для разового хеширования паролей,
+// записанных открытым текстом. Сервису они не нужны, поэтому в Store не входят
+type PlaintextPasswordStore interface {
+	ListStoredPasswords(ctx context.Context, afterID, limit int) ([]*StoredPassword, error)
+	ReplacePlaintextPassword(ctx context.Context, id int, plain, hash string) (bool, error)
+	ListStoredPasswordHistory(ctx context.Context, afterID, limit int) ([]*StoredPassword, error)
+	ReplacePlaintextPasswordHistory(ctx context.Context, id int, plain, hash string) (bool, error)
+}
+
+// OAuthStore определяет методы для работы с OAuth-клиентами и кодами авторизации
+type OAuthStore interface {
+	CreateOAuthClient(ctx context.Context, client *OAuthClient) error
+	GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
+	ListOAuthClients(ctx context.Context, orgID int) ([]*OAuthClient, error)
+	UpdateOAuthClient(ctx context.Context, client *OAuthClient) error
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+	CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode) error
+	GetAuthorizationCodeByHash(ctx context.Context, hash string) (*AuthorizationCode, error)
+	MarkAuthorizationCodeUsed(ctx context.Context, id int) (bool, error)
+}
+
+// IdentityStore определяет методы для работы с привязанными внешними аккаунтами
+type IdentityStore interface {
+	CreateUserIdentity(ctx context.Context, identity *UserIdentity) error
+	GetUserIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error)
+	ListUserIdentities(ctx context.Context, userID int) ([]*UserIdentity, error)
+	TouchUserIdentity(ctx context.Context, id int, email string) error
+	DeleteUserIdentity(ctx context.Context, id int) error
+}
+
+// MagicLinkStore определяет методы для входа по одноразовой ссылке
+type MagicLinkStore interface {
+	CreateMagicLinkToken(ctx context.Context, token *MagicLinkToken) error
+	GetMagicLinkTokenByHash(ctx context.Context, hash string) (*MagicLinkToken, error)
+	MarkMagicLinkTokenUsed(ctx context.Context, id int) (bool, error)
+	InvalidateMagicLinkTokens(ctx context.Context, userID int) error
+	RecordMagicLinkRequest(ctx context.Context, email, ip string) error
+	CountMagicLinkRequests(ctx context.Context, email, ip string, since time.Time) (byEmail int, byIP int, err error)
+	PruneMagicLinkRequests(ctx context.Context, before time.Time) error
+}
+
+// ImpersonationStore определяет методы для работы с сессиями входа под пользователем
+type ImpersonationStore interface {
+	CreateImpersonationSession(ctx context.Context, session *ImpersonationSession) error
+	GetImpersonationSession(ctx context.Context, sessionID string) (*ImpersonationSession, error)
+	EndImpersonationSession(ctx context.Context, sessionID string) (bool, error)
+}
+
+// RoleStore определяет методы для работы с ролями, правами и их назначением
+type RoleStore interface {
+	CreateRole(ctx context.Context, role *Role) error
+	GetRole(ctx context.Context, name string) (*Role, error)
+	ListRoles(ctx context.Context) ([]*Role, error)
+	UpdateRole(ctx context.Context, role *Role) error
+	SetRolePermissions(ctx context.Context, roleID int, permissions []string) error
+	DeleteRole(ctx context.Context, name string) error
+	CreatePermission(ctx context.Context, permission *Permission) error
+	ListPermissions(ctx context.Context) ([]*Permission, error)
+	DeletePermission(ctx context.Context, name string) error
+	AssignRole(ctx context.Context, userID, roleID int) error
+	UnassignRole(ctx context.Context, userID, roleID int) (bool, error)
+	UnassignRoleKeepingLast(ctx context.Context, userID, roleID int) (bool, error)
+	ListUserRoles(ctx context.Context, userID int) ([]*Role, error)
+}
+
+// OrganizationStore определяет методы для работы с организациями
+type OrganizationStore interface {
+	CreateOrganization(ctx context.Context, org *Organization) error
+	GetOrganization(ctx context.Context, id int) (*Organization, error)
+	GetOrganizationBySlug(ctx context.Context, slug string) (*Organization, error)
+	ListOrganizations(ctx context.Context) ([]*Organization, error)
+	UpdateOrganization(ctx context.Context, org *Organization) error
+	DeleteOrganization(ctx context.Context, id int) error
+}
+
+// GroupStore определяет методы для работы с группами и их участниками.
+// Списки постраничные: after - id последней записи предыдущей страницы
+type GroupStore interface {
+	CreateGroup(ctx context.Context, group *Group) error
+	GetGroup(ctx context.Context, orgID, id int) (*Group, error)
+	ListGroups(ctx context.Context, orgID, after, limit int) ([]*Group, error)
+	UpdateGroup(ctx context.Context, group *Group) error
+	DeleteGroup(ctx context.Context, orgID, id int) error
+	AddGroupUser(ctx context.Context, groupID, userID int) (bool, error)
+	RemoveGroupUser(ctx context.Context, groupID, userID int) (bool, error)
+	AddSubgroup(ctx context.Context, groupID, memberGroupID int) (bool, error)
+	RemoveSubgroup(ctx context.Context, groupID, memberGroupID int) (bool, error)
+	ListGroupMembers(ctx context.Context, groupID, after, limit int) ([]*GroupMember, error)
+	ListUserGroups(ctx context.Context, orgID, userID, after, limit int) ([]*Group, error)
+}
+
+// Store объединяет все хранилища сервиса
+type Store interface {
+	UserStore
+	TokenStore
+	KeyStore
+	PasswordResetStore
+	EmailVerificationStore
+	TOTPStore
+	RecoveryCodeStore
+	AuditStore
+	APIKeyStore
+	PasswordHistoryStore
+	OAuthStore
+	IdentityStore
+	MagicLinkStore
+	ImpersonationStore
+	RoleStore
+	OrganizationStore
+	GroupStore
 }
 
 // CreatePostgresPool создает и проверяет пул соединений к PostgreSQL.
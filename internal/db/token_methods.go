@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *PostgresStore) CreateRefreshToken(parentCtx context.Context, token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO refresh_tokens (user_id, organization_id, family_id, client_id, scope, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		token.UserId,
+		token.OrganizationId,
+		token.FamilyId,
+		token.ClientId,
+		token.Scope,
+		token.TokenHash,
+		token.ExpiresAt,
+	).Scan(&token.Id, &token.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token for user %d: %w", token.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetRefreshTokenByHash(parentCtx context.Context, hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, organization_id, family_id, client_id, scope, token_hash, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	token := new(RefreshToken)
+	err := s.db.QueryRow(ctx, query, hash).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.OrganizationId,
+		&token.FamilyId,
+		&token.ClientId,
+		&token.Scope,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkRefreshTokenUsed атомарно помечает токен использованным.
+// Возвращает false, если токен уже был использован или отозван
+func (s *PostgresStore) MarkRefreshTokenUsed(parentCtx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token %d used: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) RevokeTokenFamily(parentCtx context.Context, familyID string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := s.db.Exec(ctx, query, familyID); err != nil {
+		return fmt.Errorf("failed to revoke token family %s: %w", familyID, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) RevokeUserTokens(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+
+	if _, err := s.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to revoke tokens of user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+// IsTokenFamilyRevoked сообщает, отозвана ли сессия. Неизвестная сессия
+// считается отозванной
+func (s *PostgresStore) IsTokenFamilyRevoked(parentCtx context.Context, familyID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*) = 0 OR BOOL_OR(revoked_at IS NOT NULL)
+		FROM refresh_tokens
+		WHERE family_id = $1
+	`
+
+	var revoked bool
+	if err := s.db.QueryRow(ctx, query, familyID).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check token family %s: %w", familyID, err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// UpsertTOTP сохраняет новый неподтвержденный секрет. Подтвержденную 2FA
+// перезаписать нельзя: сначала ее нужно отключить
+func (s *PostgresStore) UpsertTOTP(parentCtx context.Context, totp *UserTOTP) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL
+		RETURNING created_at
+	`
+
+	err := s.db.QueryRow(ctx, query, totp.UserId, totp.Secret).Scan(&totp.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("totp for user %d is already confirmed", totp.UserId)
+		}
+		return fmt.Errorf("failed to save totp for user %d: %w", totp.UserId, err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetTOTP(parentCtx context.Context, userID int) (*UserTOTP, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	totp := new(UserTOTP)
+	err := s.db.QueryRow(ctx, query, userID).Scan(
+		&totp.UserId,
+		&totp.Secret,
+		&totp.ConfirmedAt,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTOTPNotFound
+		}
+		return nil, fmt.Errorf("failed to get totp for user %d: %w", userID, err)
+	}
+
+	return totp, nil
+}
+
+func (s *PostgresStore) ConfirmTOTP(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	cmdTag, err := s.db.Exec(ctx, "UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to confirm totp for user %d: %w", userID, err)
+	}
+
+	if cmdTag.RowsAffected() == 0 {
+		return ErrTOTPNotFound
+	}
+
+	return nil
+}
+
+// UseTOTPStep атомарно запоминает использованный шаг. Возвращает false,
+// если код этого или более позднего шага уже принимался
+func (s *PostgresStore) UseTOTPStep(parentCtx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use totp step for user %d: %w", userID, err)
+	}
+
+	return cmdTag.RowsAffected() == 1, nil
+}
+
+func (s *PostgresStore) DeleteTOTP(parentCtx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete totp for user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+// CountChallengeFailures возвращает число неверных кодов, введенных по challenge
+func (s *PostgresStore) CountChallengeFailures(parentCtx context.Context, challengeID string) (int, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := "SELECT COALESCE((SELECT failures FROM challenge_failures WHERE challenge_id = $1), 0)"
+
+	var failures int
+	if err := s.db.QueryRow(ctx, query, challengeID).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to count failures of challenge: %w", err)
+	}
+
+	return failures, nil
+}
+
+// RecordChallengeFailure атомарно учитывает неверный код по challenge
+// и возвращает новое число ошибок
+func (s *PostgresStore) RecordChallengeFailure(parentCtx context.Context, challengeID string, userID int, expiresAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		INSERT INTO challenge_failures (challenge_id, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (challenge_id) DO UPDATE
+		SET failures = challenge_failures.failures + 1
+		RETURNING failures
+	`
+
+	var failures int
+	if err := s.db.QueryRow(ctx, query, challengeID, userID, expiresAt).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record failure of challenge for user %d: %w", userID, err)
+	}
+
+	return failures, nil
+}
+
+// PruneChallengeFailures удаляет записи о challenge, истекших до before
+func (s *PostgresStore) PruneChallengeFailures(parentCtx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM challenge_failures WHERE expires_at < $1", before); err != nil {
+		return fmt.Errorf("failed to prune challenge failures: %w", err)
+	}
+
+	return nil
+}
@@ -4,50 +4,294 @@ import (
 	"time"
 )
 
-type GetUserRes struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
-	IsAdmin bool   `json:"is_admin"`
+type User struct {
+	Id int `json:"id"`
+	// OrganizationId организация, в которой зарегистрирован пользователь.
+	// Email уникален только в ее пределах
+	OrganizationId int    `json:"organization_id"`
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	// Password хеш пароля в одном из форматов password.Hasher. Пустой у
+	// сервисных аккаунтов и пользователей, вошедших через провайдера
+	Password string `json:"-"`
+	// IsAdmin true, если у пользователя есть роль admin. При создании
+	// пользователя с IsAdmin ему назначается эта роль
+	IsAdmin             bool       `json:"is_admin"`
+	IsServiceAccount    bool       `json:"is_service_account"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at"`
+	FailedLoginAttempts int        `json:"failed_login_attempts"`
+	LockedUntil         *time.Time `json:"locked_until"`
+	PasswordChangedAt   time.Time  `json:"password_changed_at"`
+	MustChangePassword  bool       `json:"must_change_password"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	// Roles и Permissions назначенные роли и все права, которые они дают
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
 }
 
-type CreateUserReq struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	IsAdmin  bool   `json:"is_admin"`
+// IsLocked сообщает, заблокирован ли вход пользователя на момент now
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// RefreshToken хранит хеш непрозрачного refresh-токена. Все токены одной сессии
+// объединены общим FamilyID, что позволяет отзывать цепочку ротаций целиком.
+// ClientId и Scope заданы у токенов, выданных OAuth-клиенту
+type RefreshToken struct {
+	Id             int        `json:"id"`
+	UserId         int        `json:"user_id"`
+	OrganizationId int        `json:"organization_id"`
+	FamilyId       string     `json:"family_id"`
+	ClientId       *string    `json:"client_id"`
+	Scope          string     `json:"scope"`
+	TokenHash      string     `json:"-"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
 }
 
-type UpdateUserReq struct {
+// Статусы ключей подписи
+const (
+	KeyStatusNext    = "next"
+	KeyStatusActive  = "active"
+	KeyStatusRetired = "retired"
+)
+
+// SigningKey ключ подписи токенов. Ключ в статусе next уже публикуется в JWKS,
+// active используется для подписи, retired остается в JWKS до истечения выпущенных им токенов
+type SigningKey struct {
+	Id          int        `json:"id"`
+	Kid         string     `json:"kid"`
+	Algorithm   string     `json:"algorithm"`
+	PrivateKey  []byte     `json:"-"`
+	PublicKey   []byte     `json:"public_key"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+}
+
+// PasswordResetToken одноразовый токен сброса пароля. В базе хранится только хеш.
+// OrganizationId берется из пользователя: токен гасится в его организации.
+// Email - адрес, на который ушла ссылка
+type PasswordResetToken struct {
+	Id             int        `json:"id"`
+	UserId         int        `json:"user_id"`
+	OrganizationId int        `json:"organization_id"`
+	Email          string     `json:"email"`
+	TokenHash      string     `json:"-"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// EmailVerificationToken одноразовый токен подтверждения email. В базе хранится только хеш.
+// OrganizationId берется из пользователя: токен гасится в его организации
+type EmailVerificationToken struct {
+	Id             int        `json:"id"`
+	UserId         int        `json:"user_id"`
+	OrganizationId int        `json:"organization_id"`
+	Email          string     `json:"email"`
+	TokenHash      string     `json:"-"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// MagicLinkToken одноразовая ссылка для входа без пароля. В базе хранится только хеш.
+// OrganizationId берется из пользователя: ссылка гасится в его организации
+type MagicLinkToken struct {
+	Id             int        `json:"id"`
+	UserId         int        `json:"user_id"`
+	OrganizationId int        `json:"organization_id"`
+	Email          string     `json:"email"`
+	TokenHash      string     `json:"-"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// UserTOTP настройки двухфакторной аутентификации пользователя.
+// Пока ConfirmedAt пустой, 2FA не включена
+type UserTOTP struct {
+	UserId       int        `json:"user_id"`
+	Secret       []byte     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// RecoveryCode одноразовый код восстановления доступа при потере второго фактора.
+// Хранится хеш, как и пароль
+type RecoveryCode struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Действия, которые пишутся в журнал аудита
+const (
+	AuditRecoveryCodeUsed         = "recovery_code_used"
+	AuditRecoveryCodesRegenerated = "recovery_codes_regenerated"
+	AuditIdentityLinked           = "identity_linked"
+	AuditImpersonationStarted     = "impersonation_started"
+	AuditImpersonationEnded       = "impersonation_ended"
+	AuditImpersonatedCall         = "impersonated_call"
+	AuditRoleAssigned             = "role_assigned"
+	AuditRoleUnassigned           = "role_unassigned"
+	AuditLoginRefusedLocked       = "login_refused_locked"
+)
+
+// AuditEntry запись журнала аудита. UserId - над кем выполнено действие,
+// ActorId - кто его выполнил
+type AuditEntry struct {
+	Id        int64          `json:"id"`
+	UserId    *int           `json:"user_id"`
+	ActorId   *int           `json:"actor_id"`
+	Action    string         `json:"action"`
+	Details   map[string]any `json:"details"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// APIKey долгоживущий ключ сервисного аккаунта. Prefix хранится открыто и
+// показывается в списках, от секретной части хранится только хеш
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// OrganizationId организация сервисного аккаунта, которому выдан ключ
+	OrganizationId int `json:"organization_id"`
+}
+
+// OAuthClient приложение, которое входит через OpenID Connect. У публичного
+// клиента SecretHash пустой, вместо секрета он обязан использовать PKCE
+type OAuthClient struct {
+	Id       int    `json:"id"`
+	ClientId string `json:"client_id"`
 	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	IsAdmin  bool   `json:"is_admin"`
+	// OrganizationId организация, пользователи которой входят в это приложение
+	OrganizationId int       `json:"organization_id"`
+	SecretHash     *string   `json:"-"`
+	RedirectURIs   []string  `json:"redirect_uris"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
-type User struct {
+// IsConfidential сообщает, должен ли клиент предъявлять секрет
+func (c *OAuthClient) IsConfidential() bool {
+	return c.SecretHash != nil
+}
+
+// AuthorizationCode одноразовый код авторизации OAuth 2.0. В базе хранится только хеш.
+// CodeChallenge - S256 от code_verifier, который клиент предъявит при обмене кода
+type AuthorizationCode struct {
+	Id            int        `json:"id"`
+	CodeHash      string     `json:"-"`
+	ClientId      string     `json:"client_id"`
+	UserId        int        `json:"user_id"`
+	RedirectURI   string     `json:"redirect_uri"`
+	Scope         string     `json:"scope"`
+	Nonce         string     `json:"nonce"`
+	CodeChallenge string     `json:"code_challenge"`
+	AuthTime      time.Time  `json:"auth_time"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// UserIdentity аккаунт пользователя у вышестоящего провайдера OpenID Connect.
+// Subject - идентификатор пользователя у провайдера (claim sub)
+type UserIdentity struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ImpersonationSession вход администратора ActorId под пользователем UserId.
+// SessionId совпадает с claim sid выданного access-токена
+type ImpersonationSession struct {
+	Id        int        `json:"id"`
+	SessionId string     `json:"session_id"`
+	UserId    int        `json:"user_id"`
+	ActorId   int        `json:"actor_id"`
+	Reason    string     `json:"reason"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// IsActive сообщает, действует ли сессия на момент now
+func (s *ImpersonationSession) IsActive(now time.Time) bool {
+	return s.EndedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// RoleAdmin роль с полным доступом. Новые права выдаются ей автоматически
+const RoleAdmin = "admin"
+
+// Role именованный набор прав
+type Role struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Permission право на действие, например users:read. Проверяют права как этот
+// сервис, так и другие сервисы, которым права приходят в профиле пользователя
+type Permission struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DefaultOrganizationID организация, к которой относятся запросы без указания организации
+const DefaultOrganizationID = 1
+
+// Organization арендатор: продукт, у которого свои пользователи
+type Organization struct {
 	Id        int       `json:"id"`
+	Slug      string    `json:"slug"`
 	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"password"`
-	IsAdmin   bool      `json:"is_admin"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func NewUser(r *CreateUserReq) *User {
-	return &User{
-		Name:      r.Name,
-		Email:     r.Email,
-		IsAdmin:   r.IsAdmin,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
+// Group набор пользователей и других групп в пределах организации
+type Group struct {
+	Id             int       `json:"id"`
+	OrganizationId int       `json:"organization_id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
-func (u *User) UpdateFromReq(req *UpdateUserReq) {
-	u.Name = req.Name
-	u.Email = req.Email
-	u.Password = req.Password
-	u.IsAdmin = req.IsAdmin
-	u.UpdatedAt = time.Now()
+// GroupMember прямой участник группы: заполнен либо UserId, либо MemberGroupId.
+// Name и Email берутся из пользователя или вложенной группы для вывода списком
+type GroupMember struct {
+	Id            int       `json:"id"`
+	GroupId       int       `json:"group_id"`
+	UserId        *int      `json:"user_id"`
+	MemberGroupId *int      `json:"member_group_id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	CreatedAt     time.Time `json:"created_at"`
 }
@@ -2,35 +2,93 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// userRolesColumn и userPermissionsColumn роли пользователя и права, которые они дают
+const (
+	userRolesColumn = `ARRAY(
+		SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = users.id ORDER BY r.name) AS roles`
+	userPermissionsColumn = `ARRAY(
+		SELECT DISTINCT p.name FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = users.id ORDER BY p.name) AS permissions`
+)
+
+// userColumns список колонок users в порядке, в котором их читает scanUser
+const userColumns = "id, organization_id, name, email, password, is_service_account, email_verified_at, failed_login_attempts, locked_until, password_changed_at, must_change_password, created_at, updated_at, " +
+	userRolesColumn + ", " + userPermissionsColumn
+
 func (s *PostgresStore) CreateUser(parentCtx context.Context, user *User) error {
 	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
 	defer cancel()
 
+	// Администратору роль admin назначается в том же запросе
 	query := `
-		INSERT INTO users (name, email, password, is_admin)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, updated_at
+		WITH new_user AS (
+			INSERT INTO users (organization_id, name, email, password, is_service_account, email_verified_at, must_change_password)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, password_changed_at, created_at, updated_at
+		), admin_role AS (
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT new_user.id, roles.id FROM new_user, roles
+			WHERE $8 AND roles.name = $9
+		)
+		SELECT id, password_changed_at, created_at, updated_at FROM new_user
 	`
 
 	err := s.db.QueryRow(
 		ctx,
 		query,
+		user.OrganizationId,
 		user.Name,
 		user.Email,
 		user.Password,
+		user.IsServiceAccount,
+		user.EmailVerifiedAt,
+		user.MustChangePassword,
 		user.IsAdmin,
-	).Scan(&user.Id, &user.CreatedAt, &user.UpdatedAt)
+		RoleAdmin,
+	).Scan(&user.Id, &user.PasswordChangedAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("user %v: %w", user.Email, ErrEmailTaken)
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return fmt.Errorf("organization %d: %w", user.OrganizationId, ErrOrganizationNotFound)
+		}
 		return fmt.Errorf("Failed to create user: %w", err)
 	}
 
+	if user.IsAdmin {
+		if err := s.loadUserRoles(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadUserRoles перечитывает роли и права пользователя
+func (s *PostgresStore) loadUserRoles(ctx context.Context, user *User) error {
+	query := "SELECT " + userRolesColumn + ", " + userPermissionsColumn + " FROM users WHERE id = $1"
+
+	if err := s.db.QueryRow(ctx, query, user.Id).Scan(&user.Roles, &user.Permissions); err != nil {
+		return fmt.Errorf("failed to load roles of user %d: %w", user.Id, err)
+	}
+
+	user.IsAdmin = slices.Contains(user.Roles, RoleAdmin)
+
 	return nil
 }
 
@@ -41,7 +99,7 @@ func (s *PostgresStore) UpdateUser(parentCtx context.Context, user *User) error
 	var exists bool
 
 	// Проверка есть ли запрашиваемый пользователь
-	err := s.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", user.Id).Scan(&exists)
+	err := s.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND organization_id = $2)", user.Id, user.OrganizationId).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -52,8 +110,9 @@ func (s *PostgresStore) UpdateUser(parentCtx context.Context, user *User) error
 
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password = $3, updated_at = NOW()
-		WHERE id = $4
+		SET name = $1, email = $2, password = $3, email_verified_at = $4,
+			password_changed_at = $5, must_change_password = $6, updated_at = NOW()
+		WHERE id = $7 AND organization_id = $8
 		RETURNING updated_at
 	`
 	err = s.db.QueryRow(
@@ -62,7 +121,11 @@ func (s *PostgresStore) UpdateUser(parentCtx context.Context, user *User) error
 		user.Name,
 		user.Email,
 		user.Password,
-		user.Id).Scan(&user.UpdatedAt)
+		user.EmailVerifiedAt,
+		user.PasswordChangedAt,
+		user.MustChangePassword,
+		user.Id,
+		user.OrganizationId).Scan(&user.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to update user %d: %w", user.Id, err)
 	}
@@ -70,11 +133,62 @@ func (s *PostgresStore) UpdateUser(parentCtx context.Context, user *User) error
 	return err
 }
 
-func (s *PostgresStore) GetUsers(parentCtx context.Context) ([]*User, error) {
+// UpdatePassword одним запросом сохраняет пароль, время его смены и флаг
+// обязательной смены. Остальные поля пользователя не меняются
+func (s *PostgresStore) UpdatePassword(parentCtx context.Context, user *User) error {
 	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
 	defer cancel()
 
-	rows, err := s.db.Query(ctx, "SELECT * FROM users")
+	query := `
+		UPDATE users
+		SET password = $1, password_changed_at = $2, must_change_password = $3, updated_at = NOW()
+		WHERE id = $4 AND organization_id = $5
+		RETURNING updated_at
+	`
+
+	err := s.db.QueryRow(
+		ctx,
+		query,
+		user.Password,
+		user.PasswordChangedAt,
+		user.MustChangePassword,
+		user.Id,
+		user.OrganizationId).Scan(&user.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("user %d: %w", user.Id, ErrUserNotFound)
+		}
+		return fmt.Errorf("failed to update password of user %d: %w", user.Id, err)
+	}
+
+	return nil
+}
+
+// ReplacePasswordHash заменяет хеш пароля тем же паролем в новом формате.
+// Возвращает false, если пароль успели сменить после чтения oldHash
+func (s *PostgresStore) ReplacePasswordHash(parentCtx context.Context, orgID, id int, oldHash, newHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password = $4
+		WHERE id = $1 AND organization_id = $2 AND password = $3
+	`
+
+	cmdTag, err := s.db.Exec(ctx, query, id, orgID, oldHash, newHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to replace password hash of user %d: %w", id, err)
+	}
+
+	return cmdTag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) GetUsers(parentCtx context.Context, orgID int) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+userColumns+" FROM users WHERE organization_id = $1 ORDER BY id", orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +197,7 @@ func (s *PostgresStore) GetUsers(parentCtx context.Context) ([]*User, error) {
 	users := []*User{}
 
 	for rows.Next() {
-		user, err := scanIntoUser(rows)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -97,26 +211,16 @@ func (s *PostgresStore) GetUsers(parentCtx context.Context) ([]*User, error) {
 	return users, nil
 }
 
-func (s *PostgresStore) GetUserByID(parentCtx context.Context, id int) (*User, error) {
+func (s *PostgresStore) GetUserByID(parentCtx context.Context, orgID, id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
 	defer cancel()
 
-	row := s.db.QueryRow(ctx, "SELECT id, name, email, password, is_admin, created_at, updated_at FROM users WHERE id = $1", id)
-
-	user := new(User)
-	err := row.Scan(
-		&user.Id,
-		&user.Name,
-		&user.Email,
-		&user.Password,
-		&user.IsAdmin,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	row := s.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND organization_id = $2", id, orgID)
 
+	user, err := scanUser(row)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("user %d not found", id)
+			return nil, fmt.Errorf("user %d: %w", id, ErrUserNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user by id %d: %w", id, err)
 	}
@@ -124,26 +228,16 @@ func (s *PostgresStore) GetUserByID(parentCtx context.Context, id int) (*User, e
 	return user, nil
 }
 
-func (s *PostgresStore) GetUserByEmail(parentCtx context.Context, email string) (*User, error) {
+func (s *PostgresStore) GetUserByEmail(parentCtx context.Context, orgID int, email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
 	defer cancel()
 
-	row := s.db.QueryRow(ctx, "SELECT id, name, email, password, is_admin, created_at, updated_at FROM users WHERE email = $1", email)
-
-	user := new(User)
-	err := row.Scan(
-		&user.Id,
-		&user.Name,
-		&user.Email,
-		&user.Password,
-		&user.IsAdmin,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	row := s.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1 AND organization_id = $2", email, orgID)
 
+	user, err := scanUser(row)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("user %v not found", email)
+			return nil, fmt.Errorf("user %v: %w", email, ErrUserNotFound)
 		}
 		return nil, fmt.Errorf("failed to get user by email %v: %w", email, err)
 	}
@@ -151,11 +245,11 @@ func (s *PostgresStore) GetUserByEmail(parentCtx context.Context, email string)
 	return user, nil
 }
 
-func (s *PostgresStore) DeleteUser(parentCtx context.Context, id int) error {
+func (s *PostgresStore) DeleteUser(parentCtx context.Context, orgID, id int) error {
 	ctx, cancel := context.WithTimeout(parentCtx, time.Second*3)
 	defer cancel()
 
-	cmdTag, err := s.db.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+	cmdTag, err := s.db.Exec(ctx, "DELETE FROM users WHERE id = $1 AND organization_id = $2", id, orgID)
 	if err != nil {
 		return fmt.Errorf("failedt to delete user %d: %w", id, err)
 	}
@@ -167,21 +261,33 @@ func (s *PostgresStore) DeleteUser(parentCtx context.Context, id int) error {
 	return nil
 }
 
-func scanIntoUser(rows pgx.Rows) (*User, error) {
+// scanUser читает пользователя из строки, выбранной по userColumns.
+// Подходит и для pgx.Row, и для pgx.Rows
+func scanUser(row pgx.Row) (*User, error) {
 	user := new(User)
 
-	err := rows.Scan(
+	err := row.Scan(
 		&user.Id,
+		&user.OrganizationId,
 		&user.Name,
 		&user.Email,
 		&user.Password,
-		&user.IsAdmin,
+		&user.IsServiceAccount,
+		&user.EmailVerifiedAt,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.PasswordChangedAt,
+		&user.MustChangePassword,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Roles,
+		&user.Permissions,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	user.IsAdmin = slices.Contains(user.Roles, RoleAdmin)
+
 	return user, err
 }
@@ -0,0 +1,419 @@
+package federation
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minKeysRefreshInterval не чаще этого JWKS перечитывается из-за неизвестного kid.
+// Иначе токены с выдуманным kid заставляли бы ходить к провайдеру на каждый вход
+const minKeysRefreshInterval = time.Minute
+
+var (
+	// ErrInvalidIDToken возвращается, если ID-токен вышестоящего провайдера не прошел проверку
+	ErrInvalidIDToken = errors.New("invalid id token")
+	// ErrExchangeFailed возвращается, если провайдер отказался обменять код на токены
+	ErrExchangeFailed = errors.New("code exchange failed")
+)
+
+// Identity пользователь, подтвержденный вышестоящим провайдером
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider вышестоящий провайдер OpenID Connect, через который входят партнеры.
+// Интерфейс позволяет подменить провайдера локальной заглушкой
+type Provider interface {
+	// Name короткое имя провайдера из конфигурации
+	Name() string
+	// AuthCodeURL возвращает адрес, на который нужно отправить браузер пользователя
+	AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error)
+	// Exchange обменивает код на токены и возвращает проверенного пользователя
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
+
+// Config параметры подключения к провайдеру. Адреса endpoint'ов берутся из
+// discovery по Issuer, поэтому локальный провайдер подключается сменой одного адреса
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// discoveryDocument нужные нам поля /.well-known/openid-configuration
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider провайдер OpenID Connect с discovery. Discovery и ключи
+// загружаются при первом обращении и кешируются, ключи перечитываются, когда
+// встречается неизвестный kid, но не чаще minKeysRefreshInterval
+type OIDCProvider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+	keys      map[string]*rsa.PublicKey
+	// keysFetchedAt время последней попытки прочитать JWKS
+	keysFetchedAt time.Time
+}
+
+var _ Provider = (*OIDCProvider)(nil)
+
+// NewOIDCProvider создает провайдера. Если client равен nil, используется клиент с таймаутом 10 секунд
+func NewOIDCProvider(config Config, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &OIDCProvider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return doc.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	doc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+
+	status, err := p.doJSON(req, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: status %d, error %q", ErrExchangeFailed, status, tokens.Error)
+	}
+
+	identity, err := p.verifyIDToken(ctx, doc, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Не все провайдеры кладут email в ID-токен, тогда он берется из userinfo
+	if identity.Email == "" && doc.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err := p.fillFromUserinfo(ctx, doc, tokens.AccessToken, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	return identity, nil
+}
+
+// idTokenClaims claims ID-токена, которые нужны для входа
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, doc *discoveryDocument, raw, nonce string) (*Identity, error) {
+	claims := new(idTokenClaims)
+
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, doc, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: empty subject", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Provider:      p.config.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: parseBool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *OIDCProvider) fillFromUserinfo(ctx context.Context, doc *discoveryDocument, accessToken string, identity *Identity) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.UserinfoEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build userinfo request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	status, err := p.doJSON(req, &info)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return fmt.Errorf("userinfo request failed with status %d", status)
+	}
+
+	// Ответ userinfo без совпадающего sub мог быть подменен
+	if info.Subject != identity.Subject {
+		return fmt.Errorf("%w: userinfo subject mismatch", ErrInvalidIDToken)
+	}
+
+	identity.Email = info.Email
+	identity.EmailVerified = parseBool(info.EmailVerified)
+	if identity.Name == "" {
+		identity.Name = info.Name
+	}
+
+	return nil
+}
+
+// parseBool разбирает email_verified: часть провайдеров отдает его строкой
+func parseBool(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	default:
+		return false
+	}
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+
+	doc := new(discoveryDocument)
+
+	status, err := p.doJSON(req, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery of %s failed with status %d", p.config.Issuer, status)
+	}
+
+	// По спецификации issuer в discovery должен точно совпадать с тем, у кого его запросили
+	if doc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match configured %q", doc.Issuer, p.config.Issuer)
+	}
+
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery of %s is missing required endpoints", p.config.Issuer)
+	}
+
+	p.discovery = doc
+
+	return doc, nil
+}
+
+// publicKey возвращает ключ провайдера по kid, при необходимости перечитывая JWKS.
+// Запрос к провайдеру идет без блокировки, чтобы медленный JWKS не задерживал
+// проверку токенов с уже известными ключами
+func (p *OIDCProvider) publicKey(ctx context.Context, doc *discoveryDocument, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+
+	if key, ok := p.keys[kid]; ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+
+	// Попытка занимается под блокировкой, поэтому параллельные входы с неизвестным
+	// kid не перечитывают JWKS одновременно
+	now := time.Now()
+	if now.Sub(p.keysFetchedAt) < minKeysRefreshInterval {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	p.keysFetchedAt = now
+
+	p.mu.Unlock()
+
+	keys, err := p.fetchKeys(ctx, doc.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build jwks request: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	status, err := p.doJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("jwks request failed with status %d", status)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// doJSON выполняет запрос и разбирает JSON-ответ. Тело ограничено 1 МБ
+func (p *OIDCProvider) doJSON(req *http.Request, out any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode response from %s: %w", req.URL.Host, err)
+	}
+
+	return resp.StatusCode, nil
+}
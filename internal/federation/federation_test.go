@@ -0,0 +1,57 @@
+package federation
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublicKeyRefetchInterval(t *testing.T) {
+	var fetches atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"known","n":%q,"e":"AQAB"}]}`,
+			base64.RawURLEncoding.EncodeToString([]byte{0xc5, 0x01, 0x7f}))
+	}))
+	defer srv.Close()
+
+	p := NewOIDCProvider(Config{Name: "test"}, srv.Client())
+	doc := &discoveryDocument{JWKSURI: srv.URL}
+	ctx := context.Background()
+
+	if _, err := p.publicKey(ctx, doc, "known"); err != nil {
+		t.Fatalf("known key: %v", err)
+	}
+
+	if _, err := p.publicKey(ctx, doc, "known"); err != nil {
+		t.Fatalf("cached key: %v", err)
+	}
+
+	for range 5 {
+		if _, err := p.publicKey(ctx, doc, "forged"); err == nil {
+			t.Fatal("unknown kid accepted")
+		}
+	}
+
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("jwks fetched %d times within the interval, want 1", got)
+	}
+
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-minKeysRefreshInterval)
+	p.mu.Unlock()
+
+	if _, err := p.publicKey(ctx, doc, "forged"); err == nil {
+		t.Fatal("unknown kid accepted after refetch")
+	}
+
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("jwks fetched %d times after the interval, want 2", got)
+	}
+}
@@ -0,0 +1,65 @@
+package keys
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+)
+
+// JWK публичный RSA-ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS набор публичных ключей
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает все опубликованные ключи: активный, следующий и выведенные.
+// Следующий ключ публикуется заранее, чтобы проверяющие стороны успели его закешировать
+func (m *Manager) JWKS() JWKS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(m.keys))}
+
+	for _, k := range m.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: Algorithm,
+			Kid: k.ID,
+			N:   base64.RawURLEncoding.EncodeToString(k.Public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.Public.E)).Bytes()),
+		})
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+// Handler возвращает HTTP handler для /.well-known/jwks.json
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		// Короткий кеш: новый next-ключ должен дойти до проверяющих задолго до активации
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(m.JWKS())
+	})
+}
@@ -0,0 +1,294 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	"github.com/rx3lixir/user-service/pkg/logger"
+	"github.com/rx3lixir/user-service/pkg/secret"
+)
+
+// Algorithm алгоритм подписи, который используют все ключи менеджера
+const Algorithm = "RS256"
+
+const rsaKeyBits = 2048
+
+var (
+	// ErrNoActiveKey возвращается, если в наборе нет активного ключа
+	ErrNoActiveKey = errors.New("no active signing key")
+	// ErrUnknownKey возвращается для kid, которого нет в наборе
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// Key расшифрованный ключ подписи
+type Key struct {
+	ID          string
+	Status      string
+	Private     *rsa.PrivateKey
+	Public      *rsa.PublicKey
+	ActivatedAt *time.Time
+}
+
+// Manager хранит набор ключей подписи, публикует их в JWKS и выполняет ротацию.
+// Реализует token.KeyProvider
+type Manager struct {
+	config Config
+	store  db.KeyStore
+	box    *secret.Box
+	log    logger.Logger
+
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key
+}
+
+var _ token.KeyProvider = (*Manager)(nil)
+
+// NewManager создает новый менеджер ключей
+func NewManager(store db.KeyStore, box *secret.Box, log logger.Logger, opts ...Option) *Manager {
+	config := defaultConfig()
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return &Manager{
+		config: config,
+		store:  store,
+		box:    box,
+		log:    log,
+		keys:   make(map[string]*Key),
+	}
+}
+
+// Init создает начальные ключи, если их еще нет, и загружает набор из базы
+func (m *Manager) Init(ctx context.Context) error {
+	stored, err := m.store.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	hasActive, hasNext := false, false
+	for _, k := range stored {
+		switch k.Status {
+		case db.KeyStatusActive:
+			hasActive = true
+		case db.KeyStatusNext:
+			hasNext = true
+		}
+	}
+
+	if !hasActive {
+		if err := m.createKey(ctx, db.KeyStatusActive); err != nil {
+			return err
+		}
+		m.log.Info("Created initial active signing key")
+	}
+
+	if !hasNext {
+		if err := m.createKey(ctx, db.KeyStatusNext); err != nil {
+			return err
+		}
+		m.log.Info("Created next signing key")
+	}
+
+	return m.reload(ctx)
+}
+
+// Run периодически перечитывает ключи, выполняет ротацию и удаляет устаревшие ключи.
+// Блокируется до отмены контекста
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.tick(ctx); err != nil {
+				m.log.Error("Signing key maintenance failed", "error", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) tick(ctx context.Context) error {
+	if err := m.rotateIfDue(ctx); err != nil {
+		return err
+	}
+
+	deleted, err := m.store.DeleteRetiredSigningKeys(ctx, time.Now().Add(-m.config.Retention))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		m.log.Info("Deleted retired signing keys", "count", deleted)
+	}
+
+	return m.reload(ctx)
+}
+
+// rotateIfDue выполняет ротацию, если активный ключ старше интервала ротации
+func (m *Manager) rotateIfDue(ctx context.Context) error {
+	m.mu.RLock()
+	active := m.active
+	m.mu.RUnlock()
+
+	cutoff := time.Now().Add(-m.config.RotationInterval)
+
+	// Ключ генерируем только когда ротация действительно нужна
+	if active != nil && active.ActivatedAt != nil && active.ActivatedAt.After(cutoff) {
+		return nil
+	}
+
+	next, err := m.newKey(db.KeyStatusNext)
+	if err != nil {
+		return err
+	}
+
+	rotated, err := m.store.RotateSigningKeys(ctx, next, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if rotated {
+		m.log.Info("Rotated signing keys", "next_kid", next.Kid)
+	}
+
+	return nil
+}
+
+// reload перечитывает ключи из базы и заменяет набор в памяти
+func (m *Manager) reload(ctx context.Context) error {
+	stored, err := m.store.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*Key, len(stored))
+	var active *Key
+
+	for _, s := range stored {
+		k, err := m.decode(s)
+		if err != nil {
+			return fmt.Errorf("failed to decode signing key %s: %w", s.Kid, err)
+		}
+
+		keys[k.ID] = k
+		if k.Status == db.KeyStatusActive {
+			active = k
+		}
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.active = active
+	m.mu.Unlock()
+
+	return nil
+}
+
+// SigningKey возвращает активный ключ для подписи
+func (m *Manager) SigningKey() (string, *rsa.PrivateKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.active == nil {
+		return "", nil, ErrNoActiveKey
+	}
+
+	return m.active.ID, m.active.Private, nil
+}
+
+// PublicKey возвращает публичный ключ по kid для проверки подписи
+func (m *Manager) PublicKey(kid string) (*rsa.PublicKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+
+	return k.Public, nil
+}
+
+func (m *Manager) createKey(ctx context.Context, status string) error {
+	key, err := m.newKey(status)
+	if err != nil {
+		return err
+	}
+
+	return m.store.CreateSigningKey(ctx, key)
+}
+
+// newKey генерирует RSA-ключ и готовит его к сохранению в базе
+func (m *Manager) newKey(status string) (*db.SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := m.box.Seal(privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := token.RandomString(12)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.SigningKey{
+		Kid:        kid,
+		Algorithm:  Algorithm,
+		PrivateKey: sealed,
+		PublicKey:  publicDER,
+		Status:     status,
+	}, nil
+}
+
+// decode расшифровывает ключ, прочитанный из базы
+func (m *Manager) decode(s *db.SigningKey) (*Key, error) {
+	privateDER, err := m.box.Open(s.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected key type %T", parsed)
+	}
+
+	return &Key{
+		ID:          s.Kid,
+		Status:      s.Status,
+		Private:     private,
+		Public:      &private.PublicKey,
+		ActivatedAt: s.ActivatedAt,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package keys
+
+import "time"
+
+// Config настройки менеджера ключей
+type Config struct {
+	RotationInterval time.Duration
+	Retention        time.Duration
+	RefreshInterval  time.Duration
+}
+
+// Option функция для настройки менеджера ключей
+type Option func(*Config)
+
+// defaultConfig возвращает конфигурацию по умолчанию
+func defaultConfig() Config {
+	return Config{
+		RotationInterval: 30 * 24 * time.Hour,
+		Retention:        24 * time.Hour,
+		RefreshInterval:  time.Minute,
+	}
+}
+
+// WithRotationInterval устанавливает, как долго ключ остается активным
+func WithRotationInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.RotationInterval = interval
+	}
+}
+
+// WithRetention устанавливает, сколько выведенный ключ остается в JWKS.
+// Значение должно превышать время жизни access-токена
+func WithRetention(retention time.Duration) Option {
+	return func(c *Config) {
+		c.Retention = retention
+	}
+}
+
+// WithRefreshInterval устанавливает, как часто ключи перечитываются из базы,
+// чтобы все реплики узнавали о ротации, выполненной другой репликой
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.RefreshInterval = interval
+	}
+}
@@ -0,0 +1,377 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rx3lixir/user-service/internal/db"
+)
+
+// ErrInvalidToken возвращается для любого токена, который не прошел проверку
+var ErrInvalidToken = errors.New("invalid token")
+
+// Назначения токенов, подписанных ключами сервиса. Проверка назначения
+// не дает использовать токен одного вида вместо другого
+const (
+	UseAccess    = "access"
+	UseChallenge = "challenge"
+	UseID        = "id"
+)
+
+// Claims содержимое access-токена
+type Claims struct {
+	UserID int `json:"uid"`
+	// OrganizationID организация пользователя. В токенах, выданных до появления
+	// организаций, отсутствует и означает организацию по умолчанию
+	OrganizationID int    `json:"org,omitempty"`
+	Email          string `json:"email"`
+	IsAdmin        bool   `json:"is_admin"`
+	SessionID      string `json:"sid"`
+	TokenUse       string `json:"token_use"`
+	// Act заполнен, если токен выдан администратору для входа под пользователем
+	Act *Actor `json:"act,omitempty"`
+	// ClientID и Scope заданы у токенов, выданных OAuth-клиенту. Такой токен
+	// дает доступ только к userinfo в пределах выданных областей
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// OrgID возвращает организацию пользователя с учетом старых токенов без claim org
+func (c *Claims) OrgID() int {
+	if c.OrganizationID == 0 {
+		return db.DefaultOrganizationID
+	}
+	return c.OrganizationID
+}
+
+// Actor тот, кто на самом деле действует от имени субъекта токена (claim act, RFC 8693)
+type Actor struct {
+	Subject string `json:"sub"`
+	UserID  int    `json:"uid"`
+}
+
+// ChallengeClaims содержимое токена незавершенного входа, например когда
+// после пароля требуется второй фактор. Purpose указывает, чем вход завершается
+type ChallengeClaims struct {
+	UserID         int    `json:"uid"`
+	OrganizationID int    `json:"org,omitempty"`
+	Purpose        string `json:"purpose"`
+	TokenUse       string `json:"token_use"`
+	jwt.RegisteredClaims
+}
+
+// OrgID возвращает организацию пользователя, которому выдан challenge
+func (c *ChallengeClaims) OrgID() int {
+	if c.OrganizationID == 0 {
+		return db.DefaultOrganizationID
+	}
+	return c.OrganizationID
+}
+
+// IDClaims содержимое ID-токена OpenID Connect. Audience - client_id приложения,
+// Nonce возвращается клиенту без изменений для защиты от повтора
+type IDClaims struct {
+	Nonce         string           `json:"nonce,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	Name          string           `json:"name,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	TokenUse      string           `json:"token_use"`
+	jwt.RegisteredClaims
+}
+
+// KeyProvider отдает ключи подписи. Access-токен подписывается активным ключом,
+// а проверяется ключом, указанным в заголовке kid
+type KeyProvider interface {
+	SigningKey() (kid string, key *rsa.PrivateKey, err error)
+	PublicKey(kid string) (*rsa.PublicKey, error)
+}
+
+// Issuer выпускает и проверяет токены сервиса
+type Issuer struct {
+	keys       KeyProvider
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewIssuer создает новый экземпляр Issuer
+func NewIssuer(keys KeyProvider, issuer string, accessTTL, refreshTTL time.Duration) *Issuer {
+	return &Issuer{
+		keys:       keys,
+		issuer:     issuer,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// Issuer возвращает издателя токенов (claim iss)
+func (i *Issuer) Issuer() string {
+	return i.issuer
+}
+
+// AccessTTL возвращает время жизни access-токена
+func (i *Issuer) AccessTTL() time.Duration {
+	return i.accessTTL
+}
+
+// RefreshTTL возвращает время жизни refresh-токена
+func (i *Issuer) RefreshTTL() time.Duration {
+	return i.refreshTTL
+}
+
+// IssueAccessToken подписывает короткоживущий access-токен для пользователя
+func (i *Issuer) IssueAccessToken(user *db.User, sessionID string) (string, time.Time, error) {
+	return i.issueAccessToken(user, sessionID, "", "")
+}
+
+// IssueClientAccessToken подписывает access-токен, выданный OAuth-клиенту clientID
+// с областями scope. Клиент указывается в claim aud
+func (i *Issuer) IssueClientAccessToken(user *db.User, sessionID, clientID, scope string) (string, time.Time, error) {
+	return i.issueAccessToken(user, sessionID, clientID, scope)
+}
+
+func (i *Issuer) issueAccessToken(user *db.User, sessionID, clientID, scope string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(i.accessTTL)
+
+	jti, err := RandomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := Claims{
+		UserID:         user.Id,
+		OrganizationID: user.OrganizationId,
+		Email:          user.Email,
+		IsAdmin:        user.IsAdmin,
+		SessionID:      sessionID,
+		TokenUse:       UseAccess,
+		ClientID:       clientID,
+		Scope:          scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    i.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	if clientID != "" {
+		claims.Audience = jwt.ClaimStrings{clientID}
+	}
+
+	signed, err := i.sign(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return signed, expiresAt, nil
+}
+
+// IssueImpersonationToken подписывает access-токен пользователя user для администратора
+// actorID. Токен живет до expiresAt и не продлевается: refresh-токен к нему не выдается
+func (i *Issuer) IssueImpersonationToken(user *db.User, actorID int, sessionID string, expiresAt time.Time) (string, error) {
+	now := time.Now()
+
+	jti, err := RandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := Claims{
+		UserID:         user.Id,
+		OrganizationID: user.OrganizationId,
+		Email:          user.Email,
+		IsAdmin:        user.IsAdmin,
+		SessionID:      sessionID,
+		TokenUse:       UseAccess,
+		Act: &Actor{
+			Subject: strconv.Itoa(actorID),
+			UserID:  actorID,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    i.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := i.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign impersonation token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// IssueChallengeToken подписывает короткоживущий токен незавершенного входа
+func (i *Issuer) IssueChallengeToken(user *db.User, purpose string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	jti, err := RandomString(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims := ChallengeClaims{
+		UserID:         user.Id,
+		OrganizationID: user.OrganizationId,
+		Purpose:        purpose,
+		TokenUse:       UseChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    i.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := i.sign(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign challenge token: %w", err)
+	}
+
+	return signed, expiresAt, nil
+}
+
+// IssueIDToken подписывает ID-токен для приложения clientID. Имя и email попадают
+// в токен, только если приложение запросило их областями profile и email
+func (i *Issuer) IssueIDToken(user *db.User, clientID, nonce string, authTime time.Time, profile, email bool) (string, error) {
+	now := time.Now()
+
+	claims := IDClaims{
+		Nonce:    nonce,
+		AuthTime: jwt.NewNumericDate(authTime),
+		TokenUse: UseID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.accessTTL)),
+		},
+	}
+
+	if profile {
+		claims.Name = user.Name
+	}
+
+	if email {
+		verified := user.EmailVerifiedAt != nil
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+
+	signed, err := i.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign id token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// sign подписывает claims активным ключом и указывает его kid в заголовке
+func (i *Issuer) sign(claims jwt.Claims) (string, error) {
+	kid, key, err := i.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = kid
+
+	return t.SignedString(key)
+}
+
+// ParseAccessToken проверяет подпись и срок действия access-токена
+func (i *Issuer) ParseAccessToken(raw string) (*Claims, error) {
+	claims := new(Claims)
+
+	if err := i.parse(raw, claims); err != nil {
+		return nil, err
+	}
+
+	if claims.TokenUse != UseAccess {
+		return nil, fmt.Errorf("%w: not an access token", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// ParseChallengeToken проверяет токен незавершенного входа с ожидаемым назначением
+func (i *Issuer) ParseChallengeToken(raw, purpose string) (*ChallengeClaims, error) {
+	claims := new(ChallengeClaims)
+
+	if err := i.parse(raw, claims); err != nil {
+		return nil, err
+	}
+
+	if claims.TokenUse != UseChallenge || claims.Purpose != purpose {
+		return nil, fmt.Errorf("%w: unexpected challenge purpose", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// parse проверяет подпись, издателя и срок действия токена
+func (i *Issuer) parse(raw string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return i.keys.PublicKey(kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(i.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return nil
+}
+
+// NewOpaqueToken генерирует непрозрачный одноразовый токен (refresh, сброс пароля и т.п.)
+// и его хеш для хранения в базе
+func NewOpaqueToken() (plain string, hash string, err error) {
+	plain, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return plain, HashToken(plain), nil
+}
+
+// HashToken возвращает SHA-256 хеш токена. Токены генерируются случайно и имеют
+// высокую энтропию, поэтому медленный хеш вроде bcrypt здесь не нужен
+func HashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomString возвращает n случайных байт в кодировке base64url
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,76 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+)
+
+// staticKeys отдает один ключ подписи
+type staticKeys struct {
+	key *rsa.PrivateKey
+}
+
+func (k staticKeys) SigningKey() (string, *rsa.PrivateKey, error) {
+	return "test", k.key, nil
+}
+
+func (k staticKeys) PublicKey(kid string) (*rsa.PublicKey, error) {
+	return &k.key.PublicKey, nil
+}
+
+func newTestIssuer(t *testing.T) *Issuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewIssuer(staticKeys{key: key}, "test", time.Minute, time.Hour)
+}
+
+func TestClientAccessToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	user := &db.User{Id: 7, OrganizationId: 1, Email: "alice@example.com"}
+
+	raw, _, err := issuer.IssueClientAccessToken(user, "family", "app", "openid email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.ParseAccessToken(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.ClientID != "app" || claims.Scope != "openid email" {
+		t.Errorf("client_id = %q, scope = %q", claims.ClientID, claims.Scope)
+	}
+
+	if !slices.Equal(claims.Audience, []string{"app"}) {
+		t.Errorf("aud = %v, want [app]", claims.Audience)
+	}
+}
+
+func TestFirstPartyAccessTokenHasNoClient(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	raw, _, err := issuer.IssueAccessToken(&db.User{Id: 7}, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := issuer.ParseAccessToken(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.ClientID != "" || claims.Scope != "" || len(claims.Audience) != 0 {
+		t.Errorf("first-party token carries client claims: %+v", claims)
+	}
+}
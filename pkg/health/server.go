@@ -86,6 +86,11 @@ func (s *Server) setupRoutes() {
 	mux.HandleFunc("/live", s.liveHandler)
 	mux.HandleFunc("/info", s.infoHandler)
 
+	// Дополнительные маршруты
+	for pattern, handler := range s.config.Handlers {
+		mux.Handle(pattern, handler)
+	}
+
 	s.server = &http.Server{
 		Addr:         s.config.Port,
 		Handler:      mux,
@@ -133,6 +138,14 @@ func (s *Server) infoHandler(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	if len(s.config.Handlers) > 0 {
+		extra := make([]string, 0, len(s.config.Handlers))
+		for pattern := range s.config.Handlers {
+			extra = append(extra, pattern)
+		}
+		info["extra_endpoints"] = extra
+	}
+
 	if len(s.config.RequiredTables) > 0 {
 		info["required_tables"] = s.config.RequiredTables
 	}
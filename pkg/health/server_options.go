@@ -1,6 +1,7 @@
 package health
 
 import (
+	"net/http"
 	"time"
 )
 
@@ -13,6 +14,9 @@ type Config struct {
 	RequiredTables   []string
 	MigrationVersion int // 0 = не проверять версию
 
+	// Handlers дополнительные маршруты, которые обслуживаются тем же HTTP сервером
+	Handlers map[string]http.Handler
+
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
@@ -30,6 +34,7 @@ func defaultConfig() Config {
 		Timeout:          5 * time.Second,
 		RequiredTables:   []string{},
 		MigrationVersion: 0, // 0 = не проверять версию
+		Handlers:         map[string]http.Handler{},
 		ReadTimeout:      10 * time.Second,
 		WriteTimeout:     10 * time.Second,
 		IdleTimeout:      60 * time.Second,
@@ -88,3 +93,11 @@ func WithHTTPTimeouts(read, write, idle time.Duration) Option {
 		c.IdleTimeout = idle
 	}
 }
+
+// WithHandler регистрирует дополнительный маршрут на HTTP сервере,
+// например /.well-known/jwks.json
+func WithHandler(pattern string, handler http.Handler) Option {
+	return func(c *Config) {
+		c.Handlers[pattern] = handler
+	}
+}
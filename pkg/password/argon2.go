@@ -0,0 +1,144 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2Params параметры Argon2id. Memory задается в KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params параметры по рекомендации OWASP
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Argon2idHasher хеширует пароли Argon2id в формате PHC:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+// NewArgon2id создает Argon2id с указанными параметрами
+func NewArgon2id(params Argon2Params) *Argon2idHasher {
+	return &Argon2idHasher{params: params}
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.params.Memory,
+		a.params.Iterations,
+		a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), h.salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, uint32(len(h.key)))
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (a *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a *Argon2idHasher) Check(encoded string) error {
+	_, err := parseArgon2id(encoded)
+	return err
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+	h, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return h.version != argon2.Version ||
+		h.params.Memory < a.params.Memory ||
+		h.params.Iterations < a.params.Iterations ||
+		h.params.Parallelism < a.params.Parallelism ||
+		uint32(len(h.salt)) < a.params.SaltLength ||
+		uint32(len(h.key)) < a.params.KeyLength
+}
+
+type argon2idHash struct {
+	version int
+	params  Argon2Params
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id разбирает хеш в формате PHC
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHashFormat
+	}
+
+	h := new(argon2idHash)
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.params.Memory, &h.params.Iterations, &h.params.Parallelism); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+
+	// Параметры берутся из хеша, в том числе импортированного, поэтому
+	// ограничиваются до вызова argon2.IDKey, который выделит всю память сразу
+	p := h.params
+	if p.Memory < 8*uint32(p.Parallelism) || p.Memory > MaxArgon2Memory ||
+		p.Iterations < 1 || p.Iterations > MaxArgon2Iterations ||
+		p.Parallelism < 1 || p.Parallelism > MaxArgon2Parallelism ||
+		len(h.salt) < minSaltLength || len(h.key) < 16 || len(h.key) > maxKeyLength {
+		return nil, fmt.Errorf("argon2id m=%d,t=%d,p=%d: %w", p.Memory, p.Iterations, p.Parallelism, ErrHashParams)
+	}
+
+	return h, nil
+}
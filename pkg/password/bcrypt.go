@@ -0,0 +1,75 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptMaxBytes длиннее bcrypt пароль не принимает
+const bcryptMaxBytes = 72
+
+// BcryptHasher хеширует пароли bcrypt. Пароль длиннее 72 байт не хешируется
+type BcryptHasher struct {
+	cost int
+}
+
+// NewBcrypt создает bcrypt с указанной сложностью
+func NewBcrypt(cost int) *BcryptHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	return HashWithCost(password, b.cost)
+}
+
+func (b *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if err := b.Check(encoded); err != nil {
+		return false, err
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (b *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *BcryptHasher) Check(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+
+	if cost > MaxBcryptCost {
+		return fmt.Errorf("bcrypt cost %d: %w", cost, ErrHashParams)
+	}
+
+	return nil
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+
+	return cost < b.cost
+}
+
+func (b *BcryptHasher) MaxPasswordBytes() int {
+	return bcryptMaxBytes
+}
@@ -0,0 +1,111 @@
+package password
+
+import (
+	"errors"
+)
+
+// ErrUnknownHashFormat возвращается, когда ни один алгоритм не распознал хеш
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// ErrHashParams возвращается для хеша, параметры которого выходят за допустимые
+// пределы. Проверка пароля по такому хешу могла бы занять всю память или процессор
+var ErrHashParams = errors.New("password hash parameters out of range")
+
+// Пределы параметров хешей. Хеши, созданные сервисом, в них укладываются всегда,
+// а импортированные с большими параметрами отклоняются
+const (
+	MaxBcryptCost        = 16
+	MaxArgon2Memory      = 1024 * 1024 // KiB
+	MaxArgon2Iterations  = 16
+	MaxArgon2Parallelism = 16
+	MaxPBKDF2Rounds      = 1_000_000
+	// maxKeyLength и minSaltLength пределы длины ключа и соли в байтах
+	maxKeyLength  = 64
+	minSaltLength = 8
+)
+
+// Hasher алгоритм хеширования паролей. Хеш сам хранит алгоритм и параметры
+// (формат PHC, для bcrypt - его собственный $2b$), поэтому его можно проверить
+// и после смены настроек
+type Hasher interface {
+	// Hash хеширует пароль с текущими параметрами
+	Hash(password string) (string, error)
+	// Verify проверяет пароль. Ошибка означает поврежденный или чужой хеш
+	Verify(password, encoded string) (bool, error)
+	// Supports сообщает, создан ли хеш этим алгоритмом
+	Supports(encoded string) bool
+	// NeedsRehash сообщает, что хеш создан с более слабыми параметрами, чем текущие
+	NeedsRehash(encoded string) bool
+	// Check проверяет, что хеш разбирается и его параметры в допустимых пределах
+	Check(encoded string) error
+}
+
+// Hashers хеширует новые пароли предпочтительным алгоритмом, а проверяет
+// хеши любого из известных. Хеш чужим алгоритмом требует перехеширования
+type Hashers struct {
+	preferred Hasher
+	known     []Hasher
+}
+
+// NewHashers создает набор алгоритмов. legacy нужны только для проверки старых хешей
+func NewHashers(preferred Hasher, legacy ...Hasher) *Hashers {
+	return &Hashers{
+		preferred: preferred,
+		known:     append([]Hasher{preferred}, legacy...),
+	}
+}
+
+func (h *Hashers) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h *Hashers) Verify(password, encoded string) (bool, error) {
+	for _, hasher := range h.known {
+		if hasher.Supports(encoded) {
+			return hasher.Verify(password, encoded)
+		}
+	}
+
+	return false, ErrUnknownHashFormat
+}
+
+func (h *Hashers) Check(encoded string) error {
+	for _, hasher := range h.known {
+		if hasher.Supports(encoded) {
+			return hasher.Check(encoded)
+		}
+	}
+
+	return ErrUnknownHashFormat
+}
+
+func (h *Hashers) Supports(encoded string) bool {
+	for _, hasher := range h.known {
+		if hasher.Supports(encoded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *Hashers) NeedsRehash(encoded string) bool {
+	if !h.preferred.Supports(encoded) {
+		return true
+	}
+
+	return h.preferred.NeedsRehash(encoded)
+}
+
+func (h *Hashers) MaxPasswordBytes() int {
+	return MaxBytes(h.preferred)
+}
+
+// MaxBytes возвращает, сколько байт пароля может захешировать алгоритм,
+// или 0, если ограничения нет. Ограничение сообщают алгоритмы с методом MaxPasswordBytes
+func MaxBytes(h Hasher) int {
+	if limited, ok := h.(interface{ MaxPasswordBytes() int }); ok {
+		return limited.MaxPasswordBytes()
+	}
+	return 0
+}
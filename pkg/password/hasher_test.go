@@ -0,0 +1,199 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params дешевые параметры, чтобы тесты шли быстро
+var testArgon2Params = Argon2Params{
+	Memory:      8 * 1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func pbkdf2Hash(t *testing.T, password, salt string, rounds int) string {
+	t.Helper()
+
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), rounds, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(b []byte) string {
+		return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(b), "+", ".")
+	}
+
+	return fmt.Sprintf("$pbkdf2-sha256$%d$%s$%s", rounds, encode([]byte(salt)), encode(key))
+}
+
+func saltedSHA256Hash(password, salt string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return saltedSHA256Prefix + salt + "$" + hex.EncodeToString(sum[:])
+}
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+	}{
+		{"bcrypt", NewBcrypt(4)},
+		{"argon2id", NewArgon2id(testArgon2Params)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.hasher.Supports(encoded) {
+				t.Errorf("hasher does not support its own hash %q", encoded)
+			}
+
+			if err := tt.hasher.Check(encoded); err != nil {
+				t.Errorf("Check rejected own hash: %v", err)
+			}
+
+			if ok, err := tt.hasher.Verify("correct horse", encoded); err != nil || !ok {
+				t.Errorf("Verify(correct) = %v, %v", ok, err)
+			}
+
+			if ok, err := tt.hasher.Verify("wrong horse", encoded); err != nil || ok {
+				t.Errorf("Verify(wrong) = %v, %v", ok, err)
+			}
+
+			if tt.hasher.NeedsRehash(encoded) {
+				t.Error("fresh hash needs rehash")
+			}
+		})
+	}
+}
+
+func TestLegacyVerify(t *testing.T) {
+	hashers := NewHashers(NewArgon2id(testArgon2Params), LegacyHashers()...)
+
+	tests := []struct {
+		name     string
+		encoded  string
+		password string
+		want     bool
+	}{
+		{"md5-crypt", "$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1", "Hello world!", true},
+		{"md5-crypt wrong", "$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1", "Hello world", false},
+		{"salted sha256", saltedSHA256Hash("secret", "pepper"), "secret", true},
+		{"salted sha256 wrong", saltedSHA256Hash("secret", "pepper"), "Secret", false},
+		{"pbkdf2-sha256", pbkdf2Hash(t, "secret", "saltsalt", 1000), "secret", true},
+		{"pbkdf2-sha256 wrong", pbkdf2Hash(t, "secret", "saltsalt", 1000), "secret!", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := hashers.Verify(tt.password, tt.encoded)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if ok != tt.want {
+				t.Errorf("Verify = %v, want %v", ok, tt.want)
+			}
+
+			if !hashers.NeedsRehash(tt.encoded) {
+				t.Error("legacy hash does not need rehash")
+			}
+		})
+	}
+}
+
+func TestLegacyHashRefused(t *testing.T) {
+	for _, h := range LegacyHashers() {
+		if _, err := h.Hash("secret"); !errors.Is(err, ErrLegacyScheme) {
+			t.Errorf("%T.Hash error = %v, want ErrLegacyScheme", h, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	hashers := NewHashers(NewArgon2id(testArgon2Params), append([]Hasher{NewBcrypt(4)}, LegacyHashers()...)...)
+
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	argon2 := func(params string) string {
+		return "$argon2id$v=19$" + params + "$" + salt + "$" + key
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"argon2id default", argon2("m=65536,t=3,p=2"), nil},
+		{"argon2id huge memory", argon2("m=4294967295,t=3,p=2"), ErrHashParams},
+		{"argon2id huge iterations", argon2("m=65536,t=1000000,p=2"), ErrHashParams},
+		{"argon2id zero iterations", argon2("m=65536,t=0,p=2"), ErrHashParams},
+		{"argon2id zero parallelism", argon2("m=65536,t=3,p=0"), ErrHashParams},
+		{"pbkdf2 normal", pbkdf2Hash(t, "x", "saltsalt", 29000), nil},
+		{"pbkdf2 huge rounds", "$pbkdf2-sha256$2000000000$c2FsdHNhbHQ$" + strings.Repeat("A", 43), ErrHashParams},
+		{"bcrypt normal", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", nil},
+		{"bcrypt huge cost", "$2a$31$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", ErrHashParams},
+		{"md5-crypt", "$1$saltstri$YMyguxXMBpd2TEZ.vS/3q1", nil},
+		{"unknown", "plaintext", ErrUnknownHashFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := hashers.Check(tt.encoded)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRefusesOutOfRangeParams(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	encoded := "$argon2id$v=19$m=4294967295,t=3,p=2$" + salt + "$" + key
+
+	// Без проверки параметров этот вызов попытался бы выделить 4 TiB
+	if _, err := NewArgon2id(testArgon2Params).Verify("x", encoded); !errors.Is(err, ErrHashParams) {
+		t.Errorf("Verify error = %v, want ErrHashParams", err)
+	}
+}
+
+func TestNeedsRehashWeakerParams(t *testing.T) {
+	weak, err := NewArgon2id(testArgon2Params).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+
+	if !NewArgon2id(stronger).NeedsRehash(weak) {
+		t.Error("hash with fewer iterations does not need rehash")
+	}
+
+	low, err := NewBcrypt(4).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !NewBcrypt(5).NeedsRehash(low) {
+		t.Error("bcrypt hash with lower cost does not need rehash")
+	}
+}
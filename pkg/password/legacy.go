@@ -0,0 +1,290 @@
+package password
+
+import (
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Хеши из старой системы. Ими можно только проверить пароль: новые хеши этими
+// алгоритмами не создаются, а старые переводятся на текущий алгоритм при входе
+const (
+	saltedSHA256Prefix = "$sha256-salted$"
+	pbkdf2SHA256Prefix = "$pbkdf2-sha256$"
+	md5CryptPrefix     = "$1$"
+)
+
+// ErrLegacyScheme возвращается при попытке создать хеш устаревшим алгоритмом
+var ErrLegacyScheme = errors.New("legacy hash scheme can only be used for verification")
+
+// LegacyHashers возвращает все поддерживаемые устаревшие алгоритмы
+func LegacyHashers() []Hasher {
+	return []Hasher{
+		SaltedSHA256Hasher{},
+		PBKDF2SHA256Hasher{},
+		MD5CryptHasher{},
+	}
+}
+
+// SaltedSHA256Hasher проверяет хеши вида $sha256-salted$<salt>$<hex(sha256(salt + password))>
+type SaltedSHA256Hasher struct{}
+
+func (SaltedSHA256Hasher) Hash(string) (string, error) {
+	return "", ErrLegacyScheme
+}
+
+func (SaltedSHA256Hasher) Verify(password, encoded string) (bool, error) {
+	rest, found := strings.CutPrefix(encoded, saltedSHA256Prefix)
+	if !found {
+		return false, ErrUnknownHashFormat
+	}
+
+	salt, digest, found := strings.Cut(rest, "$")
+	if !found {
+		return false, fmt.Errorf("invalid salted sha256 hash: %w", ErrUnknownHashFormat)
+	}
+
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return false, fmt.Errorf("invalid salted sha256 digest: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(salt + password))
+
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1, nil
+}
+
+func (SaltedSHA256Hasher) Check(encoded string) error {
+	rest, found := strings.CutPrefix(encoded, saltedSHA256Prefix)
+	if !found {
+		return ErrUnknownHashFormat
+	}
+
+	_, digest, found := strings.Cut(rest, "$")
+	if !found {
+		return fmt.Errorf("invalid salted sha256 hash: %w", ErrUnknownHashFormat)
+	}
+
+	if expected, err := hex.DecodeString(digest); err != nil || len(expected) != sha256.Size {
+		return fmt.Errorf("invalid salted sha256 digest: %w", ErrUnknownHashFormat)
+	}
+
+	return nil
+}
+
+func (SaltedSHA256Hasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, saltedSHA256Prefix)
+}
+
+func (SaltedSHA256Hasher) NeedsRehash(string) bool {
+	return true
+}
+
+// PBKDF2SHA256Hasher проверяет хеши в формате passlib:
+// $pbkdf2-sha256$<rounds>$<salt>$<hash>, где соль и хеш в base64 с "." вместо "+"
+type PBKDF2SHA256Hasher struct{}
+
+func (PBKDF2SHA256Hasher) Hash(string) (string, error) {
+	return "", ErrLegacyScheme
+}
+
+func (PBKDF2SHA256Hasher) Verify(password, encoded string) (bool, error) {
+	rounds, salt, expected, err := parsePBKDF2SHA256(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, rounds, len(expected))
+	if err != nil {
+		return false, fmt.Errorf("failed to derive pbkdf2 key: %w", err)
+	}
+
+	return subtle.ConstantTimeCompare(key, expected) == 1, nil
+}
+
+func (PBKDF2SHA256Hasher) Check(encoded string) error {
+	_, _, _, err := parsePBKDF2SHA256(encoded)
+	return err
+}
+
+// parsePBKDF2SHA256 разбирает хеш и отклоняет число раундов и длину ключа
+// за пределами допустимого: иначе один импортированный хеш занимал бы процессор
+// на каждой попытке входа
+func parsePBKDF2SHA256(encoded string) (int, []byte, []byte, error) {
+	// "", "pbkdf2-sha256", rounds, salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "pbkdf2-sha256" {
+		return 0, nil, nil, ErrUnknownHashFormat
+	}
+
+	rounds, err := strconv.Atoi(parts[2])
+	if err != nil || rounds < 1 {
+		return 0, nil, nil, fmt.Errorf("invalid pbkdf2 rounds %q", parts[2])
+	}
+
+	if rounds > MaxPBKDF2Rounds {
+		return 0, nil, nil, fmt.Errorf("pbkdf2 rounds %d: %w", rounds, ErrHashParams)
+	}
+
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid pbkdf2 salt: %w", err)
+	}
+
+	expected, err := decodeAdaptedBase64(parts[4])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid pbkdf2 hash: %w", err)
+	}
+
+	if len(expected) == 0 || len(expected) > maxKeyLength {
+		return 0, nil, nil, fmt.Errorf("pbkdf2 key length %d: %w", len(expected), ErrHashParams)
+	}
+
+	return rounds, salt, expected, nil
+}
+
+func (PBKDF2SHA256Hasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, pbkdf2SHA256Prefix)
+}
+
+func (PBKDF2SHA256Hasher) NeedsRehash(string) bool {
+	return true
+}
+
+// decodeAdaptedBase64 декодирует base64 без выравнивания, в котором "+" заменен на "."
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, ".", "+"))
+}
+
+// MD5CryptHasher проверяет хеши md5-crypt вида $1$<salt>$<hash>
+type MD5CryptHasher struct{}
+
+func (MD5CryptHasher) Hash(string) (string, error) {
+	return "", ErrLegacyScheme
+}
+
+func (MD5CryptHasher) Verify(password, encoded string) (bool, error) {
+	rest, found := strings.CutPrefix(encoded, md5CryptPrefix)
+	if !found {
+		return false, ErrUnknownHashFormat
+	}
+
+	salt, _, found := strings.Cut(rest, "$")
+	if !found {
+		return false, fmt.Errorf("invalid md5-crypt hash: %w", ErrUnknownHashFormat)
+	}
+
+	computed := md5Crypt([]byte(password), []byte(salt))
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(encoded)) == 1, nil
+}
+
+func (MD5CryptHasher) Check(encoded string) error {
+	rest, found := strings.CutPrefix(encoded, md5CryptPrefix)
+	if !found {
+		return ErrUnknownHashFormat
+	}
+
+	if _, _, found := strings.Cut(rest, "$"); !found {
+		return fmt.Errorf("invalid md5-crypt hash: %w", ErrUnknownHashFormat)
+	}
+
+	return nil
+}
+
+func (MD5CryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, md5CryptPrefix)
+}
+
+func (MD5CryptHasher) NeedsRehash(string) bool {
+	return true
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt реализует алгоритм md5-crypt Пола-Хеннинга Кампа (FreeBSD, glibc)
+func md5Crypt(password, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.New()
+	alt.Write(password)
+	alt.Write(salt)
+	alt.Write(password)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(md5CryptPrefix))
+	ctx.Write(salt)
+
+	for n := len(password); n > 0; n -= 16 {
+		ctx.Write(altSum[:min(n, 16)])
+	}
+
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+
+	final := ctx.Sum(nil)
+
+	// 1000 раундов, чтобы замедлить перебор
+	for i := range 1000 {
+		round := md5.New()
+
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+
+		if i%7 != 0 {
+			round.Write(password)
+		}
+
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(md5CryptPrefix)
+	out.Write(salt)
+	out.WriteByte('$')
+
+	encode := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	encode(uint32(final[0])<<16|uint32(final[6])<<8|uint32(final[12]), 4)
+	encode(uint32(final[1])<<16|uint32(final[7])<<8|uint32(final[13]), 4)
+	encode(uint32(final[2])<<16|uint32(final[8])<<8|uint32(final[14]), 4)
+	encode(uint32(final[3])<<16|uint32(final[9])<<8|uint32(final[15]), 4)
+	encode(uint32(final[4])<<16|uint32(final[10])<<8|uint32(final[5]), 4)
+	encode(uint32(final[11]), 2)
+
+	return out.String()
+}
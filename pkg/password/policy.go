@@ -0,0 +1,203 @@
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Коды правил политики паролей. Возвращаются клиенту, поэтому не должны меняться
+const (
+	RuleMinLength        = "MIN_LENGTH"
+	RuleMaxLength        = "MAX_LENGTH"
+	RuleMissingUpper     = "MISSING_UPPERCASE"
+	RuleMissingLower     = "MISSING_LOWERCASE"
+	RuleMissingDigit     = "MISSING_DIGIT"
+	RuleMissingSymbol    = "MISSING_SYMBOL"
+	RuleContainsPersonal = "CONTAINS_PERSONAL_INFO"
+	RuleCommonPassword   = "COMMON_PASSWORD"
+)
+
+// minPersonalTokenLength части имени и email короче этого не проверяются
+const minPersonalTokenLength = 3
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]struct{}
+)
+
+// Policy требования к паролю. Длина считается в символах, а не в байтах
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// MaxBytes ограничение длины в байтах, которое накладывает алгоритм
+	// хеширования, например 72 у bcrypt. 0 означает, что ограничения нет
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// ForbidPersonal запрещает пароль, содержащий имя или email пользователя
+	ForbidPersonal bool
+	// ForbidCommon запрещает пароли из встроенного списка распространенных
+	ForbidCommon bool
+}
+
+// DefaultPolicy возвращает политику по умолчанию
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:      8,
+		MaxLength:      72,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireDigit:   true,
+		ForbidPersonal: true,
+		ForbidCommon:   true,
+	}
+}
+
+// Violation нарушенное правило политики
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// Validate проверяет пароль по всем правилам и возвращает список нарушений.
+// Пустой список означает, что пароль подходит. name и email нужны для
+// проверки на личные данные и могут быть пустыми
+func (p Policy) Validate(password, name, email string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMinLength,
+			Description: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMaxLength,
+			Description: fmt.Sprintf("password must be at most %d characters long", p.MaxLength),
+		})
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		// Кириллица и эмодзи занимают несколько байт, поэтому пароль в пределах
+		// MaxLength символов может не поместиться в ограничение алгоритма
+		violations = append(violations, Violation{
+			Rule:        RuleMaxLength,
+			Description: fmt.Sprintf("password must be at most %d bytes long", p.MaxBytes),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{
+			Rule:        RuleMissingUpper,
+			Description: "password must contain an uppercase letter",
+		})
+	}
+
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{
+			Rule:        RuleMissingLower,
+			Description: "password must contain a lowercase letter",
+		})
+	}
+
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{
+			Rule:        RuleMissingDigit,
+			Description: "password must contain a digit",
+		})
+	}
+
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{
+			Rule:        RuleMissingSymbol,
+			Description: "password must contain a symbol",
+		})
+	}
+
+	if p.ForbidPersonal && containsPersonal(password, name, email) {
+		violations = append(violations, Violation{
+			Rule:        RuleContainsPersonal,
+			Description: "password must not contain your name or email",
+		})
+	}
+
+	if p.ForbidCommon && IsCommon(password) {
+		violations = append(violations, Violation{
+			Rule:        RuleCommonPassword,
+			Description: "password is too common",
+		})
+	}
+
+	return violations
+}
+
+// IsCommon сообщает, входит ли пароль в список распространенных. Регистр не учитывается
+func IsCommon(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = make(map[string]struct{})
+
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				commonPasswords[strings.ToLower(line)] = struct{}{}
+			}
+		}
+	})
+
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// containsPersonal ищет в пароле части имени, email целиком и его локальную часть.
+// Короткие части пропускаются, иначе под запрет попадет почти любой пароль
+func containsPersonal(password, name, email string) bool {
+	lower := strings.ToLower(password)
+
+	tokens := strings.Fields(strings.ToLower(name))
+
+	email = strings.ToLower(email)
+	if email != "" {
+		tokens = append(tokens, email)
+		if local, _, found := strings.Cut(email, "@"); found {
+			tokens = append(tokens, local)
+		}
+	}
+
+	for _, token := range tokens {
+		if utf8.RuneCountInString(token) < minPersonalTokenLength {
+			continue
+		}
+
+		if strings.Contains(lower, token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package password
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func rules(violations []Violation) []string {
+	var out []string
+	for _, v := range violations {
+		out = append(out, v.Rule)
+	}
+	return out
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		user     string
+		email    string
+		want     []string
+	}{
+		{
+			name:     "valid",
+			policy:   DefaultPolicy(),
+			password: "Sturdy-Lantern-42",
+		},
+		{
+			name:     "too short",
+			policy:   DefaultPolicy(),
+			password: "Ab1",
+			want:     []string{RuleMinLength},
+		},
+		{
+			name:     "too long in characters",
+			policy:   DefaultPolicy(),
+			password: "Ab1" + strings.Repeat("x", 70),
+			want:     []string{RuleMaxLength},
+		},
+		{
+			name:     "missing classes",
+			policy:   Policy{MinLength: 1, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true},
+			password: "abc",
+			want:     []string{RuleMissingUpper, RuleMissingDigit, RuleMissingSymbol},
+		},
+		{
+			name:     "length counts runes",
+			policy:   Policy{MinLength: 8},
+			password: "пароль12",
+		},
+		{
+			name:     "cyrillic fits characters but not bytes",
+			policy:   Policy{MaxLength: 72, MaxBytes: 72},
+			password: strings.Repeat("ж", 40),
+			want:     []string{RuleMaxLength},
+		},
+		{
+			name:     "byte limit reported once",
+			policy:   Policy{MaxLength: 10, MaxBytes: 10},
+			password: strings.Repeat("ж", 20),
+			want:     []string{RuleMaxLength},
+		},
+		{
+			name:     "contains name",
+			policy:   Policy{ForbidPersonal: true},
+			password: "xxAliceXX",
+			user:     "Alice Smith",
+			want:     []string{RuleContainsPersonal},
+		},
+		{
+			name:     "contains email local part",
+			policy:   Policy{ForbidPersonal: true},
+			password: "bob.jones2024",
+			email:    "bob.jones@example.com",
+			want:     []string{RuleContainsPersonal},
+		},
+		{
+			name:     "short name parts ignored",
+			policy:   Policy{ForbidPersonal: true},
+			password: "Jo-River-9",
+			user:     "Jo Li",
+		},
+		{
+			name:     "common password ignores case",
+			policy:   Policy{ForbidCommon: true},
+			password: "QWERTY",
+			want:     []string{RuleCommonPassword},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(tt.policy.Validate(tt.password, tt.user, tt.email))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+		want   int
+	}{
+		{"bcrypt", NewBcrypt(4), 72},
+		{"argon2id", NewArgon2id(DefaultArgon2Params()), 0},
+		{"bcrypt preferred", NewHashers(NewBcrypt(4), NewArgon2id(DefaultArgon2Params())), 72},
+		{"argon2id preferred", NewHashers(NewArgon2id(DefaultArgon2Params()), NewBcrypt(4)), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaxBytes(tt.hasher); got != tt.want {
+				t.Errorf("MaxBytes = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyMaxBytesMatchesBcrypt(t *testing.T) {
+	policy := Policy{MaxBytes: MaxBytes(NewBcrypt(4))}
+	password := strings.Repeat("ж", 36) // 72 байта
+
+	if v := policy.Validate(password, "", ""); len(v) != 0 {
+		t.Fatalf("password of exactly 72 bytes rejected: %v", rules(v))
+	}
+
+	if _, err := NewBcrypt(4).Hash(password); err != nil {
+		t.Fatalf("bcrypt rejected password the policy accepted: %v", err)
+	}
+
+	if v := policy.Validate(password+"ж", "", ""); len(v) == 0 {
+		t.Fatal("password over 72 bytes accepted")
+	}
+}
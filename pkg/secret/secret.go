@@ -0,0 +1,82 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// KeySize размер ключа шифрования в байтах (AES-256)
+const KeySize = 32
+
+// ErrMalformed возвращается, если зашифрованные данные повреждены или ключ не подходит
+var ErrMalformed = errors.New("malformed ciphertext")
+
+// ErrNoKey возвращается, если ключ шифрования не задан
+var ErrNoKey = errors.New("encryption key is not set")
+
+// Box шифрует небольшие секреты для хранения в базе с помощью AES-256-GCM
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox создает Box из ключа длиной KeySize байт
+func NewBox(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// NewBoxFromBase64 создает Box из ключа в кодировке base64, как он задается в конфигурации
+func NewBoxFromBase64(encoded string) (*Box, error) {
+	if encoded == "" {
+		return nil, ErrNoKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+
+	return NewBox(key)
+}
+
+// Seal шифрует данные. Результат содержит случайный nonce и шифротекст
+func (b *Box) Seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return b.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// Open расшифровывает данные, полученные от Seal
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	nonceSize := b.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrMalformed
+	}
+
+	plain, err := b.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return plain, nil
+}
@@ -0,0 +1,131 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret секрет "12345678901234567890" из тестовых векторов RFC 6238 в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("lowercase secret gives %s, want %s", lower, upper)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected error for invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	code := func(s int64) string {
+		c, err := Code(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		skew     int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(step), 1, step, true},
+		{"previous step within skew", code(step - 1), 1, step - 1, true},
+		{"next step within skew", code(step + 1), 1, step + 1, true},
+		{"outside skew", code(step - 2), 1, 0, false},
+		{"no skew rejects neighbour", code(step + 1), 0, 0, false},
+		{"surrounding spaces", " " + code(step) + " ", 1, step, true},
+		{"wrong length", code(step)[:5], 1, 0, false},
+		{"empty", "", 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("two generated secrets are equal")
+	}
+
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("user-service", "alice@example.com", rfcSecret)
+
+	for _, part := range []string{
+		"otpauth://totp/user-service:alice@example.com?",
+		"secret=" + rfcSecret,
+		"issuer=user-service",
+		"digits=6",
+		"period=30",
+	} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("uri %q does not contain %q", uri, part)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyPrefix отличает API-ключ от других учетных данных в заголовке authorization.
+// Полный ключ имеет вид usk_<prefix>_<secret>
+const apiKeyPrefix = "usk_"
+
+// Области доступа API-ключей
+const (
+	ScopeUsersRead          = "users:read"
+	ScopeUsersWrite         = "users:write"
+	ScopeTokensIntrospect   = "tokens:introspect"
+	ScopeAPIKeysManage      = "api_keys:manage"
+	ScopeOAuthClientsManage = "oauth_clients:manage"
+	ScopeRolesManage        = "roles:manage"
+	ScopeGroupsManage       = "groups:manage"
+	ScopePermissionsCheck   = "permissions:check"
+)
+
+var knownScopes = []string{
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeTokensIntrospect,
+	ScopeAPIKeysManage,
+	ScopeOAuthClientsManage,
+	ScopeRolesManage,
+	ScopeGroupsManage,
+	ScopePermissionsCheck,
+}
+
+var (
+	errInvalidAPIKey         = status.Error(codes.Unauthenticated, "invalid api key")
+	errAPIKeyNotFound        = status.Error(codes.NotFound, "api key not found")
+	errAPIKeyInternalFailure = status.Error(codes.Internal, "failed to process api key request")
+)
+
+// newAPIKey генерирует ключ и возвращает его открытый префикс, полное значение
+// для однократной выдачи клиенту и хеш секретной части для хранения
+func newAPIKey() (prefix, plain, hash string, err error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key prefix: %w", err)
+	}
+
+	secret, err := token.RandomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(b)
+
+	return prefix, apiKeyPrefix + prefix + "_" + secret, token.HashToken(secret), nil
+}
+
+// splitAPIKey разбирает ключ на префикс и секрет
+func splitAPIKey(raw string) (prefix, secret string, ok bool) {
+	rest, found := strings.CutPrefix(raw, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+
+	prefix, secret, found = strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", "", false
+	}
+
+	return prefix, secret, true
+}
+
+// validateScopes проверяет, что запрошены только известные области доступа
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one scope required")
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return status.Errorf(codes.InvalidArgument, "unknown scope %q", scope)
+		}
+	}
+
+	return nil
+}
+
+// authenticateAPIKey проверяет ключ и возвращает сервисный аккаунт, которому он выдан
+func (s *Server) authenticateAPIKey(ctx context.Context, raw string) (*Principal, error) {
+	prefix, secret, ok := splitAPIKey(raw)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+
+	key, err := s.storer.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			s.log.Warn("api key rejected", "prefix", prefix, "reason", "unknown prefix")
+			return nil, errInvalidAPIKey
+		}
+
+		s.log.Error("failed to get api key", "prefix", prefix, "error", err)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.HashToken(secret)), []byte(key.SecretHash)) != 1 {
+		s.log.Warn("api key rejected", "api_key_id", key.Id, "reason", "wrong secret")
+		return nil, errInvalidAPIKey
+	}
+
+	if key.RevokedAt != nil {
+		s.log.Warn("api key rejected", "api_key_id", key.Id, "reason", "revoked")
+		return nil, errInvalidAPIKey
+	}
+
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		s.log.Warn("api key rejected", "api_key_id", key.Id, "reason", "expired")
+		return nil, errInvalidAPIKey
+	}
+
+	user, err := s.storer.GetUserByID(ctx, key.OrganizationId, key.UserId)
+	if err != nil {
+		s.log.Error("failed to get api key owner", "api_key_id", key.Id, "error", err)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	// Сбой записи времени использования не должен ломать запрос
+	if err := s.storer.TouchAPIKey(ctx, key.Id); err != nil {
+		s.log.Error("failed to update api key last use", "api_key_id", key.Id, "error", err)
+	}
+
+	return &Principal{
+		UserID:           user.Id,
+		OrganizationID:   user.OrganizationId,
+		IsAdmin:          user.IsAdmin,
+		IsServiceAccount: user.IsServiceAccount,
+		APIKeyID:         key.Id,
+		Scopes:           key.Scopes,
+	}, nil
+}
+
+func (s *Server) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyReq) (*pb.CreateAPIKeyRes, error) {
+	s.log.Info("starting create api key",
+		"method", "CreateAPIKey",
+		"user_id", req.GetUserId(),
+		"name", req.GetName(),
+		"scopes", req.GetScopes(),
+	)
+
+	if req.GetUserId() == 0 || req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "user id and name required")
+		s.log.Error("invalid arguments for create api key",
+			"method", "CreateAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := validateScopes(req.GetScopes()); err != nil {
+		s.log.Error("invalid arguments for create api key",
+			"method", "CreateAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if req.GetExpiresAt() != nil {
+		t := req.GetExpiresAt().AsTime()
+		if !t.After(time.Now()) {
+			err := status.Error(codes.InvalidArgument, "expires at must be in the future")
+			s.log.Error("invalid arguments for create api key",
+				"method", "CreateAPIKey",
+				"error", err,
+			)
+			return nil, err
+		}
+		expiresAt = &t
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for api key",
+			"method", "CreateAPIKey",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	// Людям ключи не выдаются: у них есть пароль, 2FA и сессии
+	if !user.IsServiceAccount {
+		return nil, status.Error(codes.FailedPrecondition, "api keys can be issued only to service accounts")
+	}
+
+	prefix, plain, hash, err := newAPIKey()
+	if err != nil {
+		return nil, errAPIKeyInternalFailure
+	}
+
+	key := &db.APIKey{
+		UserId:     user.Id,
+		Name:       req.GetName(),
+		Prefix:     prefix,
+		SecretHash: hash,
+		Scopes:     req.GetScopes(),
+		ExpiresAt:  expiresAt,
+	}
+
+	if err := s.storer.CreateAPIKey(ctx, key); err != nil {
+		s.log.Error("failed to create api key",
+			"method", "CreateAPIKey",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	s.log.Info("api key created successfully",
+		"method", "CreateAPIKey",
+		"user_id", user.Id,
+		"api_key_id", key.Id,
+		"prefix", key.Prefix,
+	)
+
+	return &pb.CreateAPIKeyRes{
+		ApiKey: toPBAPIKey(key),
+		Key:    plain,
+	}, nil
+}
+
+// getAPIKey читает ключ и переводит ошибки хранилища в статусы. Ключ сервисного
+// аккаунта другой организации считается несуществующим
+func (s *Server) getAPIKey(ctx context.Context, id int, method string) (*db.APIKey, error) {
+	key, err := s.storer.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return nil, errAPIKeyNotFound
+		}
+
+		s.log.Error("failed to get api key",
+			"method", method,
+			"api_key_id", id,
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	if key.OrganizationId != s.tenant(ctx) {
+		return nil, errAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+func (s *Server) GetAPIKey(ctx context.Context, req *pb.GetAPIKeyReq) (*pb.APIKey, error) {
+	s.log.Info("starting get api key",
+		"method", "GetAPIKey",
+		"api_key_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "api key id required")
+		s.log.Error("invalid arguments for get api key",
+			"method", "GetAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	key, err := s.getAPIKey(ctx, int(req.GetId()), "GetAPIKey")
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBAPIKey(key), nil
+}
+
+func (s *Server) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysReq) (*pb.ListAPIKeysRes, error) {
+	s.log.Info("starting list api keys",
+		"method", "ListAPIKeys",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 {
+		err := status.Error(codes.InvalidArgument, "user id required")
+		s.log.Error("invalid arguments for list api keys",
+			"method", "ListAPIKeys",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if _, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId())); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get api keys owner",
+			"method", "ListAPIKeys",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	keys, err := s.storer.ListAPIKeys(ctx, int(req.GetUserId()))
+	if err != nil {
+		s.log.Error("failed to list api keys",
+			"method", "ListAPIKeys",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	pbKeys := make([]*pb.APIKey, 0, len(keys))
+
+	for _, key := range keys {
+		pbKeys = append(pbKeys, toPBAPIKey(key))
+	}
+
+	return &pb.ListAPIKeysRes{
+		ApiKeys: pbKeys,
+	}, nil
+}
+
+func (s *Server) UpdateAPIKey(ctx context.Context, req *pb.UpdateAPIKeyReq) (*pb.APIKey, error) {
+	s.log.Info("starting update api key",
+		"method", "UpdateAPIKey",
+		"api_key_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "api key id required")
+		s.log.Error("invalid arguments for update api key",
+			"method", "UpdateAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	key, err := s.getAPIKey(ctx, int(req.GetId()), "UpdateAPIKey")
+	if err != nil {
+		return nil, err
+	}
+
+	// Обновляем только заполненные поля
+	if req.GetName() != "" {
+		key.Name = req.GetName()
+	}
+
+	if len(req.GetScopes()) > 0 {
+		if err := validateScopes(req.GetScopes()); err != nil {
+			s.log.Error("invalid arguments for update api key",
+				"method", "UpdateAPIKey",
+				"error", err,
+			)
+			return nil, err
+		}
+		key.Scopes = req.GetScopes()
+	}
+
+	if err := s.storer.UpdateAPIKey(ctx, key); err != nil {
+		s.log.Error("failed to update api key",
+			"method", "UpdateAPIKey",
+			"api_key_id", key.Id,
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	s.log.Info("api key updated successfully",
+		"method", "UpdateAPIKey",
+		"api_key_id", key.Id,
+		"scopes", key.Scopes,
+	)
+
+	return toPBAPIKey(key), nil
+}
+
+// RevokeAPIKey отзывает ключ, но оставляет запись, чтобы было видно, когда
+// и каким ключом пользовались. Повторный отзыв не считается ошибкой
+func (s *Server) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyReq) (*pb.APIKey, error) {
+	s.log.Info("starting revoke api key",
+		"method", "RevokeAPIKey",
+		"api_key_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "api key id required")
+		s.log.Error("invalid arguments for revoke api key",
+			"method", "RevokeAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if _, err := s.getAPIKey(ctx, int(req.GetId()), "RevokeAPIKey"); err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.storer.RevokeAPIKey(ctx, int(req.GetId()))
+	if err != nil {
+		s.log.Error("failed to revoke api key",
+			"method", "RevokeAPIKey",
+			"api_key_id", req.GetId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	key, err := s.storer.GetAPIKeyByID(ctx, int(req.GetId()))
+	if err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return nil, errAPIKeyNotFound
+		}
+
+		s.log.Error("failed to get revoked api key",
+			"method", "RevokeAPIKey",
+			"api_key_id", req.GetId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	if revoked {
+		s.log.Info("api key revoked",
+			"method", "RevokeAPIKey",
+			"api_key_id", key.Id,
+			"user_id", key.UserId,
+		)
+	}
+
+	return toPBAPIKey(key), nil
+}
+
+func (s *Server) DeleteAPIKey(ctx context.Context, req *pb.DeleteAPIKeyReq) (*pb.DeleteAPIKeyRes, error) {
+	s.log.Info("starting delete api key",
+		"method", "DeleteAPIKey",
+		"api_key_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "api key id required")
+		s.log.Error("invalid arguments for delete api key",
+			"method", "DeleteAPIKey",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if _, err := s.getAPIKey(ctx, int(req.GetId()), "DeleteAPIKey"); err != nil {
+		return nil, err
+	}
+
+	if err := s.storer.DeleteAPIKey(ctx, int(req.GetId())); err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			return nil, errAPIKeyNotFound
+		}
+
+		s.log.Error("failed to delete api key",
+			"method", "DeleteAPIKey",
+			"api_key_id", req.GetId(),
+			"error", err,
+		)
+		return nil, errAPIKeyInternalFailure
+	}
+
+	s.log.Info("api key deleted",
+		"method", "DeleteAPIKey",
+		"api_key_id", req.GetId(),
+	)
+
+	return &pb.DeleteAPIKeyRes{}, nil
+}
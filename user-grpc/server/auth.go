@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errInvalidCredentials одинаковая ошибка для неверного email и неверного пароля,
+// чтобы по ответу нельзя было определить, существует ли пользователь
+var errInvalidCredentials = status.Error(codes.Unauthenticated, "invalid email or password")
+
+// verifyDummy выполняет сравнение с заранее посчитанным хешем, чтобы время ответа
+// для несуществующего email не отличалось от времени ответа при неверном пароле.
+// Хеш считается текущим алгоритмом, иначе разница во времени все равно была бы заметна
+func (s *Server) verifyDummy(plain string) {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.config.Hasher.Hash("dummy-password-for-timing")
+	})
+	s.config.Hasher.Verify(plain, s.dummyHash)
+}
+
+func (s *Server) Authenticate(ctx context.Context, req *pb.AuthenticateReq) (*pb.AuthenticateRes, error) {
+	s.log.Info("starting authenticate",
+		"method", "Authenticate",
+		"email", req.GetEmail(),
+	)
+
+	if req.GetEmail() == "" || req.GetPassword() == "" {
+		err := status.Error(codes.InvalidArgument, "email and password required")
+		s.log.Error("invalid arguments for authenticate",
+			"method", "Authenticate",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	user, err := s.verifyCredentials(ctx, req.GetEmail(), req.GetPassword(), "Authenticate")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, user, "Authenticate")
+}
+
+// verifyCredentials проверяет первый фактор: email и пароль. Учитывает блокировку
+// и неудачные попытки, а после успешной проверки обновляет устаревший хеш
+func (s *Server) verifyCredentials(ctx context.Context, email, plain, method string) (*db.User, error) {
+	user, err := s.storer.GetUserByEmail(ctx, s.tenant(ctx), email)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			s.verifyDummy(plain)
+
+			s.log.Warn("authentication failed",
+				"method", method,
+				"email", email,
+				"reason", "unknown email",
+			)
+			return nil, errInvalidCredentials
+		}
+
+		s.log.Error("failed to get user for authenticate",
+			"method", method,
+			"email", email,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	// Заблокированный аккаунт отклоняем без проверки пароля, чтобы во время
+	// блокировки перебор не мог его подобрать. Время ответа и ошибка те же,
+	// что для неизвестного email
+	if user.IsLocked(time.Now()) {
+		s.verifyDummy(plain)
+
+		s.log.Warn("authentication refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "account locked",
+			"locked_until", *user.LockedUntil,
+		)
+		s.auditLockedLogin(ctx, user, method)
+		return nil, errInvalidCredentials
+	}
+
+	// Сервисные аккаунты входят только по API-ключу
+	if user.IsServiceAccount {
+		s.verifyDummy(plain)
+
+		s.log.Warn("authentication failed",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "service account",
+		)
+		return nil, errInvalidCredentials
+	}
+
+	// Пользователь, созданный при входе через внешнего провайдера, пароля не имеет
+	if user.Password == "" {
+		s.verifyDummy(plain)
+
+		s.log.Warn("authentication failed",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "no password set",
+		)
+		return nil, errInvalidCredentials
+	}
+
+	ok, err := s.config.Hasher.Verify(plain, user.Password)
+	if err != nil {
+		s.log.Error("failed to verify password hash",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+	}
+
+	if !ok {
+		s.log.Warn("authentication failed",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "wrong password",
+		)
+
+		if err := s.registerFailedLogin(ctx, user, method); err != nil {
+			s.log.Error("failed to register failed login",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+
+		return nil, errInvalidCredentials
+	}
+
+	if user.FailedLoginAttempts > 0 {
+		// При включенной 2FA счетчик сбрасывает только верный второй фактор,
+		// иначе каждый вход по паролю давал бы перебору кодов новые попытки
+		enabled, err := s.totpEnabled(ctx, user.Id)
+		if err != nil {
+			s.log.Error("failed to check two-factor status",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+
+		if !enabled {
+			if err := s.storer.ResetFailedLogins(ctx, user.OrganizationId, user.Id); err != nil {
+				s.log.Error("failed to reset failed logins",
+					"method", method,
+					"user_id", user.Id,
+					"error", err,
+				)
+				return nil, status.Error(codes.Internal, "failed to authenticate")
+			}
+		}
+	}
+
+	s.rehashPassword(ctx, user, plain, method)
+
+	return user, nil
+}
+
+// rehashPassword переводит хеш пароля на текущий алгоритм и параметры. Открытый
+// пароль есть только в момент успешного входа, поэтому обновление делается здесь.
+// Сбой не мешает входу: хеш обновится при следующем
+func (s *Server) rehashPassword(ctx context.Context, user *db.User, plain, method string) {
+	if !s.config.Hasher.NeedsRehash(user.Password) {
+		return
+	}
+
+	hashedPassword, err := s.config.Hasher.Hash(plain)
+	if err != nil {
+		s.log.Error("failed to rehash password",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return
+	}
+
+	// Обновляется только хеш и только если пароль не сменили, пока шел вход,
+	// иначе запись целиком затерла бы параллельные изменения пользователя
+	replaced, err := s.storer.ReplacePasswordHash(ctx, user.OrganizationId, user.Id, user.Password, hashedPassword)
+	if err != nil {
+		s.log.Error("failed to save rehashed password",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return
+	}
+
+	if !replaced {
+		s.log.Info("password changed during login, rehash skipped",
+			"method", method,
+			"user_id", user.Id,
+		)
+		return
+	}
+
+	user.Password = hashedPassword
+
+	s.log.Info("password hash upgraded",
+		"method", method,
+		"user_id", user.Id,
+	)
+}
+
+// completeLogin завершает вход после успешной проверки первого фактора.
+// Если у пользователя включена 2FA, вместо сессии выдается challenge,
+// который завершается вызовом VerifyTOTP
+func (s *Server) completeLogin(ctx context.Context, user *db.User, method string) (*pb.AuthenticateRes, error) {
+	enabled, err := s.totpEnabled(ctx, user.Id)
+	if err != nil {
+		s.log.Error("failed to check two-factor status",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	if enabled {
+		challenge, expiresAt, err := s.issuer.IssueChallengeToken(user, challengeTOTP, s.config.ChallengeTTL)
+		if err != nil {
+			s.log.Error("failed to issue challenge",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+
+		s.log.Info("second factor required",
+			"method", method,
+			"user_id", user.Id,
+		)
+
+		return &pb.AuthenticateRes{
+			SecondFactorRequired: true,
+			ChallengeToken:       challenge,
+			ChallengeExpiresAt:   timestamppb.New(expiresAt),
+		}, nil
+	}
+
+	return s.startSession(ctx, user, method)
+}
+
+// startSession открывает сессию для пользователя, прошедшего все факторы
+func (s *Server) startSession(ctx context.Context, user *db.User, method string) (*pb.AuthenticateRes, error) {
+	// Пока временный или истекший пароль не сменен, сессия не выдается
+	if required, reason := s.passwordChangeRequired(user, time.Now()); required {
+		return s.requirePasswordChange(user, reason, method)
+	}
+
+	tokens, err := s.issueSession(ctx, user, nil, "")
+	if err != nil {
+		s.log.Error("failed to issue session",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	s.log.Info("user authenticated successfully",
+		"method", method,
+		"user_id", user.Id,
+	)
+
+	return &pb.AuthenticateRes{
+		User:   toPBUser(user),
+		Tokens: tokens,
+	}, nil
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Права, которые проверяет сервис. Области доступа API-ключей называются так же
+const (
+	PermissionUsersRead           = "users:read"
+	PermissionUsersWrite          = "users:write"
+	PermissionUsersUnlock         = "users:unlock"
+	PermissionUsersImpersonate    = "users:impersonate"
+	PermissionRolesManage         = "roles:manage"
+	PermissionAPIKeysManage       = "api_keys:manage"
+	PermissionOAuthClientsManage  = "oauth_clients:manage"
+	PermissionTokensIntrospect    = "tokens:introspect"
+	PermissionOrganizationsManage = "organizations:manage"
+	PermissionGroupsManage        = "groups:manage"
+	PermissionPermissionsCheck    = "permissions:check"
+)
+
+// privilegedPermissions права, которые дают доступ к чужим аккаунтам, ключам
+// и клиентам или позволяют раздавать права. Обладатель любого из них считается
+// привилегированным наравне с администратором
+var privilegedPermissions = []string{
+	PermissionUsersWrite,
+	PermissionUsersUnlock,
+	PermissionUsersImpersonate,
+	PermissionRolesManage,
+	PermissionAPIKeysManage,
+	PermissionOAuthClientsManage,
+	PermissionOrganizationsManage,
+	PermissionGroupsManage,
+}
+
+var (
+	errAuthenticationRequired = status.Error(codes.Unauthenticated, "authentication required")
+	errAccessDenied           = status.Error(codes.PermissionDenied, "not allowed to call this method")
+)
+
+// accessPolicy кто может вызывать метод
+type accessPolicy struct {
+	// public метод доступен без учетных данных: вход, сброс пароля, регистрация
+	public bool
+	// self метод доступен пользователю, к которому относится запрос
+	self bool
+	// permission право, с которым метод доступен для любого пользователя.
+	// Если пусто и self не задан, достаточно быть аутентифицированным
+	permission string
+	// operator метод затрагивает все организации и доступен только
+	// пользователям организации по умолчанию
+	operator bool
+}
+
+var (
+	publicAccess        = accessPolicy{public: true}
+	authenticatedAccess = accessPolicy{}
+	selfAccess          = accessPolicy{self: true}
+)
+
+func permissionAccess(permission string) accessPolicy {
+	return accessPolicy{permission: permission}
+}
+
+func selfOrPermissionAccess(permission string) accessPolicy {
+	return accessPolicy{self: true, permission: permission}
+}
+
+func operatorAccess(permission string) accessPolicy {
+	return accessPolicy{permission: permission, operator: true}
+}
+
+// methodPolicy политика доступа для каждого метода UserService. Метод, которого
+// здесь нет, недоступен никому, кроме API-ключей с нужной областью доступа
+var methodPolicy = map[string]accessPolicy{
+	// Методы входа: учетные данные передаются в самом запросе
+	pb.UserService_CreateUser_FullMethodName:             publicAccess,
+	pb.UserService_Authenticate_FullMethodName:           publicAccess,
+	pb.UserService_RefreshToken_FullMethodName:           publicAccess,
+	pb.UserService_RevokeToken_FullMethodName:            publicAccess,
+	pb.UserService_RequestPasswordReset_FullMethodName:   publicAccess,
+	pb.UserService_ConfirmPasswordReset_FullMethodName:   publicAccess,
+	pb.UserService_VerifyEmail_FullMethodName:            publicAccess,
+	pb.UserService_VerifyTOTP_FullMethodName:             publicAccess,
+	pb.UserService_ChangePassword_FullMethodName:         publicAccess,
+	pb.UserService_ListIdentityProviders_FullMethodName:  publicAccess,
+	pb.UserService_StartFederatedLogin_FullMethodName:    publicAccess,
+	pb.UserService_CompleteFederatedLogin_FullMethodName: publicAccess,
+	pb.UserService_RequestMagicLink_FullMethodName:       publicAccess,
+	pb.UserService_RedeemMagicLink_FullMethodName:        publicAccess,
+
+	pb.UserService_GetUser_FullMethodName:               selfOrPermissionAccess(PermissionUsersRead),
+	pb.UserService_ListUsers_FullMethodName:             permissionAccess(PermissionUsersRead),
+	pb.UserService_UpdateUser_FullMethodName:            selfOrPermissionAccess(PermissionUsersWrite),
+	pb.UserService_DeleteUser_FullMethodName:            permissionAccess(PermissionUsersWrite),
+	pb.UserService_SendVerificationEmail_FullMethodName: selfOrPermissionAccess(PermissionUsersWrite),
+	pb.UserService_UnlockUser_FullMethodName:            permissionAccess(PermissionUsersUnlock),
+	pb.UserService_ImportUsers_FullMethodName:           permissionAccess(PermissionUsersWrite),
+	pb.UserService_IntrospectToken_FullMethodName:       permissionAccess(PermissionTokensIntrospect),
+
+	// Второй фактор настраивает только сам владелец
+	pb.UserService_EnrollTOTP_FullMethodName:              selfAccess,
+	pb.UserService_ConfirmTOTP_FullMethodName:             selfAccess,
+	pb.UserService_DisableTOTP_FullMethodName:             selfAccess,
+	pb.UserService_RegenerateRecoveryCodes_FullMethodName: selfAccess,
+
+	pb.UserService_CreateAPIKey_FullMethodName: permissionAccess(PermissionAPIKeysManage),
+	pb.UserService_GetAPIKey_FullMethodName:    permissionAccess(PermissionAPIKeysManage),
+	pb.UserService_ListAPIKeys_FullMethodName:  permissionAccess(PermissionAPIKeysManage),
+	pb.UserService_UpdateAPIKey_FullMethodName: permissionAccess(PermissionAPIKeysManage),
+	pb.UserService_RevokeAPIKey_FullMethodName: permissionAccess(PermissionAPIKeysManage),
+	pb.UserService_DeleteAPIKey_FullMethodName: permissionAccess(PermissionAPIKeysManage),
+
+	pb.UserService_CreateOAuthClient_FullMethodName: permissionAccess(PermissionOAuthClientsManage),
+	pb.UserService_GetOAuthClient_FullMethodName:    permissionAccess(PermissionOAuthClientsManage),
+	pb.UserService_ListOAuthClients_FullMethodName:  permissionAccess(PermissionOAuthClientsManage),
+	pb.UserService_UpdateOAuthClient_FullMethodName: permissionAccess(PermissionOAuthClientsManage),
+	pb.UserService_DeleteOAuthClient_FullMethodName: permissionAccess(PermissionOAuthClientsManage),
+
+	pb.UserService_ListUserIdentities_FullMethodName: selfOrPermissionAccess(PermissionUsersRead),
+	pb.UserService_DeleteUserIdentity_FullMethodName: permissionAccess(PermissionUsersWrite),
+
+	// Права на завершение сессии проверяет сам EndImpersonation
+	pb.UserService_Impersonate_FullMethodName:      permissionAccess(PermissionUsersImpersonate),
+	pb.UserService_EndImpersonation_FullMethodName: authenticatedAccess,
+
+	// Роли и права общие для всех организаций, поэтому меняет их только оператор.
+	// Назначать существующие роли можно в своей организации
+	pb.UserService_CreateRole_FullMethodName:       operatorAccess(PermissionRolesManage),
+	pb.UserService_GetRole_FullMethodName:          permissionAccess(PermissionRolesManage),
+	pb.UserService_ListRoles_FullMethodName:        permissionAccess(PermissionRolesManage),
+	pb.UserService_UpdateRole_FullMethodName:       operatorAccess(PermissionRolesManage),
+	pb.UserService_DeleteRole_FullMethodName:       operatorAccess(PermissionRolesManage),
+	pb.UserService_CreatePermission_FullMethodName: operatorAccess(PermissionRolesManage),
+	pb.UserService_ListPermissions_FullMethodName:  permissionAccess(PermissionRolesManage),
+	pb.UserService_DeletePermission_FullMethodName: operatorAccess(PermissionRolesManage),
+	pb.UserService_AssignRole_FullMethodName:       permissionAccess(PermissionRolesManage),
+	pb.UserService_UnassignRole_FullMethodName:     permissionAccess(PermissionRolesManage),
+
+	pb.UserService_CreateOrganization_FullMethodName:      operatorAccess(PermissionOrganizationsManage),
+	pb.UserService_GetOrganization_FullMethodName:         operatorAccess(PermissionOrganizationsManage),
+	pb.UserService_ListOrganizations_FullMethodName:       operatorAccess(PermissionOrganizationsManage),
+	pb.UserService_UpdateOrganization_FullMethodName:      operatorAccess(PermissionOrganizationsManage),
+	pb.UserService_DeleteOrganization_FullMethodName:      operatorAccess(PermissionOrganizationsManage),
+	pb.UserService_ListOrganizationMembers_FullMethodName: operatorAccess(PermissionOrganizationsManage),
+
+	pb.UserService_CreateGroup_FullMethodName:       permissionAccess(PermissionGroupsManage),
+	pb.UserService_GetGroup_FullMethodName:          permissionAccess(PermissionUsersRead),
+	pb.UserService_ListGroups_FullMethodName:        permissionAccess(PermissionUsersRead),
+	pb.UserService_RenameGroup_FullMethodName:       permissionAccess(PermissionGroupsManage),
+	pb.UserService_DeleteGroup_FullMethodName:       permissionAccess(PermissionGroupsManage),
+	pb.UserService_AddGroupMember_FullMethodName:    permissionAccess(PermissionGroupsManage),
+	pb.UserService_RemoveGroupMember_FullMethodName: permissionAccess(PermissionGroupsManage),
+	pb.UserService_ListGroupMembers_FullMethodName:  permissionAccess(PermissionUsersRead),
+	pb.UserService_ListUserGroups_FullMethodName:    selfOrPermissionAccess(PermissionUsersRead),
+
+	pb.UserService_CheckPermission_FullMethodName:  selfOrPermissionAccess(PermissionPermissionsCheck),
+	pb.UserService_CheckPermissions_FullMethodName: permissionAccess(PermissionPermissionsCheck),
+}
+
+// userServicePrefix префикс полных имен методов UserService. Остальные сервисы
+// на том же сервере (reflection) политикой не покрываются
+var userServicePrefix = "/" + pb.UserService_ServiceDesc.ServiceName + "/"
+
+// targetUserID возвращает пользователя, к которому относится запрос
+func targetUserID(req any) (int, bool) {
+	switch r := req.(type) {
+	case *pb.GetUserReq:
+		return int(r.GetId()), r.GetId() != 0
+	case *pb.UpdateUserReq:
+		return int(r.GetId()), r.GetId() != 0
+	case interface{ GetUserId() int64 }:
+		return int(r.GetUserId()), r.GetUserId() != 0
+	}
+	return 0, false
+}
+
+// authorize проверяет, может ли principal вызвать метод. principal равен nil,
+// если запрос пришел без учетных данных. Для потоковых методов req равен nil
+func (s *Server) authorize(p *Principal, method string, req any) error {
+	if !strings.HasPrefix(method, userServicePrefix) {
+		return nil
+	}
+
+	// API-ключи ограничены своими областями доступа
+	if p != nil && p.APIKeyID != 0 {
+		required, ok := methodScopes[method]
+		if !ok || !p.HasScope(required) {
+			s.log.Warn("api key scope denied",
+				"method", method,
+				"api_key_id", p.APIKeyID,
+				"required_scope", required,
+			)
+			return status.Error(codes.PermissionDenied, "api key is not allowed to call this method")
+		}
+
+		if methodPolicy[method].operator && p.OrganizationID != db.DefaultOrganizationID {
+			s.log.Warn("api key scope denied",
+				"method", method,
+				"api_key_id", p.APIKeyID,
+				"reason", "not an operator organization",
+			)
+			return status.Error(codes.PermissionDenied, "api key is not allowed to call this method")
+		}
+
+		return nil
+	}
+
+	policy, ok := methodPolicy[method]
+	if !ok {
+		s.log.Warn("access denied", "method", method, "reason", "no access policy")
+		return errAccessDenied
+	}
+
+	if policy.public {
+		return nil
+	}
+
+	if p == nil {
+		s.log.Warn("access denied", "method", method, "reason", "no credentials")
+		return errAuthenticationRequired
+	}
+
+	// Роли общие для всех организаций, поэтому администратор арендатора
+	// не должен получить доступ к чужим организациям и определениям ролей
+	if policy.operator && p.OrganizationID != db.DefaultOrganizationID {
+		s.log.Warn("access denied",
+			"method", method,
+			"user_id", p.UserID,
+			"reason", "not an operator organization",
+		)
+		return errAccessDenied
+	}
+
+	if policy.self {
+		if userID, ok := targetUserID(req); ok && userID == p.UserID {
+			return nil
+		}
+	}
+
+	if policy.permission != "" {
+		if p.HasPermission(policy.permission) {
+			return nil
+		}
+	} else if !policy.self {
+		return nil
+	}
+
+	s.log.Warn("access denied",
+		"method", method,
+		"user_id", p.UserID,
+		"required_permission", policy.permission,
+	)
+	return errAccessDenied
+}
+
+// canManageCredentials сообщает, может ли principal менять пароль и email
+// пользователя target или удалить его. С roles:manage или правами оператора можно менять всем.
+// Остальные не могут менять учетные данные тех, у кого есть права, которых нет
+// у них самих: иначе через чужой аккаунт можно было бы получить эти права
+func canManageCredentials(p *Principal, target *db.User) bool {
+	if p.HasPermission(PermissionRolesManage) {
+		return true
+	}
+
+	if p.OrganizationID == db.DefaultOrganizationID && p.HasPermission(PermissionOrganizationsManage) {
+		return true
+	}
+
+	// Администратор получает и права, которых еще нет в его ролях
+	if target.IsAdmin {
+		return false
+	}
+
+	for _, permission := range target.Permissions {
+		if !p.HasPermission(permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPrivileged сообщает, что у пользователя есть роль admin или привилегированные права
+func isPrivileged(user *db.User) bool {
+	if user.IsAdmin {
+		return true
+	}
+
+	for _, permission := range privilegedPermissions {
+		if slices.Contains(user.Permissions, permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signedInAs сообщает, что запрос пришел с access-токеном самого пользователя.
+// Сессия выдается только после всех факторов входа, поэтому такой запрос
+// подтверждает и второй фактор
+func signedInAs(ctx context.Context, user *db.User) bool {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || p.APIKeyID != 0 || p.IsImpersonated() {
+		return false
+	}
+
+	return p.UserID == user.Id && p.OrganizationID == user.OrganizationId
+}
+
+// requirePermission проверяет право вызывающего внутри метода, когда оно зависит
+// от содержимого запроса, например от флагов создаваемого пользователя
+func requirePermission(ctx context.Context, permission string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return errAuthenticationRequired
+	}
+
+	if !p.HasPermission(permission) {
+		return errAccessDenied
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+)
+
+func TestEveryMethodHasPolicy(t *testing.T) {
+	for _, m := range pb.UserService_ServiceDesc.Methods {
+		method := userServicePrefix + m.MethodName
+		if _, ok := methodPolicy[method]; !ok {
+			t.Errorf("%s has no access policy", method)
+		}
+	}
+
+	for _, st := range pb.UserService_ServiceDesc.Streams {
+		method := userServicePrefix + st.StreamName
+		if _, ok := methodPolicy[method]; !ok {
+			t.Errorf("%s has no access policy", method)
+		}
+	}
+}
+
+func TestCanManageCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		target    *db.User
+		want      bool
+	}{
+		{
+			name:      "role manager changes administrator",
+			principal: &Principal{OrganizationID: 2, Permissions: []string{PermissionUsersWrite, PermissionRolesManage}},
+			target:    &db.User{IsAdmin: true},
+			want:      true,
+		},
+		{
+			name:      "operator changes administrator",
+			principal: &Principal{OrganizationID: db.DefaultOrganizationID, Permissions: []string{PermissionUsersWrite, PermissionOrganizationsManage}},
+			target:    &db.User{IsAdmin: true},
+			want:      true,
+		},
+		{
+			name:      "organizations manager outside operator organization",
+			principal: &Principal{OrganizationID: 2, Permissions: []string{PermissionUsersWrite, PermissionOrganizationsManage}},
+			target:    &db.User{IsAdmin: true},
+			want:      false,
+		},
+		{
+			name:      "users writer changes administrator",
+			principal: &Principal{Permissions: []string{PermissionUsersWrite}},
+			target:    &db.User{IsAdmin: true},
+			want:      false,
+		},
+		{
+			name:      "users writer changes user with extra permission",
+			principal: &Principal{Permissions: []string{PermissionUsersWrite}},
+			target:    &db.User{Permissions: []string{PermissionUsersRead, PermissionAPIKeysManage}},
+			want:      false,
+		},
+		{
+			name:      "users writer changes user with fewer permissions",
+			principal: &Principal{Permissions: []string{PermissionUsersWrite, PermissionUsersRead}},
+			target:    &db.User{Permissions: []string{PermissionUsersRead}},
+			want:      true,
+		},
+		{
+			name:      "api key with users write changes administrator",
+			principal: &Principal{APIKeyID: 1, Scopes: []string{ScopeUsersWrite}},
+			target:    &db.User{IsAdmin: true},
+			want:      false,
+		},
+		{
+			name:      "api key with users write changes plain user",
+			principal: &Principal{APIKeyID: 1, Scopes: []string{ScopeUsersWrite}},
+			target:    &db.User{},
+			want:      true,
+		},
+		{
+			name:      "api key with roles manage changes administrator",
+			principal: &Principal{APIKeyID: 1, Scopes: []string{ScopeUsersWrite, ScopeRolesManage}},
+			target:    &db.User{IsAdmin: true},
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canManageCredentials(tt.principal, tt.target); got != tt.want {
+				t.Errorf("canManageCredentials = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoleDefinitionsAreOperatorOnly(t *testing.T) {
+	for _, method := range []string{
+		pb.UserService_CreateRole_FullMethodName,
+		pb.UserService_UpdateRole_FullMethodName,
+		pb.UserService_DeleteRole_FullMethodName,
+		pb.UserService_CreatePermission_FullMethodName,
+		pb.UserService_DeletePermission_FullMethodName,
+	} {
+		if !methodPolicy[method].operator {
+			t.Errorf("%s is available outside the operator organization", method)
+		}
+	}
+}
+
+func TestSignedInAs(t *testing.T) {
+	user := &db.User{Id: 7, OrganizationId: 2}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      bool
+	}{
+		{"no credentials", nil, false},
+		{"own session", &Principal{UserID: 7, OrganizationID: 2}, true},
+		{"another user", &Principal{UserID: 8, OrganizationID: 2}, false},
+		{"same id in another organization", &Principal{UserID: 7, OrganizationID: 3}, false},
+		{"api key of the user", &Principal{UserID: 7, OrganizationID: 2, APIKeyID: 1}, false},
+		{"impersonated session", &Principal{UserID: 7, OrganizationID: 2, ActorID: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = withPrincipal(ctx, tt.principal)
+			}
+
+			if got := signedInAs(ctx, user); got != tt.want {
+				t.Errorf("signedInAs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPrivileged(t *testing.T) {
+	tests := []struct {
+		name string
+		user *db.User
+		want bool
+	}{
+		{"plain user", &db.User{}, false},
+		{"reader", &db.User{Permissions: []string{PermissionUsersRead, PermissionPermissionsCheck}}, false},
+		{"administrator", &db.User{IsAdmin: true}, true},
+		{"role manager without admin", &db.User{Permissions: []string{PermissionRolesManage}}, true},
+		{"users writer", &db.User{Permissions: []string{PermissionUsersRead, PermissionUsersWrite}}, true},
+		{"impersonator", &db.User{Permissions: []string{PermissionUsersImpersonate}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPrivileged(tt.user); got != tt.want {
+				t.Errorf("isPrivileged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errInvalidVerificationToken = status.Error(codes.InvalidArgument, "invalid or expired verification token")
+
+func (s *Server) SendVerificationEmail(ctx context.Context, req *pb.SendVerificationEmailReq) (*pb.SendVerificationEmailRes, error) {
+	s.log.Info("starting send verification email",
+		"method", "SendVerificationEmail",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 {
+		err := status.Error(codes.InvalidArgument, "user id required")
+		s.log.Error("invalid arguments for send verification email",
+			"method", "SendVerificationEmail",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for verification",
+			"method", "SendVerificationEmail",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to send verification email")
+	}
+
+	if user.EmailVerifiedAt != nil {
+		return nil, status.Error(codes.FailedPrecondition, "email already verified")
+	}
+
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to send verification email")
+	}
+
+	verificationToken := &db.EmailVerificationToken{
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.config.EmailVerificationTTL),
+	}
+
+	if err := s.storer.CreateEmailVerificationToken(ctx, verificationToken); err != nil {
+		s.log.Error("failed to create verification token",
+			"method", "SendVerificationEmail",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to send verification email")
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nTo confirm your email address follow the link below. It is valid for %s.\n\n%s\n",
+			user.Name,
+			s.config.EmailVerificationTTL,
+			s.link("/verify-email", plain),
+		),
+	}
+
+	if err := s.config.Mailer.Send(ctx, msg); err != nil {
+		s.log.Error("failed to send verification mail",
+			"method", "SendVerificationEmail",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to send verification email")
+	}
+
+	s.log.Info("verification email sent",
+		"method", "SendVerificationEmail",
+		"user_id", user.Id,
+	)
+
+	return &pb.SendVerificationEmailRes{}, nil
+}
+
+func (s *Server) VerifyEmail(ctx context.Context, req *pb.VerifyEmailReq) (*pb.VerifyEmailRes, error) {
+	s.log.Info("starting verify email",
+		"method", "VerifyEmail",
+	)
+
+	if req.GetToken() == "" {
+		err := status.Error(codes.InvalidArgument, "token required")
+		s.log.Error("invalid arguments for verify email",
+			"method", "VerifyEmail",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	verificationToken, err := s.storer.GetEmailVerificationTokenByHash(ctx, token.HashToken(req.GetToken()))
+	if err != nil {
+		if errors.Is(err, db.ErrTokenNotFound) {
+			return nil, errInvalidVerificationToken
+		}
+
+		s.log.Error("failed to get verification token",
+			"method", "VerifyEmail",
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	marked, err := s.storer.MarkEmailVerificationTokenUsed(ctx, verificationToken.Id)
+	if err != nil {
+		s.log.Error("failed to mark verification token used",
+			"method", "VerifyEmail",
+			"user_id", verificationToken.UserId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	if !marked {
+		return nil, errInvalidVerificationToken
+	}
+
+	// Если email сменили после отправки письма, токен подтверждает уже не тот адрес
+	verified, err := s.storer.MarkEmailVerified(ctx, verificationToken.UserId, verificationToken.Email)
+	if err != nil {
+		s.log.Error("failed to mark email verified",
+			"method", "VerifyEmail",
+			"user_id", verificationToken.UserId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	if !verified {
+		s.log.Warn("verification token does not match current email",
+			"method", "VerifyEmail",
+			"user_id", verificationToken.UserId,
+		)
+		return nil, errInvalidVerificationToken
+	}
+
+	// Ссылку открывают без заголовка организации, поэтому она берется из токена
+	ctx = withTenant(ctx, verificationToken.OrganizationId)
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), verificationToken.UserId)
+	if err != nil {
+		s.log.Error("failed to load verified user",
+			"method", "VerifyEmail",
+			"user_id", verificationToken.UserId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	s.log.Info("email verified successfully",
+		"method", "VerifyEmail",
+		"user_id", user.Id,
+	)
+
+	return &pb.VerifyEmailRes{
+		User: toPBUser(user),
+	}, nil
+}
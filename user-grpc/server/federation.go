@@ -0,0 +1,517 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/federation"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errFederationNotConfigured = status.Error(codes.FailedPrecondition, "federated login is not configured on the server")
+	errUnknownIdentityProvider = status.Error(codes.InvalidArgument, "unknown identity provider")
+	errInvalidFederatedState   = status.Error(codes.Unauthenticated, "invalid or expired login state")
+	errFederatedLoginFailed    = status.Error(codes.Unauthenticated, "identity provider login failed")
+	errIdentityNotLinked       = status.Error(codes.PermissionDenied, "no account is linked to this identity")
+	errIdentityNotFound        = status.Error(codes.NotFound, "identity not found")
+	errFederationInternal      = status.Error(codes.Internal, "failed to process federated login")
+)
+
+// federatedState то, что нужно помнить между StartFederatedLogin и CompleteFederatedLogin.
+// Состояние шифруется и целиком отдается клиенту в параметре state, поэтому
+// сервису не нужно ничего хранить, а подменить nonce или verifier нельзя
+type federatedState struct {
+	Provider  string `json:"p"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"`
+	// Organization организация, в которой начат вход. Вход завершается в ней же,
+	// даже если браузер вернулся в приложение без x-organization
+	Organization int `json:"o"`
+}
+
+func (s *Server) sealFederatedState(st *federatedState) (string, error) {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := s.config.SecretBox.Seal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openFederatedState расшифровывает state и проверяет срок его действия
+func (s *Server) openFederatedState(raw string, now time.Time) (*federatedState, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.config.SecretBox.Open(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var st federatedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+
+	if now.Unix() > st.ExpiresAt {
+		return nil, errors.New("state expired")
+	}
+
+	return &st, nil
+}
+
+func (s *Server) ListIdentityProviders(ctx context.Context, req *pb.ListIdentityProvidersReq) (*pb.ListIdentityProvidersRes, error) {
+	providers := make([]string, 0, len(s.config.FederatedProviders))
+
+	for name := range s.config.FederatedProviders {
+		providers = append(providers, name)
+	}
+
+	slices.Sort(providers)
+
+	return &pb.ListIdentityProvidersRes{
+		Providers: providers,
+	}, nil
+}
+
+// StartFederatedLogin готовит переход на страницу входа вышестоящего провайдера.
+// PKCE и nonce защищают от подмены кода и ID токена, state связывает ответ
+// провайдера с этим запросом
+func (s *Server) StartFederatedLogin(ctx context.Context, req *pb.StartFederatedLoginReq) (*pb.StartFederatedLoginRes, error) {
+	s.log.Info("starting federated login",
+		"method", "StartFederatedLogin",
+		"provider", req.GetProvider(),
+	)
+
+	if req.GetProvider() == "" {
+		err := status.Error(codes.InvalidArgument, "provider required")
+		s.log.Error("invalid arguments for start federated login",
+			"method", "StartFederatedLogin",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errFederationNotConfigured
+	}
+
+	fp, ok := s.config.FederatedProviders[req.GetProvider()]
+	if !ok {
+		return nil, errUnknownIdentityProvider
+	}
+
+	nonce, err := token.RandomString(16)
+	if err != nil {
+		return nil, errFederationInternal
+	}
+
+	verifier, err := token.RandomString(32)
+	if err != nil {
+		return nil, errFederationInternal
+	}
+
+	expiresAt := time.Now().Add(s.config.FederatedLoginTTL)
+
+	state, err := s.sealFederatedState(&federatedState{
+		Provider:     req.GetProvider(),
+		Nonce:        nonce,
+		Verifier:     verifier,
+		ExpiresAt:    expiresAt.Unix(),
+		Organization: s.tenant(ctx),
+	})
+	if err != nil {
+		s.log.Error("failed to seal federated state",
+			"method", "StartFederatedLogin",
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	authURL, err := fp.Provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		s.log.Error("failed to build authorization url",
+			"method", "StartFederatedLogin",
+			"provider", req.GetProvider(),
+			"error", err,
+		)
+		return nil, status.Error(codes.Unavailable, "identity provider is unavailable")
+	}
+
+	return &pb.StartFederatedLoginRes{
+		AuthorizationUrl: authURL,
+		State:            state,
+		ExpiresAt:        timestamppb.New(expiresAt),
+	}, nil
+}
+
+// CompleteFederatedLogin обменивает код провайдера на его ID токен, находит или
+// создает локального пользователя и завершает вход так же, как Authenticate:
+// с 2FA и обязательной сменой пароля, если они нужны
+func (s *Server) CompleteFederatedLogin(ctx context.Context, req *pb.CompleteFederatedLoginReq) (*pb.AuthenticateRes, error) {
+	s.log.Info("starting complete federated login",
+		"method", "CompleteFederatedLogin",
+	)
+
+	if req.GetCode() == "" || req.GetState() == "" {
+		err := status.Error(codes.InvalidArgument, "code and state required")
+		s.log.Error("invalid arguments for complete federated login",
+			"method", "CompleteFederatedLogin",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errFederationNotConfigured
+	}
+
+	st, err := s.openFederatedState(req.GetState(), time.Now())
+	if err != nil {
+		s.log.Warn("federated login failed",
+			"method", "CompleteFederatedLogin",
+			"reason", "invalid state",
+			"error", err,
+		)
+		return nil, errInvalidFederatedState
+	}
+
+	fp, ok := s.config.FederatedProviders[st.Provider]
+	if !ok {
+		return nil, errUnknownIdentityProvider
+	}
+
+	if st.Organization != 0 {
+		ctx = withTenant(ctx, st.Organization)
+	}
+
+	identity, err := fp.Provider.Exchange(ctx, req.GetCode(), st.Verifier, st.Nonce)
+	if err != nil {
+		s.log.Warn("federated login failed",
+			"method", "CompleteFederatedLogin",
+			"provider", st.Provider,
+			"reason", "exchange failed",
+			"error", err,
+		)
+		return nil, errFederatedLoginFailed
+	}
+
+	user, err := s.resolveFederatedUser(ctx, fp, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.IsServiceAccount {
+		s.log.Warn("federated login refused",
+			"method", "CompleteFederatedLogin",
+			"user_id", user.Id,
+			"reason", "service account",
+		)
+		return nil, errIdentityNotLinked
+	}
+
+	if user.IsLocked(time.Now()) {
+		s.log.Warn("federated login refused",
+			"method", "CompleteFederatedLogin",
+			"user_id", user.Id,
+			"reason", "account locked",
+			"locked_until", *user.LockedUntil,
+		)
+		s.auditLockedLogin(ctx, user, "CompleteFederatedLogin")
+		return nil, errFederatedLoginFailed
+	}
+
+	return s.completeLogin(ctx, user, "CompleteFederatedLogin")
+}
+
+// resolveFederatedUser находит локального пользователя для внешнего аккаунта.
+// Порядок: уже привязанный аккаунт, затем пользователь с тем же email,
+// подтвержденным и у провайдера, и локально (если провайдеру это разрешено),
+// затем регистрация нового пользователя
+func (s *Server) resolveFederatedUser(ctx context.Context, fp FederatedProvider, identity *federation.Identity) (*db.User, error) {
+	const method = "CompleteFederatedLogin"
+
+	linked, err := s.storer.GetUserIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if err := s.storer.TouchUserIdentity(ctx, linked.Id, identity.Email); err != nil {
+			s.log.Error("failed to touch identity",
+				"method", method,
+				"identity_id", linked.Id,
+				"error", err,
+			)
+		}
+
+		user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), linked.UserId)
+		if err != nil {
+			// Внешний аккаунт привязан к пользователю другой организации
+			if errors.Is(err, db.ErrUserNotFound) {
+				s.log.Warn("federated login refused",
+					"method", method,
+					"identity_id", linked.Id,
+					"reason", "identity linked in another organization",
+				)
+				return nil, errIdentityNotLinked
+			}
+
+			s.log.Error("failed to get user for identity",
+				"method", method,
+				"identity_id", linked.Id,
+				"error", err,
+			)
+			return nil, errFederationInternal
+		}
+
+		return user, nil
+	}
+
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		s.log.Error("failed to get identity",
+			"method", method,
+			"provider", identity.Provider,
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	// Без email нельзя ни сопоставить, ни завести пользователя
+	if identity.Email == "" {
+		s.log.Warn("federated login refused",
+			"method", method,
+			"provider", identity.Provider,
+			"reason", "no email from provider",
+		)
+		return nil, errIdentityNotLinked
+	}
+
+	var user *db.User
+
+	// Неподтвержденному email верить нельзя: иначе любой, кто завел у провайдера
+	// аккаунт с чужим адресом, получил бы доступ к чужому пользователю
+	if fp.LinkByEmail && identity.EmailVerified {
+		user, err = s.storer.GetUserByEmail(ctx, s.tenant(ctx), identity.Email)
+		if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			s.log.Error("failed to get user by email",
+				"method", method,
+				"error", err,
+			)
+			return nil, errFederationInternal
+		}
+
+		// Адрес локального аккаунта тоже должен быть подтвержден. Иначе аккаунт,
+		// заведенный на чужой адрес, достался бы владельцу этого адреса у провайдера,
+		// а пароль, заданный при регистрации, остался бы у того, кто его завел
+		if user != nil && user.EmailVerifiedAt == nil {
+			s.log.Warn("federated login refused",
+				"method", method,
+				"provider", identity.Provider,
+				"user_id", user.Id,
+				"reason", "local email not verified",
+			)
+			return nil, errIdentityNotLinked
+		}
+	}
+
+	created := false
+
+	if user == nil {
+		if !fp.AllowSignup {
+			s.log.Warn("federated login refused",
+				"method", method,
+				"provider", identity.Provider,
+				"reason", "no linked account",
+			)
+			return nil, errIdentityNotLinked
+		}
+
+		user, err = s.signupFederatedUser(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+		created = true
+	}
+
+	now := time.Now()
+
+	link := &db.UserIdentity{
+		UserId:      user.Id,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	}
+
+	if err := s.storer.CreateUserIdentity(ctx, link); err != nil {
+		// Параллельный вход тем же аккаунтом успел создать привязку
+		if errors.Is(err, db.ErrIdentityTaken) {
+			return nil, status.Error(codes.Aborted, "identity is being linked, retry login")
+		}
+
+		s.log.Error("failed to link identity",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	s.audit(ctx, user.Id, user.Id, db.AuditIdentityLinked, map[string]any{
+		"provider": identity.Provider,
+		"subject":  identity.Subject,
+		"signup":   created,
+	})
+
+	s.log.Info("identity linked",
+		"method", method,
+		"user_id", user.Id,
+		"provider", identity.Provider,
+		"signup", created,
+	)
+
+	return user, nil
+}
+
+// signupFederatedUser создает пользователя без пароля. Войти он может только
+// через провайдера, пока не задаст пароль через сброс
+func (s *Server) signupFederatedUser(ctx context.Context, identity *federation.Identity) (*db.User, error) {
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+
+	user := &db.User{
+		OrganizationId: s.tenant(ctx),
+		Name:           name,
+		Email:          identity.Email,
+	}
+
+	if identity.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := s.storer.CreateUser(ctx, user); err != nil {
+		// Email уже занят, а привязка по email провайдеру не разрешена
+		if errors.Is(err, db.ErrEmailTaken) {
+			s.log.Warn("federated login refused",
+				"method", "CompleteFederatedLogin",
+				"provider", identity.Provider,
+				"reason", "email taken",
+			)
+			return nil, errIdentityNotLinked
+		}
+
+		s.log.Error("failed to create federated user",
+			"method", "CompleteFederatedLogin",
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	return user, nil
+}
+
+func (s *Server) ListUserIdentities(ctx context.Context, req *pb.ListUserIdentitiesReq) (*pb.ListUserIdentitiesRes, error) {
+	s.log.Info("starting list user identities",
+		"method", "ListUserIdentities",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 {
+		err := status.Error(codes.InvalidArgument, "user id required")
+		s.log.Error("invalid arguments for list user identities",
+			"method", "ListUserIdentities",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if _, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId())); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for identities",
+			"method", "ListUserIdentities",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	identities, err := s.storer.ListUserIdentities(ctx, int(req.GetUserId()))
+	if err != nil {
+		s.log.Error("failed to list user identities",
+			"method", "ListUserIdentities",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	pbIdentities := make([]*pb.UserIdentity, 0, len(identities))
+
+	for _, identity := range identities {
+		pbIdentities = append(pbIdentities, toPBUserIdentity(identity))
+	}
+
+	return &pb.ListUserIdentitiesRes{
+		Identities: pbIdentities,
+	}, nil
+}
+
+// DeleteUserIdentity отвязывает внешний аккаунт. Следующий вход через него
+// снова пройдет сопоставление по email или регистрацию
+func (s *Server) DeleteUserIdentity(ctx context.Context, req *pb.DeleteUserIdentityReq) (*pb.DeleteUserIdentityRes, error) {
+	s.log.Info("starting delete user identity",
+		"method", "DeleteUserIdentity",
+		"id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for delete user identity",
+			"method", "DeleteUserIdentity",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.storer.DeleteUserIdentity(ctx, int(req.GetId())); err != nil {
+		if errors.Is(err, db.ErrIdentityNotFound) {
+			return nil, errIdentityNotFound
+		}
+
+		s.log.Error("failed to delete user identity",
+			"method", "DeleteUserIdentity",
+			"id", req.GetId(),
+			"error", err,
+		)
+		return nil, errFederationInternal
+	}
+
+	s.log.Info("user identity deleted successfully",
+		"method", "DeleteUserIdentity",
+		"id", req.GetId(),
+	)
+
+	return &pb.DeleteUserIdentityRes{}, nil
+}
@@ -0,0 +1,501 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var (
+	errGroupNotFound        = status.Error(codes.NotFound, "group not found")
+	errGroupInternalFailure = status.Error(codes.Internal, "failed to process group request")
+	errInvalidPageToken     = status.Error(codes.InvalidArgument, "invalid page token")
+)
+
+// pageParams переводит page_size и page_token запроса в размер страницы и id,
+// после которого она начинается. Токен непрозрачен для клиента
+func pageParams(size int32, pageToken string) (int, int, error) {
+	limit := int(size)
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	if pageToken == "" {
+		return limit, 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(pageToken)
+	if err != nil {
+		return 0, 0, errInvalidPageToken
+	}
+
+	after, err := strconv.Atoi(string(raw))
+	if err != nil || after <= 0 {
+		return 0, 0, errInvalidPageToken
+	}
+
+	return limit, after, nil
+}
+
+// nextPageToken возвращает токен следующей страницы или пустую строку,
+// если страница неполная и дальше ничего нет
+func nextPageToken(count, limit, lastID int) string {
+	if count < limit {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastID)))
+}
+
+// getGroup читает группу текущей организации и переводит ошибки хранилища в статусы
+func (s *Server) getGroup(ctx context.Context, id int, method string) (*db.Group, error) {
+	group, err := s.storer.GetGroup(ctx, s.tenant(ctx), id)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			return nil, errGroupNotFound
+		}
+
+		s.log.Error("failed to get group",
+			"method", method,
+			"group_id", id,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	return group, nil
+}
+
+// checkGroupUser проверяет, что пользователь есть в текущей организации
+func (s *Server) checkGroupUser(ctx context.Context, userID int, method string) error {
+	if _, err := s.storer.GetUserByID(ctx, s.tenant(ctx), userID); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user",
+			"method", method,
+			"user_id", userID,
+			"error", err,
+		)
+		return errGroupInternalFailure
+	}
+
+	return nil
+}
+
+func (s *Server) CreateGroup(ctx context.Context, req *pb.CreateGroupReq) (*pb.Group, error) {
+	s.log.Info("starting create group",
+		"method", "CreateGroup",
+		"name", req.GetName(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for create group",
+			"method", "CreateGroup",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group := &db.Group{
+		OrganizationId: s.tenant(ctx),
+		Name:           req.GetName(),
+		Description:    req.GetDescription(),
+	}
+
+	if err := s.storer.CreateGroup(ctx, group); err != nil {
+		if errors.Is(err, db.ErrGroupTaken) {
+			return nil, status.Error(codes.AlreadyExists, "group already exists")
+		}
+
+		s.log.Error("failed to create group",
+			"method", "CreateGroup",
+			"name", group.Name,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	s.log.Info("group created successfully",
+		"method", "CreateGroup",
+		"group_id", group.Id,
+		"organization_id", group.OrganizationId,
+	)
+
+	return toPBGroup(group), nil
+}
+
+func (s *Server) GetGroup(ctx context.Context, req *pb.GetGroupReq) (*pb.Group, error) {
+	s.log.Info("starting get group",
+		"method", "GetGroup",
+		"group_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for get group",
+			"method", "GetGroup",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group, err := s.getGroup(ctx, int(req.GetId()), "GetGroup")
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBGroup(group), nil
+}
+
+func (s *Server) ListGroups(ctx context.Context, req *pb.ListGroupsReq) (*pb.ListGroupsRes, error) {
+	s.log.Info("starting list groups",
+		"method", "ListGroups",
+	)
+
+	limit, after, err := pageParams(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		s.log.Error("invalid arguments for list groups",
+			"method", "ListGroups",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	groups, err := s.storer.ListGroups(ctx, s.tenant(ctx), after, limit)
+	if err != nil {
+		s.log.Error("failed to list groups",
+			"method", "ListGroups",
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	res := &pb.ListGroupsRes{
+		Groups: make([]*pb.Group, 0, len(groups)),
+	}
+
+	for _, group := range groups {
+		res.Groups = append(res.Groups, toPBGroup(group))
+	}
+
+	if len(groups) > 0 {
+		res.NextPageToken = nextPageToken(len(groups), limit, groups[len(groups)-1].Id)
+	}
+
+	return res, nil
+}
+
+func (s *Server) RenameGroup(ctx context.Context, req *pb.RenameGroupReq) (*pb.Group, error) {
+	s.log.Info("starting rename group",
+		"method", "RenameGroup",
+		"group_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 || req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "id and name required")
+		s.log.Error("invalid arguments for rename group",
+			"method", "RenameGroup",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group, err := s.getGroup(ctx, int(req.GetId()), "RenameGroup")
+	if err != nil {
+		return nil, err
+	}
+
+	group.Name = req.GetName()
+
+	if err := s.storer.UpdateGroup(ctx, group); err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			return nil, errGroupNotFound
+		}
+
+		if errors.Is(err, db.ErrGroupTaken) {
+			return nil, status.Error(codes.AlreadyExists, "group already exists")
+		}
+
+		s.log.Error("failed to rename group",
+			"method", "RenameGroup",
+			"group_id", group.Id,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	s.log.Info("group renamed successfully",
+		"method", "RenameGroup",
+		"group_id", group.Id,
+	)
+
+	return toPBGroup(group), nil
+}
+
+func (s *Server) DeleteGroup(ctx context.Context, req *pb.DeleteGroupReq) (*pb.DeleteGroupRes, error) {
+	s.log.Info("starting delete group",
+		"method", "DeleteGroup",
+		"group_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for delete group",
+			"method", "DeleteGroup",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.storer.DeleteGroup(ctx, s.tenant(ctx), int(req.GetId())); err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			return nil, errGroupNotFound
+		}
+
+		s.log.Error("failed to delete group",
+			"method", "DeleteGroup",
+			"group_id", req.GetId(),
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	s.log.Info("group deleted successfully",
+		"method", "DeleteGroup",
+		"group_id", req.GetId(),
+	)
+
+	return &pb.DeleteGroupRes{}, nil
+}
+
+// AddGroupMember добавляет в группу пользователя или вложенную группу.
+// Повторное добавление ничего не меняет, вложение с циклом отклоняется
+func (s *Server) AddGroupMember(ctx context.Context, req *pb.AddGroupMemberReq) (*pb.AddGroupMemberRes, error) {
+	s.log.Info("starting add group member",
+		"method", "AddGroupMember",
+		"group_id", req.GetGroupId(),
+		"user_id", req.GetUserId(),
+		"member_group_id", req.GetMemberGroupId(),
+	)
+
+	if req.GetGroupId() == 0 || (req.GetUserId() == 0) == (req.GetMemberGroupId() == 0) {
+		err := status.Error(codes.InvalidArgument, "group id and exactly one of user id or member group id required")
+		s.log.Error("invalid arguments for add group member",
+			"method", "AddGroupMember",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group, err := s.getGroup(ctx, int(req.GetGroupId()), "AddGroupMember")
+	if err != nil {
+		return nil, err
+	}
+
+	var added bool
+
+	if req.GetUserId() != 0 {
+		if err := s.checkGroupUser(ctx, int(req.GetUserId()), "AddGroupMember"); err != nil {
+			return nil, err
+		}
+
+		added, err = s.storer.AddGroupUser(ctx, group.Id, int(req.GetUserId()))
+	} else {
+		if _, err := s.getGroup(ctx, int(req.GetMemberGroupId()), "AddGroupMember"); err != nil {
+			return nil, err
+		}
+
+		added, err = s.storer.AddSubgroup(ctx, group.Id, int(req.GetMemberGroupId()))
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrGroupCycle) {
+			return nil, status.Error(codes.FailedPrecondition, "group cannot be nested into itself or its member")
+		}
+
+		s.log.Error("failed to add group member",
+			"method", "AddGroupMember",
+			"group_id", group.Id,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	if added {
+		s.log.Info("group member added successfully",
+			"method", "AddGroupMember",
+			"group_id", group.Id,
+			"user_id", req.GetUserId(),
+			"member_group_id", req.GetMemberGroupId(),
+		)
+	}
+
+	return &pb.AddGroupMemberRes{}, nil
+}
+
+func (s *Server) RemoveGroupMember(ctx context.Context, req *pb.RemoveGroupMemberReq) (*pb.RemoveGroupMemberRes, error) {
+	s.log.Info("starting remove group member",
+		"method", "RemoveGroupMember",
+		"group_id", req.GetGroupId(),
+		"user_id", req.GetUserId(),
+		"member_group_id", req.GetMemberGroupId(),
+	)
+
+	if req.GetGroupId() == 0 || (req.GetUserId() == 0) == (req.GetMemberGroupId() == 0) {
+		err := status.Error(codes.InvalidArgument, "group id and exactly one of user id or member group id required")
+		s.log.Error("invalid arguments for remove group member",
+			"method", "RemoveGroupMember",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group, err := s.getGroup(ctx, int(req.GetGroupId()), "RemoveGroupMember")
+	if err != nil {
+		return nil, err
+	}
+
+	var removed bool
+
+	if req.GetUserId() != 0 {
+		removed, err = s.storer.RemoveGroupUser(ctx, group.Id, int(req.GetUserId()))
+	} else {
+		removed, err = s.storer.RemoveSubgroup(ctx, group.Id, int(req.GetMemberGroupId()))
+	}
+	if err != nil {
+		s.log.Error("failed to remove group member",
+			"method", "RemoveGroupMember",
+			"group_id", group.Id,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	if !removed {
+		return nil, status.Error(codes.NotFound, "group member not found")
+	}
+
+	s.log.Info("group member removed successfully",
+		"method", "RemoveGroupMember",
+		"group_id", group.Id,
+		"user_id", req.GetUserId(),
+		"member_group_id", req.GetMemberGroupId(),
+	)
+
+	return &pb.RemoveGroupMemberRes{}, nil
+}
+
+// ListGroupMembers возвращает прямых участников группы. Участников вложенных
+// групп нужно запрашивать отдельно
+func (s *Server) ListGroupMembers(ctx context.Context, req *pb.ListGroupMembersReq) (*pb.ListGroupMembersRes, error) {
+	s.log.Info("starting list group members",
+		"method", "ListGroupMembers",
+		"group_id", req.GetGroupId(),
+	)
+
+	limit, after, err := pageParams(req.GetPageSize(), req.GetPageToken())
+	if err == nil && req.GetGroupId() == 0 {
+		err = status.Error(codes.InvalidArgument, "group id required")
+	}
+	if err != nil {
+		s.log.Error("invalid arguments for list group members",
+			"method", "ListGroupMembers",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	group, err := s.getGroup(ctx, int(req.GetGroupId()), "ListGroupMembers")
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.storer.ListGroupMembers(ctx, group.Id, after, limit)
+	if err != nil {
+		s.log.Error("failed to list group members",
+			"method", "ListGroupMembers",
+			"group_id", group.Id,
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	res := &pb.ListGroupMembersRes{
+		Members: make([]*pb.GroupMember, 0, len(members)),
+	}
+
+	for _, member := range members {
+		res.Members = append(res.Members, toPBGroupMember(member))
+	}
+
+	if len(members) > 0 {
+		res.NextPageToken = nextPageToken(len(members), limit, members[len(members)-1].Id)
+	}
+
+	return res, nil
+}
+
+// ListUserGroups возвращает группы, в которые пользователь входит напрямую
+// или через вложенные группы
+func (s *Server) ListUserGroups(ctx context.Context, req *pb.ListUserGroupsReq) (*pb.ListUserGroupsRes, error) {
+	s.log.Info("starting list user groups",
+		"method", "ListUserGroups",
+		"user_id", req.GetUserId(),
+	)
+
+	limit, after, err := pageParams(req.GetPageSize(), req.GetPageToken())
+	if err == nil && req.GetUserId() == 0 {
+		err = status.Error(codes.InvalidArgument, "user id required")
+	}
+	if err != nil {
+		s.log.Error("invalid arguments for list user groups",
+			"method", "ListUserGroups",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.checkGroupUser(ctx, int(req.GetUserId()), "ListUserGroups"); err != nil {
+		return nil, err
+	}
+
+	groups, err := s.storer.ListUserGroups(ctx, s.tenant(ctx), int(req.GetUserId()), after, limit)
+	if err != nil {
+		s.log.Error("failed to list user groups",
+			"method", "ListUserGroups",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errGroupInternalFailure
+	}
+
+	res := &pb.ListUserGroupsRes{
+		Groups: make([]*pb.Group, 0, len(groups)),
+	}
+
+	for _, group := range groups {
+		res.Groups = append(res.Groups, toPBGroup(group))
+	}
+
+	if len(groups) > 0 {
+		res.NextPageToken = nextPageToken(len(groups), limit, groups[len(groups)-1].Id)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errImpersonationNotFound        = status.Error(codes.NotFound, "impersonation session not found")
+	errImpersonationInternalFailure = status.Error(codes.Internal, "failed to process impersonation request")
+)
+
+// impersonationAllowed методы, доступные в сессии входа под пользователем.
+// Администратор видит то же, что и пользователь, но не может ничего менять,
+// в том числе выпускать учетные данные и заводить аккаунты. Новый метод
+// по умолчанию запрещен, пока его не добавят сюда
+var impersonationAllowed = map[string]bool{
+	pb.UserService_GetUser_FullMethodName:                 true,
+	pb.UserService_ListUsers_FullMethodName:               true,
+	pb.UserService_IntrospectToken_FullMethodName:         true,
+	pb.UserService_GetAPIKey_FullMethodName:               true,
+	pb.UserService_ListAPIKeys_FullMethodName:             true,
+	pb.UserService_GetOAuthClient_FullMethodName:          true,
+	pb.UserService_ListOAuthClients_FullMethodName:        true,
+	pb.UserService_ListIdentityProviders_FullMethodName:   true,
+	pb.UserService_ListUserIdentities_FullMethodName:      true,
+	pb.UserService_EndImpersonation_FullMethodName:        true,
+	pb.UserService_GetRole_FullMethodName:                 true,
+	pb.UserService_ListRoles_FullMethodName:               true,
+	pb.UserService_ListPermissions_FullMethodName:         true,
+	pb.UserService_GetOrganization_FullMethodName:         true,
+	pb.UserService_ListOrganizations_FullMethodName:       true,
+	pb.UserService_ListOrganizationMembers_FullMethodName: true,
+	pb.UserService_GetGroup_FullMethodName:                true,
+	pb.UserService_ListGroups_FullMethodName:              true,
+	pb.UserService_ListGroupMembers_FullMethodName:        true,
+	pb.UserService_ListUserGroups_FullMethodName:          true,
+	pb.UserService_CheckPermission_FullMethodName:         true,
+	pb.UserService_CheckPermissions_FullMethodName:        true,
+}
+
+// checkImpersonatedCall отклоняет методы не из impersonationAllowed и записывает
+// каждый вызов в сессии входа под пользователем в лог и журнал аудита
+func (s *Server) checkImpersonatedCall(ctx context.Context, p *Principal, method string) error {
+	if !impersonationAllowed[method] {
+		s.log.Warn("impersonated call denied",
+			"method", method,
+			"user_id", p.UserID,
+			"actor_id", p.ActorID,
+			"session_id", p.SessionID,
+		)
+		return status.Error(codes.PermissionDenied, "method is not allowed during impersonation")
+	}
+
+	s.log.Info("impersonated call",
+		"method", method,
+		"user_id", p.UserID,
+		"actor_id", p.ActorID,
+		"session_id", p.SessionID,
+	)
+
+	s.audit(ctx, p.UserID, p.ActorID, db.AuditImpersonatedCall, map[string]any{
+		"method":     method,
+		"session_id": p.SessionID,
+	})
+
+	return nil
+}
+
+// Impersonate выдает сотруднику с правом users:impersonate access-токен пользователя. В токене есть
+// claim act с сотрудником, сессия ограничена ImpersonationTTL и не продлевается
+func (s *Server) Impersonate(ctx context.Context, req *pb.ImpersonateReq) (*pb.ImpersonateRes, error) {
+	s.log.Info("starting impersonate",
+		"method", "Impersonate",
+		"user_id", req.GetUserId(),
+	)
+
+	// Только сотрудник, вошедший сам: не по API-ключу и не под другим пользователем
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || !principal.HasPermission(PermissionUsersImpersonate) || principal.APIKeyID != 0 || principal.IsImpersonated() {
+		s.log.Warn("impersonation denied",
+			"method", "Impersonate",
+			"user_id", req.GetUserId(),
+		)
+		return nil, status.Error(codes.PermissionDenied, "not allowed to impersonate users")
+	}
+
+	if req.GetUserId() == 0 || req.GetReason() == "" {
+		err := status.Error(codes.InvalidArgument, "user id and reason required")
+		s.log.Error("invalid arguments for impersonate",
+			"method", "Impersonate",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if int(req.GetUserId()) == principal.UserID {
+		return nil, status.Error(codes.InvalidArgument, "cannot impersonate yourself")
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for impersonation",
+			"method", "Impersonate",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errImpersonationInternalFailure
+	}
+
+	// Вход под администратором или пользователем с правами, которых у сотрудника нет,
+	// позволил бы действовать с чужими правами, а у сервисного аккаунта нет
+	// интерфейса, который можно было бы посмотреть
+	missing := slices.ContainsFunc(user.Permissions, func(permission string) bool {
+		return !principal.HasPermission(permission)
+	})
+	if user.IsAdmin || user.IsServiceAccount || missing {
+		s.log.Warn("impersonation denied",
+			"method", "Impersonate",
+			"user_id", user.Id,
+			"actor_id", principal.UserID,
+			"reason", "privileged target",
+		)
+		return nil, status.Error(codes.PermissionDenied, "user has privileges that cannot be impersonated")
+	}
+
+	sessionID, err := token.RandomString(16)
+	if err != nil {
+		return nil, errImpersonationInternalFailure
+	}
+
+	session := &db.ImpersonationSession{
+		SessionId: sessionID,
+		UserId:    user.Id,
+		ActorId:   principal.UserID,
+		Reason:    req.GetReason(),
+		ExpiresAt: time.Now().Add(s.config.ImpersonationTTL),
+	}
+
+	if err := s.storer.CreateImpersonationSession(ctx, session); err != nil {
+		s.log.Error("failed to create impersonation session",
+			"method", "Impersonate",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errImpersonationInternalFailure
+	}
+
+	access, err := s.issuer.IssueImpersonationToken(user, principal.UserID, sessionID, session.ExpiresAt)
+	if err != nil {
+		s.log.Error("failed to issue impersonation token",
+			"method", "Impersonate",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errImpersonationInternalFailure
+	}
+
+	s.audit(ctx, user.Id, principal.UserID, db.AuditImpersonationStarted, map[string]any{
+		"session_id": sessionID,
+		"reason":     req.GetReason(),
+		"expires_at": session.ExpiresAt,
+	})
+
+	s.log.Info("impersonation started",
+		"method", "Impersonate",
+		"user_id", user.Id,
+		"actor_id", principal.UserID,
+		"session_id", sessionID,
+	)
+
+	return &pb.ImpersonateRes{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresAt:   timestamppb.New(session.ExpiresAt),
+		SessionId:   sessionID,
+	}, nil
+}
+
+// EndImpersonation завершает сессию досрочно. Это может сделать сама сессия
+// или администратор, который ее открыл
+func (s *Server) EndImpersonation(ctx context.Context, req *pb.EndImpersonationReq) (*pb.EndImpersonationRes, error) {
+	s.log.Info("starting end impersonation",
+		"method", "EndImpersonation",
+		"session_id", req.GetSessionId(),
+	)
+
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok || principal.APIKeyID != 0 {
+		return nil, status.Error(codes.PermissionDenied, "only the impersonation session or its administrator can end it")
+	}
+
+	sessionID := req.GetSessionId()
+	if sessionID == "" {
+		if !principal.IsImpersonated() {
+			err := status.Error(codes.InvalidArgument, "session id required")
+			s.log.Error("invalid arguments for end impersonation",
+				"method", "EndImpersonation",
+				"error", err,
+			)
+			return nil, err
+		}
+		sessionID = principal.SessionID
+	}
+
+	session, err := s.storer.GetImpersonationSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, db.ErrImpersonationNotFound) {
+			return nil, errImpersonationNotFound
+		}
+
+		s.log.Error("failed to get impersonation session",
+			"method", "EndImpersonation",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, errImpersonationInternalFailure
+	}
+
+	own := principal.IsImpersonated() && principal.SessionID == session.SessionId
+	opener := !principal.IsImpersonated() && principal.UserID == session.ActorId
+	if !own && !opener {
+		return nil, status.Error(codes.PermissionDenied, "only the impersonation session or its administrator can end it")
+	}
+
+	ended, err := s.storer.EndImpersonationSession(ctx, sessionID)
+	if err != nil {
+		s.log.Error("failed to end impersonation session",
+			"method", "EndImpersonation",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, errImpersonationInternalFailure
+	}
+
+	// Уже завершенная или истекшая сессия: повторный вызов ничего не меняет
+	if !ended {
+		return &pb.EndImpersonationRes{}, nil
+	}
+
+	s.audit(ctx, session.UserId, session.ActorId, db.AuditImpersonationEnded, map[string]any{
+		"session_id": sessionID,
+	})
+
+	s.log.Info("impersonation ended",
+		"method", "EndImpersonation",
+		"user_id", session.UserId,
+		"actor_id", session.ActorId,
+		"session_id", sessionID,
+	)
+
+	return &pb.EndImpersonationRes{}, nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+)
+
+// credentialMethods методы, которые меняют учетные данные, права или аккаунты
+// и не должны попасть в impersonationAllowed
+var credentialMethods = []string{
+	pb.UserService_CreateUser_FullMethodName,
+	pb.UserService_ImportUsers_FullMethodName,
+	pb.UserService_CreateOAuthClient_FullMethodName,
+	pb.UserService_UpdateOAuthClient_FullMethodName,
+	pb.UserService_DeleteOAuthClient_FullMethodName,
+	pb.UserService_Impersonate_FullMethodName,
+	pb.UserService_UpdateUser_FullMethodName,
+	pb.UserService_DeleteUser_FullMethodName,
+	pb.UserService_ChangePassword_FullMethodName,
+	pb.UserService_EnrollTOTP_FullMethodName,
+	pb.UserService_ConfirmTOTP_FullMethodName,
+	pb.UserService_DisableTOTP_FullMethodName,
+	pb.UserService_RegenerateRecoveryCodes_FullMethodName,
+	pb.UserService_CreateAPIKey_FullMethodName,
+	pb.UserService_UpdateAPIKey_FullMethodName,
+	pb.UserService_RevokeAPIKey_FullMethodName,
+	pb.UserService_DeleteAPIKey_FullMethodName,
+	pb.UserService_DeleteUserIdentity_FullMethodName,
+	pb.UserService_AssignRole_FullMethodName,
+	pb.UserService_UnassignRole_FullMethodName,
+}
+
+func TestImpersonationForbidsCredentialMethods(t *testing.T) {
+	for _, method := range credentialMethods {
+		if impersonationAllowed[method] {
+			t.Errorf("%s is allowed during impersonation", method)
+		}
+	}
+}
+
+func TestImpersonationAllowsEnding(t *testing.T) {
+	if !impersonationAllowed[pb.UserService_EndImpersonation_FullMethodName] {
+		t.Error("impersonation session can not be ended from itself")
+	}
+}
+
+func TestImpersonationAllowedMethodsExist(t *testing.T) {
+	methods := make(map[string]bool)
+	for _, m := range pb.UserService_ServiceDesc.Methods {
+		methods["/"+pb.UserService_ServiceDesc.ServiceName+"/"+m.MethodName] = true
+	}
+
+	for method := range impersonationAllowed {
+		if !methods[method] {
+			t.Errorf("%s is not a method of the service", method)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/pkg/password"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxImportBatch сколько пользователей можно перенести за один вызов ImportUsers
+const maxImportBatch = 1000
+
+// ImportUsers переносит пользователей из старой системы вместе с хешами паролей.
+// Политика паролей здесь не применяется: открытых паролей у нас нет
+func (s *Server) ImportUsers(ctx context.Context, req *pb.ImportUsersReq) (*pb.ImportUsersRes, error) {
+	s.log.Info("starting import users",
+		"method", "ImportUsers",
+		"count", len(req.GetUsers()),
+	)
+
+	if len(req.GetUsers()) == 0 || len(req.GetUsers()) > maxImportBatch {
+		err := status.Errorf(codes.InvalidArgument, "from 1 to %d users required", maxImportBatch)
+		s.log.Error("invalid arguments for import users",
+			"method", "ImportUsers",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	// Администраторов переносит только тот, кто управляет ролями
+	canCreateAdmins := requirePermission(ctx, PermissionRolesManage) == nil
+
+	res := &pb.ImportUsersRes{}
+
+	fail := func(index int, email, reason string) {
+		res.Failures = append(res.Failures, &pb.ImportUserFailure{
+			Index:  int32(index),
+			Email:  email,
+			Reason: reason,
+		})
+	}
+
+	for i, u := range req.GetUsers() {
+		if u.GetName() == "" || u.GetEmail() == "" || u.GetPasswordHash() == "" {
+			fail(i, u.GetEmail(), "name, email and password hash required")
+			continue
+		}
+
+		if u.GetIsAdmin() && !canCreateAdmins {
+			fail(i, u.GetEmail(), "not allowed to import administrators")
+			continue
+		}
+
+		if err := s.config.Hasher.Check(u.GetPasswordHash()); err != nil {
+			if errors.Is(err, password.ErrHashParams) {
+				fail(i, u.GetEmail(), "password hash parameters out of range")
+			} else {
+				fail(i, u.GetEmail(), "unsupported password hash format")
+			}
+			continue
+		}
+
+		user := &db.User{
+			OrganizationId: s.tenant(ctx),
+			Name:           u.GetName(),
+			Email:          u.GetEmail(),
+			Password:       u.GetPasswordHash(),
+			IsAdmin:        u.GetIsAdmin(),
+		}
+
+		if u.GetEmailVerified() {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+
+		if err := s.storer.CreateUser(ctx, user); err != nil {
+			if errors.Is(err, db.ErrEmailTaken) {
+				fail(i, u.GetEmail(), "email already exists")
+				continue
+			}
+
+			s.log.Error("failed to import user",
+				"method", "ImportUsers",
+				"email", u.GetEmail(),
+				"error", err,
+			)
+			fail(i, u.GetEmail(), "failed to create user")
+			continue
+		}
+
+		res.Imported++
+	}
+
+	s.log.Info("users imported",
+		"method", "ImportUsers",
+		"imported", res.Imported,
+		"failed", len(res.Failures),
+	)
+
+	return res, nil
+}
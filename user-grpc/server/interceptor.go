@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Principal тот, от чьего имени выполняется запрос
+type Principal struct {
+	UserID int
+	// OrganizationID организация пользователя. Запрос выполняется в ее пределах
+	OrganizationID   int
+	IsAdmin          bool
+	IsServiceAccount bool
+	// APIKeyID заполнен, если запрос пришел с API-ключом
+	APIKeyID int
+	Scopes   []string
+	// Permissions права из ролей пользователя, вошедшего по access-токену
+	Permissions []string
+	// SessionID сессия, к которой относится access-токен
+	SessionID string
+	// ActorID администратор, который вошел под пользователем UserID
+	ActorID int
+}
+
+// IsImpersonated сообщает, действует ли от имени пользователя администратор
+func (p *Principal) IsImpersonated() bool {
+	return p.ActorID != 0
+}
+
+// HasScope сообщает, разрешена ли principal указанная область доступа
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// HasPermission сообщает, есть ли у principal право. Для API-ключа правами
+// служат его области доступа
+func (p *Principal) HasPermission(permission string) bool {
+	if p.APIKeyID != 0 {
+		return p.HasScope(permission)
+	}
+	return slices.Contains(p.Permissions, permission)
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext возвращает principal, которого перехватчик положил в контекст
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// methodScopes какая область доступа нужна API-ключу для вызова метода.
+// Методы, которых здесь нет (вход, 2FA, сброс пароля), доступны только людям
+var methodScopes = map[string]string{
+	pb.UserService_GetUser_FullMethodName:               ScopeUsersRead,
+	pb.UserService_ListUsers_FullMethodName:             ScopeUsersRead,
+	pb.UserService_CreateUser_FullMethodName:            ScopeUsersWrite,
+	pb.UserService_UpdateUser_FullMethodName:            ScopeUsersWrite,
+	pb.UserService_DeleteUser_FullMethodName:            ScopeUsersWrite,
+	pb.UserService_SendVerificationEmail_FullMethodName: ScopeUsersWrite,
+	pb.UserService_UnlockUser_FullMethodName:            ScopeUsersWrite,
+	pb.UserService_ImportUsers_FullMethodName:           ScopeUsersWrite,
+	pb.UserService_IntrospectToken_FullMethodName:       ScopeTokensIntrospect,
+	pb.UserService_CreateAPIKey_FullMethodName:          ScopeAPIKeysManage,
+	pb.UserService_GetAPIKey_FullMethodName:             ScopeAPIKeysManage,
+	pb.UserService_ListAPIKeys_FullMethodName:           ScopeAPIKeysManage,
+	pb.UserService_UpdateAPIKey_FullMethodName:          ScopeAPIKeysManage,
+	pb.UserService_RevokeAPIKey_FullMethodName:          ScopeAPIKeysManage,
+	pb.UserService_DeleteAPIKey_FullMethodName:          ScopeAPIKeysManage,
+	pb.UserService_CreateOAuthClient_FullMethodName:     ScopeOAuthClientsManage,
+	pb.UserService_GetOAuthClient_FullMethodName:        ScopeOAuthClientsManage,
+	pb.UserService_ListOAuthClients_FullMethodName:      ScopeOAuthClientsManage,
+	pb.UserService_UpdateOAuthClient_FullMethodName:     ScopeOAuthClientsManage,
+	pb.UserService_DeleteOAuthClient_FullMethodName:     ScopeOAuthClientsManage,
+	pb.UserService_ListUserIdentities_FullMethodName:    ScopeUsersRead,
+	pb.UserService_DeleteUserIdentity_FullMethodName:    ScopeUsersWrite,
+	pb.UserService_CreateRole_FullMethodName:            ScopeRolesManage,
+	pb.UserService_GetRole_FullMethodName:               ScopeRolesManage,
+	pb.UserService_ListRoles_FullMethodName:             ScopeRolesManage,
+	pb.UserService_UpdateRole_FullMethodName:            ScopeRolesManage,
+	pb.UserService_DeleteRole_FullMethodName:            ScopeRolesManage,
+	pb.UserService_CreatePermission_FullMethodName:      ScopeRolesManage,
+	pb.UserService_ListPermissions_FullMethodName:       ScopeRolesManage,
+	pb.UserService_DeletePermission_FullMethodName:      ScopeRolesManage,
+	pb.UserService_AssignRole_FullMethodName:            ScopeRolesManage,
+	pb.UserService_UnassignRole_FullMethodName:          ScopeRolesManage,
+	pb.UserService_CreateGroup_FullMethodName:           ScopeGroupsManage,
+	pb.UserService_GetGroup_FullMethodName:              ScopeUsersRead,
+	pb.UserService_ListGroups_FullMethodName:            ScopeUsersRead,
+	pb.UserService_RenameGroup_FullMethodName:           ScopeGroupsManage,
+	pb.UserService_DeleteGroup_FullMethodName:           ScopeGroupsManage,
+	pb.UserService_AddGroupMember_FullMethodName:        ScopeGroupsManage,
+	pb.UserService_RemoveGroupMember_FullMethodName:     ScopeGroupsManage,
+	pb.UserService_ListGroupMembers_FullMethodName:      ScopeUsersRead,
+	pb.UserService_ListUserGroups_FullMethodName:        ScopeUsersRead,
+	pb.UserService_CheckPermission_FullMethodName:       ScopePermissionsCheck,
+	pb.UserService_CheckPermissions_FullMethodName:      ScopePermissionsCheck,
+}
+
+// credentialsFromMetadata достает учетные данные из заголовка authorization.
+// Принимаются схемы Bearer и ApiKey
+func credentialsFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	scheme, credentials, found := strings.Cut(strings.TrimSpace(values[0]), " ")
+	if !found {
+		return ""
+	}
+
+	if !strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "ApiKey") {
+		return ""
+	}
+
+	return strings.TrimSpace(credentials)
+}
+
+// authenticate проверяет API-ключ или access-токен из заголовка authorization.
+// Для запроса без учетных данных возвращает nil
+func (s *Server) authenticate(ctx context.Context, method string) (*Principal, error) {
+	credentials := credentialsFromMetadata(ctx)
+	if credentials == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(credentials, apiKeyPrefix) {
+		return s.authenticateAPIKey(ctx, credentials)
+	}
+
+	principal, err := s.authenticateAccessToken(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	if principal.IsImpersonated() {
+		if err := s.checkImpersonatedCall(ctx, principal, method); err != nil {
+			return nil, err
+		}
+	}
+
+	return principal, nil
+}
+
+// UnaryAuthInterceptor аутентифицирует вызывающего, проверяет политику доступа
+// метода и кладет principal и организацию запроса в контекст, откуда их читают методы Server
+func (s *Server) UnaryAuthInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		principal, err := s.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.authorize(principal, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+
+		tenant, err := s.resolveTenant(ctx, principal)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx = withTenant(ctx, tenant)
+		if principal != nil {
+			ctx = withPrincipal(ctx, principal)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// principalStream подменяет контекст потока, чтобы в нем были principal и организация
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamAuthInterceptor то же, что UnaryAuthInterceptor, для потоковых методов.
+// Запрос до начала потока неизвестен, поэтому доступ "для себя" здесь не работает
+func (s *Server) StreamAuthInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		principal, err := s.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		if err := s.authorize(principal, info.FullMethod, nil); err != nil {
+			return err
+		}
+
+		tenant, err := s.resolveTenant(ss.Context(), principal)
+		if err != nil {
+			return err
+		}
+
+		ctx := withTenant(ss.Context(), tenant)
+		if principal != nil {
+			ctx = withPrincipal(ctx, principal)
+		}
+
+		return handler(srv, &principalStream{
+			ServerStream: ss,
+			ctx:          ctx,
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lockDuration вычисляет время блокировки после attempts неудачных попыток подряд.
+// До порога блокировки нет, на пороге она равна LockoutBaseDelay, а каждая
+// следующая ошибка удваивает ее, но не больше LockoutMaxDelay
+func (s *Server) lockDuration(attempts int) time.Duration {
+	if attempts < s.config.LockoutMaxAttempts {
+		return 0
+	}
+
+	d := s.config.LockoutBaseDelay
+	for i := s.config.LockoutMaxAttempts; i < attempts; i++ {
+		d *= 2
+		if d >= s.config.LockoutMaxDelay {
+			return s.config.LockoutMaxDelay
+		}
+	}
+
+	return min(d, s.config.LockoutMaxDelay)
+}
+
+// registerFailedLogin учитывает неверный пароль и блокирует вход, когда попыток становится слишком много
+func (s *Server) registerFailedLogin(ctx context.Context, user *db.User, method string) error {
+	attempts, err := s.storer.RecordFailedLogin(ctx, user.OrganizationId, user.Id)
+	if err != nil {
+		return err
+	}
+
+	d := s.lockDuration(attempts)
+	if d == 0 {
+		return nil
+	}
+
+	until := time.Now().Add(d)
+	if err := s.storer.LockUser(ctx, user.OrganizationId, user.Id, until); err != nil {
+		return err
+	}
+
+	s.log.Warn("account locked",
+		"method", method,
+		"user_id", user.Id,
+		"failed_attempts", attempts,
+		"lock_duration", d,
+		"locked_until", until,
+	)
+
+	return nil
+}
+
+// auditLockedLogin пишет в журнал отказ во входе заблокированному аккаунту.
+// Вызывающему блокировка не сообщается: он получает ту же ошибку, что и для
+// неизвестного email, иначе по ней можно узнать, что адрес зарегистрирован
+func (s *Server) auditLockedLogin(ctx context.Context, user *db.User, method string) {
+	s.audit(ctx, user.Id, user.Id, db.AuditLoginRefusedLocked, map[string]any{
+		"method":       method,
+		"locked_until": user.LockedUntil,
+	})
+}
+
+func (s *Server) UnlockUser(ctx context.Context, req *pb.UnlockUserReq) (*pb.User, error) {
+	s.log.Info("starting unlock user",
+		"method", "UnlockUser",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 {
+		err := status.Error(codes.InvalidArgument, "user id required")
+		s.log.Error("invalid arguments for unlock user",
+			"method", "UnlockUser",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.storer.ResetFailedLogins(ctx, s.tenant(ctx), int(req.GetUserId())); err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to unlock user",
+			"method", "UnlockUser",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to unlock user")
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+	if err != nil {
+		s.log.Error("failed to get unlocked user",
+			"method", "UnlockUser",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to unlock user")
+	}
+
+	s.log.Info("account unlocked",
+		"method", "UnlockUser",
+		"user_id", user.Id,
+	)
+
+	return toPBUser(user), nil
+}
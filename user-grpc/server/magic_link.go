@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errInvalidMagicLink         = status.Error(codes.Unauthenticated, "invalid or expired login link")
+	errMagicLinkRateLimited     = status.Error(codes.ResourceExhausted, "too many login link requests, try again later")
+	errMagicLinkInternalFailure = status.Error(codes.Internal, "failed to process login link")
+)
+
+// clientIP возвращает адрес клиента. За прокси берется последний адрес из
+// заголовка ClientIPHeader: его добавил сам прокси, остальные мог подставить клиент
+func (s *Server) clientIP(ctx context.Context) string {
+	if s.config.ClientIPHeader != "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(s.config.ClientIPHeader); len(values) > 0 {
+				parts := strings.Split(values[len(values)-1], ",")
+				if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+					return ip
+				}
+			}
+		}
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// checkMagicLinkRateLimit учитывает запрос и проверяет, не превышены ли лимиты.
+// Запрос записывается до подсчета, чтобы параллельные запросы видели друг друга.
+// Незарегистрированные email тоже учитываются, иначе по лимиту можно было бы
+// определить, существует ли пользователь
+func (s *Server) checkMagicLinkRateLimit(ctx context.Context, email, ip string) (bool, error) {
+	if err := s.storer.RecordMagicLinkRequest(ctx, email, ip); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+
+	byEmail, byIP, err := s.storer.CountMagicLinkRequests(ctx, email, ip, now.Add(-s.config.MagicLinkWindow))
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.storer.PruneMagicLinkRequests(ctx, now.Add(-s.config.MagicLinkWindow)); err != nil {
+		s.log.Error("failed to prune magic link requests", "error", err)
+	}
+
+	if s.config.MagicLinkMaxPerEmail > 0 && byEmail > s.config.MagicLinkMaxPerEmail {
+		return false, nil
+	}
+
+	if s.config.MagicLinkMaxPerIP > 0 && byIP > s.config.MagicLinkMaxPerIP {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *Server) RequestMagicLink(ctx context.Context, req *pb.RequestMagicLinkReq) (*pb.RequestMagicLinkRes, error) {
+	s.log.Info("starting request magic link",
+		"method", "RequestMagicLink",
+		"email", req.GetEmail(),
+	)
+
+	if req.GetEmail() == "" {
+		err := status.Error(codes.InvalidArgument, "email required")
+		s.log.Error("invalid arguments for request magic link",
+			"method", "RequestMagicLink",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	ip := s.clientIP(ctx)
+
+	allowed, err := s.checkMagicLinkRateLimit(ctx, strings.ToLower(req.GetEmail()), ip)
+	if err != nil {
+		s.log.Error("failed to check magic link rate limit",
+			"method", "RequestMagicLink",
+			"error", err,
+		)
+		return nil, errMagicLinkInternalFailure
+	}
+
+	if !allowed {
+		s.log.Warn("magic link rate limited",
+			"method", "RequestMagicLink",
+			"email", req.GetEmail(),
+			"ip", ip,
+		)
+		return nil, errMagicLinkRateLimited
+	}
+
+	// Дальше ответ всегда одинаковый, даже при сбое: иначе по нему можно было бы
+	// проверить, зарегистрирован ли email
+	if err := s.sendMagicLink(ctx, req.GetEmail()); err != nil {
+		s.log.Error("failed to send magic link",
+			"method", "RequestMagicLink",
+			"error", err,
+		)
+	}
+
+	return &pb.RequestMagicLinkRes{}, nil
+}
+
+// sendMagicLink выпускает ссылку и отправляет ее пользователю с этим email.
+// Если ссылка пользователю не положена, ничего не делает
+func (s *Server) sendMagicLink(ctx context.Context, email string) error {
+	user, err := s.storer.GetUserByEmail(ctx, s.tenant(ctx), email)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			s.log.Info("magic link requested for unknown email",
+				"method", "RequestMagicLink",
+				"email", email,
+			)
+			return nil
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Сервисные аккаунты входят только по API-ключу
+	if user.IsServiceAccount {
+		s.log.Info("magic link requested for service account",
+			"method", "RequestMagicLink",
+			"user_id", user.Id,
+		)
+		return nil
+	}
+
+	// Доступ к почтовому ящику администратора не должен давать его права.
+	// Права теперь дают и роли без admin, поэтому проверяются и они
+	if isPrivileged(user) && !s.config.MagicLinkAllowAdmins {
+		s.log.Info("magic link requested for privileged user",
+			"method", "RequestMagicLink",
+			"user_id", user.Id,
+		)
+		return nil
+	}
+
+	// Действительна только последняя выданная ссылка
+	if err := s.storer.InvalidateMagicLinkTokens(ctx, user.Id); err != nil {
+		return fmt.Errorf("failed to invalidate previous magic links of user %d: %w", user.Id, err)
+	}
+
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	magicLink := &db.MagicLinkToken{
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.config.MagicLinkTTL),
+	}
+
+	if err := s.storer.CreateMagicLinkToken(ctx, magicLink); err != nil {
+		return fmt.Errorf("failed to create magic link for user %d: %w", user.Id, err)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Sign in link",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nTo sign in follow the link below. It is valid for %s and can be used once.\n\n%s\n\nIf you did not request this link, ignore this message.\n",
+			user.Name,
+			s.config.MagicLinkTTL,
+			s.link("/magic-link", plain),
+		),
+	}
+
+	if err := s.config.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send magic link mail to user %d: %w", user.Id, err)
+	}
+
+	s.log.Info("magic link requested",
+		"method", "RequestMagicLink",
+		"user_id", user.Id,
+	)
+
+	return nil
+}
+
+// RedeemMagicLink гасит ссылку и завершает вход так же, как Authenticate:
+// ссылка заменяет только пароль, 2FA и обязательная смена пароля остаются
+func (s *Server) RedeemMagicLink(ctx context.Context, req *pb.RedeemMagicLinkReq) (*pb.AuthenticateRes, error) {
+	s.log.Info("starting redeem magic link",
+		"method", "RedeemMagicLink",
+	)
+
+	if req.GetToken() == "" {
+		err := status.Error(codes.InvalidArgument, "token required")
+		s.log.Error("invalid arguments for redeem magic link",
+			"method", "RedeemMagicLink",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	magicLink, err := s.storer.GetMagicLinkTokenByHash(ctx, token.HashToken(req.GetToken()))
+	if err != nil {
+		if errors.Is(err, db.ErrTokenNotFound) {
+			return nil, errInvalidMagicLink
+		}
+
+		s.log.Error("failed to get magic link",
+			"method", "RedeemMagicLink",
+			"error", err,
+		)
+		return nil, errMagicLinkInternalFailure
+	}
+
+	// Гасим ссылку атомарно: из двух параллельных запросов пройдет только один
+	marked, err := s.storer.MarkMagicLinkTokenUsed(ctx, magicLink.Id)
+	if err != nil {
+		s.log.Error("failed to mark magic link used",
+			"method", "RedeemMagicLink",
+			"user_id", magicLink.UserId,
+			"error", err,
+		)
+		return nil, errMagicLinkInternalFailure
+	}
+
+	if !marked {
+		s.log.Warn("magic link is used or expired",
+			"method", "RedeemMagicLink",
+			"user_id", magicLink.UserId,
+		)
+		return nil, errInvalidMagicLink
+	}
+
+	// Ссылку открывают без заголовка организации, поэтому она берется из токена,
+	// и вход завершается в ней же
+	ctx = withTenant(ctx, magicLink.OrganizationId)
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), magicLink.UserId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, errInvalidMagicLink
+		}
+
+		s.log.Error("failed to get user for magic link",
+			"method", "RedeemMagicLink",
+			"user_id", magicLink.UserId,
+			"error", err,
+		)
+		return nil, errMagicLinkInternalFailure
+	}
+
+	// Ссылка, отправленная на прежний адрес, не должна открывать аккаунт после смены email
+	if !strings.EqualFold(user.Email, magicLink.Email) || user.IsServiceAccount {
+		s.log.Warn("magic link refused",
+			"method", "RedeemMagicLink",
+			"user_id", user.Id,
+			"reason", "email changed or service account",
+		)
+		return nil, errInvalidMagicLink
+	}
+
+	// Ссылка могла быть выдана до того, как пользователь получил права
+	if isPrivileged(user) && !s.config.MagicLinkAllowAdmins {
+		s.log.Warn("magic link refused",
+			"method", "RedeemMagicLink",
+			"user_id", user.Id,
+			"reason", "privileged user",
+		)
+		return nil, errInvalidMagicLink
+	}
+
+	if user.IsLocked(time.Now()) {
+		s.log.Warn("magic link refused",
+			"method", "RedeemMagicLink",
+			"user_id", user.Id,
+			"reason", "account locked",
+			"locked_until", *user.LockedUntil,
+		)
+		s.auditLockedLogin(ctx, user, "RedeemMagicLink")
+		return nil, errInvalidMagicLink
+	}
+
+	// Переход по ссылке доказывает владение адресом
+	if user.EmailVerifiedAt == nil {
+		verified, err := s.storer.MarkEmailVerified(ctx, user.Id, magicLink.Email)
+		if err != nil {
+			s.log.Error("failed to mark email verified",
+				"method", "RedeemMagicLink",
+				"user_id", user.Id,
+				"error", err,
+			)
+		}
+
+		if verified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	return s.completeLogin(ctx, user, "RedeemMagicLink")
+}
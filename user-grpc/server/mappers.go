@@ -7,14 +7,155 @@ import (
 	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
 )
 
-// Преобразует объект User из базы данных в протобаф-объект UserRes
-func toPBUserRes(u *db.User) *pb.UserRes {
-	return &pb.UserRes{
-		Id:        int64(u.Id),
-		Name:      u.Name,
-		Email:     u.Email,
-		Password:  u.Password,
-		IsAdmin:   u.IsAdmin,
-		CreatedAt: timestamppb.New(u.CreatedAt),
+// Преобразует объект User из базы данных в протобаф-объект User.
+// Хеш пароля наружу не отдается
+func toPBUser(u *db.User) *pb.User {
+	res := &pb.User{
+		Id:                 int64(u.Id),
+		OrganizationId:     int64(u.OrganizationId),
+		Name:               u.Name,
+		Email:              u.Email,
+		IsAdmin:            u.IsAdmin,
+		CreatedAt:          timestamppb.New(u.CreatedAt),
+		EmailVerified:      u.EmailVerifiedAt != nil,
+		IsServiceAccount:   u.IsServiceAccount,
+		MustChangePassword: u.MustChangePassword,
+		Roles:              u.Roles,
+		Permissions:        u.Permissions,
 	}
+
+	if !u.PasswordChangedAt.IsZero() {
+		res.PasswordChangedAt = timestamppb.New(u.PasswordChangedAt)
+	}
+
+	if u.EmailVerifiedAt != nil {
+		res.EmailVerifiedAt = timestamppb.New(*u.EmailVerifiedAt)
+	}
+
+	if u.LockedUntil != nil {
+		res.LockedUntil = timestamppb.New(*u.LockedUntil)
+	}
+
+	return res
+}
+
+// Преобразует API-ключ в протобаф-объект. Хеш секрета наружу не отдается
+func toPBAPIKey(k *db.APIKey) *pb.APIKey {
+	res := &pb.APIKey{
+		Id:        int64(k.Id),
+		UserId:    int64(k.UserId),
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		CreatedAt: timestamppb.New(k.CreatedAt),
+	}
+
+	if k.LastUsedAt != nil {
+		res.LastUsedAt = timestamppb.New(*k.LastUsedAt)
+	}
+
+	if k.ExpiresAt != nil {
+		res.ExpiresAt = timestamppb.New(*k.ExpiresAt)
+	}
+
+	if k.RevokedAt != nil {
+		res.RevokedAt = timestamppb.New(*k.RevokedAt)
+	}
+
+	return res
+}
+
+// Преобразует OAuth-клиента в протобаф-объект. Хеш секрета наружу не отдается
+func toPBOAuthClient(c *db.OAuthClient) *pb.OAuthClient {
+	return &pb.OAuthClient{
+		ClientId:       c.ClientId,
+		Name:           c.Name,
+		RedirectUris:   c.RedirectURIs,
+		Confidential:   c.IsConfidential(),
+		OrganizationId: int64(c.OrganizationId),
+		CreatedAt:      timestamppb.New(c.CreatedAt),
+		UpdatedAt:      timestamppb.New(c.UpdatedAt),
+	}
+}
+
+// Преобразует привязанный внешний аккаунт в протобаф-объект
+func toPBUserIdentity(i *db.UserIdentity) *pb.UserIdentity {
+	res := &pb.UserIdentity{
+		Id:        int64(i.Id),
+		UserId:    int64(i.UserId),
+		Provider:  i.Provider,
+		Subject:   i.Subject,
+		Email:     i.Email,
+		CreatedAt: timestamppb.New(i.CreatedAt),
+	}
+
+	if i.LastLoginAt != nil {
+		res.LastLoginAt = timestamppb.New(*i.LastLoginAt)
+	}
+
+	return res
+}
+
+// Преобразует роль в протобаф-объект
+func toPBRole(r *db.Role) *pb.Role {
+	return &pb.Role{
+		Id:          int64(r.Id),
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: r.Permissions,
+		CreatedAt:   timestamppb.New(r.CreatedAt),
+		UpdatedAt:   timestamppb.New(r.UpdatedAt),
+	}
+}
+
+// Преобразует право в протобаф-объект
+func toPBPermission(p *db.Permission) *pb.Permission {
+	return &pb.Permission{
+		Id:          int64(p.Id),
+		Name:        p.Name,
+		Description: p.Description,
+		CreatedAt:   timestamppb.New(p.CreatedAt),
+	}
+}
+
+// Преобразует организацию в протобаф-объект
+func toPBOrganization(o *db.Organization) *pb.Organization {
+	return &pb.Organization{
+		Id:        int64(o.Id),
+		Slug:      o.Slug,
+		Name:      o.Name,
+		CreatedAt: timestamppb.New(o.CreatedAt),
+		UpdatedAt: timestamppb.New(o.UpdatedAt),
+	}
+}
+
+// Преобразует группу в протобаф-объект
+func toPBGroup(g *db.Group) *pb.Group {
+	return &pb.Group{
+		Id:             int64(g.Id),
+		Name:           g.Name,
+		Description:    g.Description,
+		OrganizationId: int64(g.OrganizationId),
+		CreatedAt:      timestamppb.New(g.CreatedAt),
+		UpdatedAt:      timestamppb.New(g.UpdatedAt),
+	}
+}
+
+// Преобразует участника группы в протобаф-объект
+func toPBGroupMember(m *db.GroupMember) *pb.GroupMember {
+	res := &pb.GroupMember{
+		Name:    m.Name,
+		Email:   m.Email,
+		AddedAt: timestamppb.New(m.CreatedAt),
+	}
+
+	if m.UserId != nil {
+		res.UserId = int64(*m.UserId)
+	}
+
+	if m.MemberGroupId != nil {
+		res.MemberGroupId = int64(*m.MemberGroupId)
+	}
+
+	return res
 }
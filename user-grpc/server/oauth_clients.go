@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errOAuthClientNotFound        = status.Error(codes.NotFound, "oauth client not found")
+	errOAuthClientInternalFailure = status.Error(codes.Internal, "failed to process oauth client request")
+)
+
+// validateRedirectURIs проверяет адреса, на которые можно вернуть код авторизации.
+// Адрес сравнивается целиком, поэтому фрагмент и относительные адреса запрещены.
+// http допускается только для localhost, чтобы приложение можно было проверить локально
+func validateRedirectURIs(uris []string) error {
+	if len(uris) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one redirect uri required")
+	}
+
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return status.Errorf(codes.InvalidArgument, "invalid redirect uri %q", raw)
+		}
+
+		switch u.Scheme {
+		case "https":
+		case "http":
+			if !isLoopbackHost(u.Hostname()) {
+				return status.Errorf(codes.InvalidArgument, "redirect uri %q must use https", raw)
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "redirect uri %q must use https", raw)
+		}
+	}
+
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) CreateOAuthClient(ctx context.Context, req *pb.CreateOAuthClientReq) (*pb.CreateOAuthClientRes, error) {
+	s.log.Info("starting create oauth client",
+		"method", "CreateOAuthClient",
+		"name", req.GetName(),
+		"confidential", req.GetConfidential(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for create oauth client",
+			"method", "CreateOAuthClient",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := validateRedirectURIs(req.GetRedirectUris()); err != nil {
+		s.log.Error("invalid arguments for create oauth client",
+			"method", "CreateOAuthClient",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	clientID, err := token.RandomString(16)
+	if err != nil {
+		return nil, errOAuthClientInternalFailure
+	}
+
+	client := &db.OAuthClient{
+		ClientId:       clientID,
+		Name:           req.GetName(),
+		OrganizationId: s.tenant(ctx),
+		RedirectURIs:   req.GetRedirectUris(),
+	}
+
+	var secret string
+	if req.GetConfidential() {
+		plain, hash, err := token.NewOpaqueToken()
+		if err != nil {
+			return nil, errOAuthClientInternalFailure
+		}
+		secret = plain
+		client.SecretHash = &hash
+	}
+
+	if err := s.storer.CreateOAuthClient(ctx, client); err != nil {
+		s.log.Error("failed to create oauth client",
+			"method", "CreateOAuthClient",
+			"error", err,
+		)
+		return nil, errOAuthClientInternalFailure
+	}
+
+	s.log.Info("oauth client created successfully",
+		"method", "CreateOAuthClient",
+		"client_id", client.ClientId,
+		"confidential", client.IsConfidential(),
+	)
+
+	return &pb.CreateOAuthClientRes{
+		Client:       toPBOAuthClient(client),
+		ClientSecret: secret,
+	}, nil
+}
+
+// getOAuthClient читает клиента и переводит ошибки хранилища в статусы. Клиент
+// другой организации считается несуществующим
+func (s *Server) getOAuthClient(ctx context.Context, clientID, method string) (*db.OAuthClient, error) {
+	client, err := s.storer.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, db.ErrOAuthClientNotFound) {
+			return nil, errOAuthClientNotFound
+		}
+
+		s.log.Error("failed to get oauth client",
+			"method", method,
+			"client_id", clientID,
+			"error", err,
+		)
+		return nil, errOAuthClientInternalFailure
+	}
+
+	if client.OrganizationId != s.tenant(ctx) {
+		return nil, errOAuthClientNotFound
+	}
+
+	return client, nil
+}
+
+func (s *Server) GetOAuthClient(ctx context.Context, req *pb.GetOAuthClientReq) (*pb.OAuthClient, error) {
+	s.log.Info("starting get oauth client",
+		"method", "GetOAuthClient",
+		"client_id", req.GetClientId(),
+	)
+
+	if req.GetClientId() == "" {
+		err := status.Error(codes.InvalidArgument, "client id required")
+		s.log.Error("invalid arguments for get oauth client",
+			"method", "GetOAuthClient",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	client, err := s.getOAuthClient(ctx, req.GetClientId(), "GetOAuthClient")
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBOAuthClient(client), nil
+}
+
+func (s *Server) ListOAuthClients(ctx context.Context, req *pb.ListOAuthClientsReq) (*pb.ListOAuthClientsRes, error) {
+	s.log.Info("starting list oauth clients",
+		"method", "ListOAuthClients",
+	)
+
+	clients, err := s.storer.ListOAuthClients(ctx, s.tenant(ctx))
+	if err != nil {
+		s.log.Error("failed to list oauth clients",
+			"method", "ListOAuthClients",
+			"error", err,
+		)
+		return nil, errOAuthClientInternalFailure
+	}
+
+	pbClients := make([]*pb.OAuthClient, 0, len(clients))
+
+	for _, client := range clients {
+		pbClients = append(pbClients, toPBOAuthClient(client))
+	}
+
+	return &pb.ListOAuthClientsRes{
+		Clients: pbClients,
+	}, nil
+}
+
+func (s *Server) UpdateOAuthClient(ctx context.Context, req *pb.UpdateOAuthClientReq) (*pb.OAuthClient, error) {
+	s.log.Info("starting update oauth client",
+		"method", "UpdateOAuthClient",
+		"client_id", req.GetClientId(),
+	)
+
+	if req.GetClientId() == "" {
+		err := status.Error(codes.InvalidArgument, "client id required")
+		s.log.Error("invalid arguments for update oauth client",
+			"method", "UpdateOAuthClient",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	client, err := s.getOAuthClient(ctx, req.GetClientId(), "UpdateOAuthClient")
+	if err != nil {
+		return nil, err
+	}
+
+	// Обновляем только заполненные поля
+	if req.GetName() != "" {
+		client.Name = req.GetName()
+	}
+
+	if len(req.GetRedirectUris()) > 0 {
+		if err := validateRedirectURIs(req.GetRedirectUris()); err != nil {
+			s.log.Error("invalid arguments for update oauth client",
+				"method", "UpdateOAuthClient",
+				"error", err,
+			)
+			return nil, err
+		}
+		client.RedirectURIs = req.GetRedirectUris()
+	}
+
+	if err := s.storer.UpdateOAuthClient(ctx, client); err != nil {
+		s.log.Error("failed to update oauth client",
+			"method", "UpdateOAuthClient",
+			"client_id", client.ClientId,
+			"error", err,
+		)
+		return nil, errOAuthClientInternalFailure
+	}
+
+	s.log.Info("oauth client updated successfully",
+		"method", "UpdateOAuthClient",
+		"client_id", client.ClientId,
+	)
+
+	return toPBOAuthClient(client), nil
+}
+
+// DeleteOAuthClient удаляет клиента вместе с его неиспользованными кодами авторизации.
+// Уже выданные сессии продолжают работать до истечения или отзыва
+func (s *Server) DeleteOAuthClient(ctx context.Context, req *pb.DeleteOAuthClientReq) (*pb.DeleteOAuthClientRes, error) {
+	s.log.Info("starting delete oauth client",
+		"method", "DeleteOAuthClient",
+		"client_id", req.GetClientId(),
+	)
+
+	if req.GetClientId() == "" {
+		err := status.Error(codes.InvalidArgument, "client id required")
+		s.log.Error("invalid arguments for delete oauth client",
+			"method", "DeleteOAuthClient",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if _, err := s.getOAuthClient(ctx, req.GetClientId(), "DeleteOAuthClient"); err != nil {
+		return nil, err
+	}
+
+	if err := s.storer.DeleteOAuthClient(ctx, req.GetClientId()); err != nil {
+		if errors.Is(err, db.ErrOAuthClientNotFound) {
+			return nil, errOAuthClientNotFound
+		}
+
+		s.log.Error("failed to delete oauth client",
+			"method", "DeleteOAuthClient",
+			"client_id", req.GetClientId(),
+			"error", err,
+		)
+		return nil, errOAuthClientInternalFailure
+	}
+
+	s.log.Info("oauth client deleted successfully",
+		"method", "DeleteOAuthClient",
+		"client_id", req.GetClientId(),
+	)
+
+	return &pb.DeleteOAuthClientRes{}, nil
+}
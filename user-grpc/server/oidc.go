@@ -0,0 +1,758 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Адреса провайдера OpenID Connect относительно издателя токенов. JWKS
+// публикуется менеджером ключей, здесь он нужен только для discovery
+const (
+	OIDCDiscoveryPath = "/.well-known/openid-configuration"
+	OAuthPathPrefix   = "/oauth/"
+
+	oauthAuthorizePath = "/oauth/authorize"
+	oauthTokenPath     = "/oauth/token"
+	oauthUserinfoPath  = "/oauth/userinfo"
+	jwksPath           = "/.well-known/jwks.json"
+)
+
+// Области доступа OpenID Connect
+const (
+	oidcScopeOpenID  = "openid"
+	oidcScopeProfile = "profile"
+	oidcScopeEmail   = "email"
+)
+
+var oidcScopes = []string{oidcScopeOpenID, oidcScopeProfile, oidcScopeEmail}
+
+// Длина code_verifier по RFC 7636
+const (
+	minCodeVerifierLength = 43
+	maxCodeVerifierLength = 128
+)
+
+// oauthError ошибка в формате RFC 6749: код из спецификации и пояснение для разработчика
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func newOAuthError(code, description string) *oauthError {
+	return &oauthError{Code: code, Description: description}
+}
+
+// authorizeRequest параметры запроса авторизации. Они приходят в query при
+// показе формы и повторяются скрытыми полями при ее отправке
+type authorizeRequest struct {
+	ClientID      string
+	RedirectURI   string
+	ResponseType  string
+	Scope         string
+	State         string
+	Nonce         string
+	CodeChallenge string
+	Method        string
+	Prompt        string
+}
+
+func parseAuthorizeRequest(form url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ClientID:      form.Get("client_id"),
+		RedirectURI:   form.Get("redirect_uri"),
+		ResponseType:  form.Get("response_type"),
+		Scope:         form.Get("scope"),
+		State:         form.Get("state"),
+		Nonce:         form.Get("nonce"),
+		CodeChallenge: form.Get("code_challenge"),
+		Method:        form.Get("code_challenge_method"),
+		Prompt:        form.Get("prompt"),
+	}
+}
+
+// validate проверяет параметры, ошибку в которых можно вернуть клиенту через redirect_uri
+func (r *authorizeRequest) validate() *oauthError {
+	if r.ResponseType != "code" {
+		return newOAuthError("unsupported_response_type", "only response_type=code is supported")
+	}
+
+	scopes := strings.Fields(r.Scope)
+	if !slices.Contains(scopes, oidcScopeOpenID) {
+		return newOAuthError("invalid_scope", "scope must include openid")
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(oidcScopes, scope) {
+			return newOAuthError("invalid_scope", "unsupported scope "+scope)
+		}
+	}
+
+	// PKCE обязателен для всех клиентов, а plain не принимается
+	if r.CodeChallenge == "" || r.Method != "S256" {
+		return newOAuthError("invalid_request", "code_challenge with code_challenge_method=S256 required")
+	}
+
+	// Сессии в браузере у провайдера нет, поэтому войти без формы нельзя
+	if r.Prompt == "none" {
+		return newOAuthError("login_required", "interactive login required")
+	}
+
+	return nil
+}
+
+// OIDCHandler возвращает HTTP handler провайдера OpenID Connect: discovery,
+// авторизацию по коду с PKCE, обмен кода на токены и userinfo
+func (s *Server) OIDCHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(OIDCDiscoveryPath, s.handleDiscovery)
+	mux.HandleFunc(oauthAuthorizePath, s.handleAuthorize)
+	mux.HandleFunc(oauthTokenPath, s.handleToken)
+	mux.HandleFunc(oauthUserinfoPath, s.handleUserinfo)
+
+	return mux
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	base := strings.TrimSuffix(s.issuer.Issuer(), "/")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                s.issuer.Issuer(),
+		"authorization_endpoint":                base + oauthAuthorizePath,
+		"token_endpoint":                        base + oauthTokenPath,
+		"userinfo_endpoint":                     base + oauthUserinfoPath,
+		"jwks_uri":                              base + jwksPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      oidcScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "email", "email_verified"},
+	})
+}
+
+// loginPage данные формы входа
+type loginPage struct {
+	Request   *authorizeRequest
+	Client    string
+	Email     string
+	Challenge string
+	Error     string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .Client}}<h1>Sign in to {{.Client}}</h1>{{else}}<h1>Sign in</h1>{{end}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Request}}
+<form method="post" action="` + oauthAuthorizePath + `">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.Method}}">
+{{if .Challenge}}
+<input type="hidden" name="challenge_token" value="{{.Challenge}}">
+<label>Two-factor code <input name="code" autocomplete="one-time-code" autofocus></label>
+<label>or recovery code <input name="recovery_code"></label>
+{{else}}
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+{{end}}
+<button type="submit">Continue</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func (s *Server) renderLogin(w http.ResponseWriter, statusCode int, page loginPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Форму с паролем нельзя встраивать в чужие страницы
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(statusCode)
+
+	if err := loginTemplate.Execute(w, page); err != nil {
+		s.log.Error("failed to render login page", "error", err)
+	}
+}
+
+// redirectWithError возвращает ошибку авторизации клиенту через redirect_uri
+func redirectWithError(w http.ResponseWriter, r *http.Request, req *authorizeRequest, oerr *oauthError) {
+	params := url.Values{}
+	params.Set("error", oerr.Code)
+	params.Set("error_description", oerr.Description)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+
+	return rawURL + sep + params.Encode()
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: "malformed request"})
+		return
+	}
+
+	req := parseAuthorizeRequest(r.Form)
+
+	// Пока клиент и redirect_uri не проверены, перенаправлять нельзя:
+	// иначе провайдер станет открытым редиректом
+	client, err := s.storer.GetOAuthClient(r.Context(), req.ClientID)
+	if err != nil {
+		if !errors.Is(err, db.ErrOAuthClientNotFound) {
+			s.log.Error("failed to get oauth client",
+				"method", "OAuthAuthorize",
+				"client_id", req.ClientID,
+				"error", err,
+			)
+			s.renderLogin(w, http.StatusInternalServerError, loginPage{Error: "failed to process request"})
+			return
+		}
+
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: "unknown client"})
+		return
+	}
+
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		s.log.Warn("authorization refused",
+			"method", "OAuthAuthorize",
+			"client_id", client.ClientId,
+			"reason", "redirect uri not registered",
+		)
+		s.renderLogin(w, http.StatusBadRequest, loginPage{Error: "redirect uri is not registered for this client"})
+		return
+	}
+
+	if oerr := req.validate(); oerr != nil {
+		redirectWithError(w, r, req, oerr)
+		return
+	}
+
+	page := loginPage{Request: req, Client: client.Name}
+
+	// Входят пользователи той организации, в которой зарегистрирован клиент
+	ctx := withTenant(r.Context(), client.OrganizationId)
+
+	if r.Method == http.MethodGet {
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	user, challenge, err := s.authorizeLogin(ctx, r.PostForm)
+	if err != nil {
+		page.Email = r.PostForm.Get("email")
+		page.Challenge = r.PostForm.Get("challenge_token")
+		page.Error = status.Convert(err).Message()
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	if challenge != "" {
+		page.Challenge = challenge
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	// Сменить пароль в этой форме нельзя, это делается в приложении через ChangePassword
+	if required, reason := s.passwordChangeRequired(user, time.Now()); required {
+		s.log.Info("password change required",
+			"method", "OAuthAuthorize",
+			"user_id", user.Id,
+			"reason", reason,
+		)
+		page.Error = "you must change your password before signing in"
+		page.Email = user.Email
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	code, err := s.issueAuthorizationCode(ctx, client, user, req)
+	if err != nil {
+		s.log.Error("failed to issue authorization code",
+			"method", "OAuthAuthorize",
+			"client_id", client.ClientId,
+			"user_id", user.Id,
+			"error", err,
+		)
+		redirectWithError(w, r, req, newOAuthError("server_error", "failed to issue authorization code"))
+		return
+	}
+
+	s.log.Info("authorization code issued",
+		"method", "OAuthAuthorize",
+		"client_id", client.ClientId,
+		"user_id", user.Id,
+	)
+
+	params := url.Values{}
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+// authorizeLogin проверяет отправленную форму входа. Возвращает пользователя,
+// прошедшего все факторы, либо challenge, если нужен код второго фактора
+func (s *Server) authorizeLogin(ctx context.Context, form url.Values) (*db.User, string, error) {
+	if challenge := form.Get("challenge_token"); challenge != "" {
+		code, recoveryCode := form.Get("code"), form.Get("recovery_code")
+		if code == "" && recoveryCode == "" {
+			return nil, "", status.Error(codes.InvalidArgument, "code or recovery code required")
+		}
+
+		user, err := s.verifySecondFactor(ctx, challenge, code, recoveryCode, "OAuthAuthorize")
+		return user, "", err
+	}
+
+	email, plain := form.Get("email"), form.Get("password")
+	if email == "" || plain == "" {
+		return nil, "", status.Error(codes.InvalidArgument, "email and password required")
+	}
+
+	user, err := s.verifyCredentials(ctx, email, plain, "OAuthAuthorize")
+	if err != nil {
+		return nil, "", err
+	}
+
+	enabled, err := s.totpEnabled(ctx, user.Id)
+	if err != nil {
+		s.log.Error("failed to check two-factor status",
+			"method", "OAuthAuthorize",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, "", status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	if !enabled {
+		return user, "", nil
+	}
+
+	challenge, _, err := s.issuer.IssueChallengeToken(user, challengeTOTP, s.config.ChallengeTTL)
+	if err != nil {
+		s.log.Error("failed to issue challenge",
+			"method", "OAuthAuthorize",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, "", status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	return nil, challenge, nil
+}
+
+// issueAuthorizationCode сохраняет хеш одноразового кода и возвращает сам код
+func (s *Server) issueAuthorizationCode(ctx context.Context, client *db.OAuthClient, user *db.User, req *authorizeRequest) (string, error) {
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	code := &db.AuthorizationCode{
+		CodeHash:      hash,
+		ClientId:      client.ClientId,
+		UserId:        user.Id,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(s.config.AuthorizationCodeTTL),
+	}
+
+	if err := s.storer.CreateAuthorizationCode(ctx, code); err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// tokenResponse ответ token endpoint по RFC 6749 и OpenID Connect Core
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+func writeOAuthJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeOAuthError(w http.ResponseWriter, statusCode int, oerr *oauthError) {
+	if oerr.Code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	writeOAuthJSON(w, statusCode, oerr)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, newOAuthError("invalid_request", "malformed form body"))
+		return
+	}
+
+	client, oerr := s.authenticateOAuthClient(r)
+	if oerr != nil {
+		writeOAuthError(w, http.StatusUnauthorized, oerr)
+		return
+	}
+
+	var (
+		res *tokenResponse
+		err error
+	)
+
+	// Коды и refresh-токены принимаются только для пользователей организации клиента
+	ctx := withTenant(r.Context(), client.OrganizationId)
+
+	switch grant := r.PostForm.Get("grant_type"); grant {
+	case "authorization_code":
+		res, err = s.exchangeAuthorizationCode(ctx, client, r.PostForm)
+	case "refresh_token":
+		res, err = s.exchangeRefreshToken(ctx, client, r.PostForm)
+	default:
+		err = newOAuthError("unsupported_grant_type", "grant type "+grant+" is not supported")
+	}
+
+	if err != nil {
+		var oerr *oauthError
+		if errors.As(err, &oerr) {
+			writeOAuthError(w, http.StatusBadRequest, oerr)
+			return
+		}
+
+		s.log.Error("failed to issue oauth tokens",
+			"method", "OAuthToken",
+			"client_id", client.ClientId,
+			"error", err,
+		)
+		writeOAuthError(w, http.StatusInternalServerError, newOAuthError("server_error", "failed to issue tokens"))
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, res)
+}
+
+// authenticateOAuthClient определяет клиента по client_secret_basic, client_secret_post
+// или, для публичного клиента, по одному client_id
+func (s *Server) authenticateOAuthClient(r *http.Request) (*db.OAuthClient, *oauthError) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// В Basic значения дополнительно закодированы как form-urlencoded
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	invalid := newOAuthError("invalid_client", "client authentication failed")
+
+	if clientID == "" {
+		return nil, invalid
+	}
+
+	client, err := s.storer.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if !errors.Is(err, db.ErrOAuthClientNotFound) {
+			s.log.Error("failed to get oauth client",
+				"method", "OAuthToken",
+				"client_id", clientID,
+				"error", err,
+			)
+		}
+		return nil, invalid
+	}
+
+	if !client.IsConfidential() {
+		if secret != "" {
+			return nil, invalid
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.HashToken(secret)), []byte(*client.SecretHash)) != 1 {
+		s.log.Warn("oauth client authentication failed",
+			"method", "OAuthToken",
+			"client_id", client.ClientId,
+		)
+		return nil, invalid
+	}
+
+	return client, nil
+}
+
+// verifyCodeChallenge сверяет code_verifier с code_challenge метода S256
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < minCodeVerifierLength || len(verifier) > maxCodeVerifierLength {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func (s *Server) exchangeAuthorizationCode(ctx context.Context, client *db.OAuthClient, form url.Values) (*tokenResponse, error) {
+	raw, redirectURI, verifier := form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier")
+	if raw == "" || redirectURI == "" || verifier == "" {
+		return nil, newOAuthError("invalid_request", "code, redirect_uri and code_verifier required")
+	}
+
+	invalidGrant := newOAuthError("invalid_grant", "authorization code is invalid, expired or already used")
+
+	code, err := s.storer.GetAuthorizationCodeByHash(ctx, token.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, db.ErrTokenNotFound) {
+			return nil, invalidGrant
+		}
+		return nil, err
+	}
+
+	if code.ClientId != client.ClientId || code.RedirectURI != redirectURI {
+		s.log.Warn("authorization code rejected",
+			"method", "OAuthToken",
+			"client_id", client.ClientId,
+			"reason", "client or redirect uri mismatch",
+		)
+		return nil, invalidGrant
+	}
+
+	if !verifyCodeChallenge(verifier, code.CodeChallenge) {
+		s.log.Warn("authorization code rejected",
+			"method", "OAuthToken",
+			"client_id", client.ClientId,
+			"reason", "pkce verification failed",
+		)
+		return nil, invalidGrant
+	}
+
+	marked, err := s.storer.MarkAuthorizationCodeUsed(ctx, code.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !marked {
+		s.log.Warn("authorization code rejected",
+			"method", "OAuthToken",
+			"client_id", client.ClientId,
+			"user_id", code.UserId,
+			"reason", "used or expired",
+		)
+		return nil, invalidGrant
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), code.UserId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, invalidGrant
+		}
+		return nil, err
+	}
+
+	tokens, err := s.issueSession(ctx, user, &client.ClientId, code.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(code.Scope)
+
+	idToken, err := s.issuer.IssueIDToken(user, client.ClientId, code.Nonce, code.AuthTime,
+		slices.Contains(scopes, oidcScopeProfile),
+		slices.Contains(scopes, oidcScopeEmail),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("authorization code exchanged",
+		"method", "OAuthToken",
+		"client_id", client.ClientId,
+		"user_id", user.Id,
+	)
+
+	res := toTokenResponse(tokens)
+	res.IDToken = idToken
+	res.Scope = code.Scope
+
+	return res, nil
+}
+
+// exchangeRefreshToken продлевает сессию той же ротацией refresh-токенов, что и RefreshToken.
+// Токен, выданный другому клиенту, отклоняется как invalid_grant
+func (s *Server) exchangeRefreshToken(ctx context.Context, client *db.OAuthClient, form url.Values) (*tokenResponse, error) {
+	raw := form.Get("refresh_token")
+	if raw == "" {
+		return nil, newOAuthError("invalid_request", "refresh_token required")
+	}
+
+	res, err := s.rotateRefreshToken(ctx, raw, &client.ClientId)
+	if err != nil {
+		if status.Code(err) == codes.Internal {
+			return nil, err
+		}
+		return nil, newOAuthError("invalid_grant", "refresh token is invalid or expired")
+	}
+
+	// Сменить пароль приложение не может, пользователь должен войти заново
+	if res.GetPasswordChangeRequired() {
+		return nil, newOAuthError("invalid_grant", "password change required, sign in again")
+	}
+
+	return toTokenResponse(res.GetTokens()), nil
+}
+
+func toTokenResponse(tokens *pb.TokenPair) *tokenResponse {
+	return &tokenResponse{
+		AccessToken:  tokens.GetAccessToken(),
+		TokenType:    tokens.GetTokenType(),
+		ExpiresIn:    int64(time.Until(tokens.GetAccessTokenExpiresAt().AsTime()).Seconds()),
+		RefreshToken: tokens.GetRefreshToken(),
+	}
+}
+
+// handleUserinfo отдает claims владельца access-токена в пределах областей,
+// выданных приложению: name для profile, email и email_verified для email
+func (s *Server) handleUserinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	unauthorized := func() {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	scheme, raw, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		unauthorized()
+		return
+	}
+
+	claims, err := s.issuer.ParseAccessToken(strings.TrimSpace(raw))
+	if err != nil {
+		unauthorized()
+		return
+	}
+
+	active, err := s.sessionActive(r.Context(), claims)
+	if err != nil {
+		s.log.Error("failed to check session revocation",
+			"method", "OAuthUserinfo",
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !active {
+		unauthorized()
+		return
+	}
+
+	user, err := s.storer.GetUserByID(r.Context(), claims.OrgID(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			unauthorized()
+			return
+		}
+
+		s.log.Error("failed to load userinfo subject",
+			"method", "OAuthUserinfo",
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	scopes := strings.Fields(claims.Scope)
+
+	info := map[string]any{
+		"sub": claims.Subject,
+	}
+
+	if slices.Contains(scopes, oidcScopeProfile) {
+		info["name"] = user.Name
+	}
+
+	if slices.Contains(scopes, oidcScopeEmail) {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerifiedAt != nil
+	}
+
+	writeOAuthJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/federation"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/pkg/password"
+	"github.com/rx3lixir/user-service/pkg/secret"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config настройки gRPC сервера пользователей
+type Config struct {
+	Mailer               mailer.Mailer
+	AppBaseURL           string
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+	// SecretBox шифрует секреты 2FA. Без него подключение 2FA недоступно
+	SecretBox *secret.Box
+	// TOTPIssuer имя сервиса, которое видит пользователь в приложении-аутентификаторе
+	TOTPIssuer   string
+	ChallengeTTL time.Duration
+	// LockoutMaxAttempts неудачных попыток подряд, после которых вход блокируется
+	LockoutMaxAttempts int
+	LockoutBaseDelay   time.Duration
+	LockoutMaxDelay    time.Duration
+	PasswordPolicy     password.Policy
+	// Hasher хеширует новые пароли и проверяет старые хеши любых поддерживаемых алгоритмов
+	Hasher password.Hasher
+	// PasswordHistoryDepth сколько последних паролей нельзя использовать повторно. 0 отключает проверку
+	PasswordHistoryDepth     int
+	PasswordHistoryRetention time.Duration
+	// AdminPasswordMaxAge и UserPasswordMaxAge срок действия пароля администратора
+	// и обычного пользователя. 0 означает, что пароль не истекает
+	AdminPasswordMaxAge time.Duration
+	UserPasswordMaxAge  time.Duration
+	// AuthorizationCodeTTL сколько живет код авторизации OpenID Connect до обмена на токены
+	AuthorizationCodeTTL time.Duration
+	// FederatedProviders вышестоящие провайдеры OpenID Connect по имени
+	FederatedProviders map[string]FederatedProvider
+	// FederatedLoginTTL сколько времени дается на вход у вышестоящего провайдера
+	FederatedLoginTTL time.Duration
+	// MagicLinkTTL сколько действует ссылка для входа без пароля
+	MagicLinkTTL time.Duration
+	// MagicLinkMaxPerEmail и MagicLinkMaxPerIP сколько ссылок можно запросить
+	// на один email и с одного адреса за MagicLinkWindow. 0 снимает ограничение
+	MagicLinkMaxPerEmail int
+	MagicLinkMaxPerIP    int
+	MagicLinkWindow      time.Duration
+	// MagicLinkAllowAdmins разрешает входить по ссылке администраторам и обладателям
+	// привилегированных прав. По умолчанию им ссылка не отправляется: доступ
+	// к почте не должен давать эти права
+	MagicLinkAllowAdmins bool
+	// ClientIPHeader заголовок, в котором прокси передает адрес клиента.
+	// Пустой, если сервис принимает соединения напрямую
+	ClientIPHeader string
+	// ImpersonationTTL сколько длится вход администратора под пользователем
+	ImpersonationTTL time.Duration
+	// PermissionCacheTTL сколько CheckPermission помнит роли пользователя.
+	// Изменение ролей сбрасывает кеш сразу, но только в той реплике, которая
+	// его выполнила. Остальные реплики увидят изменение через PermissionCacheTTL
+	PermissionCacheTTL time.Duration
+}
+
+// FederatedProvider вышестоящий провайдер и правила сопоставления его
+// пользователей с локальными
+type FederatedProvider struct {
+	Provider federation.Provider
+	// AllowSignup создает локального пользователя при первом входе
+	AllowSignup bool
+	// LinkByEmail привязывает внешний аккаунт к пользователю с тем же email,
+	// если провайдер подтвердил email. Включать только для доверенных провайдеров
+	LinkByEmail bool
+}
+
+// Option функция для настройки сервера
+type Option func(*Config)
+
+// defaultConfig возвращает конфигурацию по умолчанию
+func defaultConfig() Config {
+	return Config{
+		AppBaseURL:           "http://localhost:3000",
+		PasswordResetTTL:     30 * time.Minute,
+		EmailVerificationTTL: 24 * time.Hour,
+		TOTPIssuer:           "user-service",
+		ChallengeTTL:         5 * time.Minute,
+		LockoutMaxAttempts:   5,
+		LockoutBaseDelay:     time.Minute,
+		LockoutMaxDelay:      time.Hour,
+		PasswordPolicy:       password.DefaultPolicy(),
+		Hasher: password.NewHashers(
+			password.NewArgon2id(password.DefaultArgon2Params()),
+			append([]password.Hasher{password.NewBcrypt(bcrypt.DefaultCost)}, password.LegacyHashers()...)...,
+		),
+		PasswordHistoryDepth:     5,
+		PasswordHistoryRetention: 365 * 24 * time.Hour,
+		AuthorizationCodeTTL:     time.Minute,
+		FederatedProviders:       map[string]FederatedProvider{},
+		FederatedLoginTTL:        10 * time.Minute,
+		MagicLinkTTL:             15 * time.Minute,
+		MagicLinkMaxPerEmail:     5,
+		MagicLinkMaxPerIP:        20,
+		MagicLinkWindow:          time.Hour,
+		ImpersonationTTL:         30 * time.Minute,
+		PermissionCacheTTL:       5 * time.Second,
+		AdminPasswordMaxAge:      90 * 24 * time.Hour,
+	}
+}
+
+// WithMailer устанавливает способ отправки писем. По умолчанию письма пишутся в лог
+func WithMailer(m mailer.Mailer) Option {
+	return func(c *Config) {
+		c.Mailer = m
+	}
+}
+
+// WithAppBaseURL устанавливает адрес фронтенда для ссылок в письмах
+func WithAppBaseURL(url string) Option {
+	return func(c *Config) {
+		c.AppBaseURL = url
+	}
+}
+
+// WithPasswordResetTTL устанавливает время жизни токена сброса пароля
+func WithPasswordResetTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.PasswordResetTTL = ttl
+	}
+}
+
+// WithEmailVerificationTTL устанавливает время жизни ссылки подтверждения email
+func WithEmailVerificationTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.EmailVerificationTTL = ttl
+	}
+}
+
+// WithSecretBox устанавливает шифрование секретов 2FA
+func WithSecretBox(box *secret.Box) Option {
+	return func(c *Config) {
+		c.SecretBox = box
+	}
+}
+
+// WithTOTPIssuer устанавливает имя сервиса в ссылке otpauth://
+func WithTOTPIssuer(issuer string) Option {
+	return func(c *Config) {
+		c.TOTPIssuer = issuer
+	}
+}
+
+// WithChallengeTTL устанавливает, сколько времени дается на ввод второго фактора
+func WithChallengeTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.ChallengeTTL = ttl
+	}
+}
+
+// WithLockout устанавливает порог блокировки входа и границы времени блокировки
+func WithLockout(maxAttempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(c *Config) {
+		c.LockoutMaxAttempts = maxAttempts
+		c.LockoutBaseDelay = baseDelay
+		c.LockoutMaxDelay = maxDelay
+	}
+}
+
+// WithPasswordPolicy устанавливает требования к новым паролям
+func WithPasswordPolicy(p password.Policy) Option {
+	return func(c *Config) {
+		c.PasswordPolicy = p
+	}
+}
+
+// WithPasswordHasher устанавливает алгоритм хеширования паролей
+func WithPasswordHasher(h password.Hasher) Option {
+	return func(c *Config) {
+		c.Hasher = h
+	}
+}
+
+// WithPasswordHistory устанавливает, сколько последних паролей и как долго помнить
+func WithPasswordHistory(depth int, retention time.Duration) Option {
+	return func(c *Config) {
+		c.PasswordHistoryDepth = depth
+		c.PasswordHistoryRetention = retention
+	}
+}
+
+// WithPasswordMaxAge устанавливает срок действия паролей администраторов и обычных пользователей
+func WithPasswordMaxAge(admin, user time.Duration) Option {
+	return func(c *Config) {
+		c.AdminPasswordMaxAge = admin
+		c.UserPasswordMaxAge = user
+	}
+}
+
+// WithAuthorizationCodeTTL устанавливает время жизни кода авторизации OpenID Connect
+func WithAuthorizationCodeTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.AuthorizationCodeTTL = ttl
+	}
+}
+
+// WithFederatedProvider подключает вышестоящего провайдера OpenID Connect
+func WithFederatedProvider(p federation.Provider, allowSignup, linkByEmail bool) Option {
+	return func(c *Config) {
+		c.FederatedProviders[p.Name()] = FederatedProvider{
+			Provider:    p,
+			AllowSignup: allowSignup,
+			LinkByEmail: linkByEmail,
+		}
+	}
+}
+
+// WithFederatedLoginTTL устанавливает, сколько времени дается на вход у вышестоящего провайдера
+func WithFederatedLoginTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.FederatedLoginTTL = ttl
+	}
+}
+
+// WithMagicLinkTTL устанавливает время жизни ссылки для входа без пароля
+func WithMagicLinkTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.MagicLinkTTL = ttl
+	}
+}
+
+// WithMagicLinkRateLimit ограничивает число ссылок для входа на один email и с одного адреса за window
+func WithMagicLinkRateLimit(perEmail, perIP int, window time.Duration) Option {
+	return func(c *Config) {
+		c.MagicLinkMaxPerEmail = perEmail
+		c.MagicLinkMaxPerIP = perIP
+		c.MagicLinkWindow = window
+	}
+}
+
+// WithMagicLinkForAdmins разрешает или запрещает вход по ссылке администраторам
+// и пользователям с привилегированными правами
+func WithMagicLinkForAdmins(allow bool) Option {
+	return func(c *Config) {
+		c.MagicLinkAllowAdmins = allow
+	}
+}
+
+// WithClientIPHeader устанавливает заголовок, из которого берется адрес клиента за прокси
+func WithClientIPHeader(header string) Option {
+	return func(c *Config) {
+		c.ClientIPHeader = header
+	}
+}
+
+// WithImpersonationTTL устанавливает длительность входа администратора под пользователем
+func WithImpersonationTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.ImpersonationTTL = ttl
+	}
+}
+
+// WithPermissionCacheTTL устанавливает, сколько CheckPermission помнит роли пользователя
+func WithPermissionCacheTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.PermissionCacheTTL = ttl
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// organizationHeader метаданные, в которых клиент передает slug организации
+const organizationHeader = "x-organization"
+
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+var (
+	errOrganizationNotFound        = status.Error(codes.NotFound, "organization not found")
+	errOrganizationMismatch        = status.Error(codes.PermissionDenied, "credentials belong to another organization")
+	errOrganizationInternalFailure = status.Error(codes.Internal, "failed to process organization request")
+)
+
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, orgID int) context.Context {
+	return context.WithValue(ctx, tenantKey{}, orgID)
+}
+
+// tenant возвращает организацию, в пределах которой выполняется запрос.
+// Перехватчик кладет ее в контекст, без нее используется организация по умолчанию
+func (s *Server) tenant(ctx context.Context) int {
+	if orgID, ok := ctx.Value(tenantKey{}).(int); ok {
+		return orgID
+	}
+	return db.DefaultOrganizationID
+}
+
+// resolveTenant определяет организацию запроса. Для аутентифицированного
+// вызова это организация principal: указать в метаданных другую нельзя.
+// Для остальных берется slug из метаданных x-organization
+func (s *Server) resolveTenant(ctx context.Context, p *Principal) (int, error) {
+	slug := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(organizationHeader); len(values) > 0 {
+			slug = strings.TrimSpace(values[0])
+		}
+	}
+
+	if slug == "" {
+		if p != nil {
+			return p.OrganizationID, nil
+		}
+		return db.DefaultOrganizationID, nil
+	}
+
+	org, err := s.storer.GetOrganizationBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			s.log.Warn("unknown organization", "slug", slug)
+			return 0, errOrganizationNotFound
+		}
+
+		s.log.Error("failed to resolve organization", "slug", slug, "error", err)
+		return 0, errOrganizationInternalFailure
+	}
+
+	if p != nil && p.OrganizationID != org.Id {
+		s.log.Warn("organization mismatch",
+			"user_id", p.UserID,
+			"organization_id", p.OrganizationID,
+			"requested_organization_id", org.Id,
+		)
+		return 0, errOrganizationMismatch
+	}
+
+	return org.Id, nil
+}
+
+func (s *Server) CreateOrganization(ctx context.Context, req *pb.CreateOrganizationReq) (*pb.Organization, error) {
+	s.log.Info("starting create organization",
+		"method", "CreateOrganization",
+		"slug", req.GetSlug(),
+	)
+
+	if !organizationSlugPattern.MatchString(req.GetSlug()) || req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "slug of lowercase letters, digits or '-' and name required")
+		s.log.Error("invalid arguments for create organization",
+			"method", "CreateOrganization",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	org := &db.Organization{
+		Slug: req.GetSlug(),
+		Name: req.GetName(),
+	}
+
+	if err := s.storer.CreateOrganization(ctx, org); err != nil {
+		if errors.Is(err, db.ErrOrganizationTaken) {
+			return nil, status.Error(codes.AlreadyExists, "organization already exists")
+		}
+
+		s.log.Error("failed to create organization",
+			"method", "CreateOrganization",
+			"slug", org.Slug,
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	s.log.Info("organization created successfully",
+		"method", "CreateOrganization",
+		"organization_id", org.Id,
+		"slug", org.Slug,
+	)
+
+	return toPBOrganization(org), nil
+}
+
+// getOrganization читает организацию и переводит ошибки хранилища в статусы
+func (s *Server) getOrganization(ctx context.Context, id int, method string) (*db.Organization, error) {
+	org, err := s.storer.GetOrganization(ctx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			return nil, errOrganizationNotFound
+		}
+
+		s.log.Error("failed to get organization",
+			"method", method,
+			"organization_id", id,
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	return org, nil
+}
+
+func (s *Server) GetOrganization(ctx context.Context, req *pb.GetOrganizationReq) (*pb.Organization, error) {
+	s.log.Info("starting get organization",
+		"method", "GetOrganization",
+		"organization_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for get organization",
+			"method", "GetOrganization",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	org, err := s.getOrganization(ctx, int(req.GetId()), "GetOrganization")
+	if err != nil {
+		return nil, err
+	}
+
+	return toPBOrganization(org), nil
+}
+
+func (s *Server) ListOrganizations(ctx context.Context, req *pb.ListOrganizationsReq) (*pb.ListOrganizationsRes, error) {
+	s.log.Info("starting list organizations",
+		"method", "ListOrganizations",
+	)
+
+	orgs, err := s.storer.ListOrganizations(ctx)
+	if err != nil {
+		s.log.Error("failed to list organizations",
+			"method", "ListOrganizations",
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	pbOrgs := make([]*pb.Organization, 0, len(orgs))
+
+	for _, org := range orgs {
+		pbOrgs = append(pbOrgs, toPBOrganization(org))
+	}
+
+	return &pb.ListOrganizationsRes{
+		Organizations: pbOrgs,
+	}, nil
+}
+
+func (s *Server) UpdateOrganization(ctx context.Context, req *pb.UpdateOrganizationReq) (*pb.Organization, error) {
+	s.log.Info("starting update organization",
+		"method", "UpdateOrganization",
+		"organization_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for update organization",
+			"method", "UpdateOrganization",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	org, err := s.getOrganization(ctx, int(req.GetId()), "UpdateOrganization")
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetName() != "" {
+		org.Name = req.GetName()
+	}
+
+	if err := s.storer.UpdateOrganization(ctx, org); err != nil {
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			return nil, errOrganizationNotFound
+		}
+
+		s.log.Error("failed to update organization",
+			"method", "UpdateOrganization",
+			"organization_id", org.Id,
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	s.log.Info("organization updated successfully",
+		"method", "UpdateOrganization",
+		"organization_id", org.Id,
+	)
+
+	return toPBOrganization(org), nil
+}
+
+func (s *Server) DeleteOrganization(ctx context.Context, req *pb.DeleteOrganizationReq) (*pb.DeleteOrganizationRes, error) {
+	s.log.Info("starting delete organization",
+		"method", "DeleteOrganization",
+		"organization_id", req.GetId(),
+	)
+
+	if req.GetId() == 0 {
+		err := status.Error(codes.InvalidArgument, "id required")
+		s.log.Error("invalid arguments for delete organization",
+			"method", "DeleteOrganization",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	// В организации по умолчанию оказываются все запросы без x-organization
+	if req.GetId() == db.DefaultOrganizationID {
+		return nil, status.Error(codes.FailedPrecondition, "default organization cannot be deleted")
+	}
+
+	if err := s.storer.DeleteOrganization(ctx, int(req.GetId())); err != nil {
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			return nil, errOrganizationNotFound
+		}
+
+		if errors.Is(err, db.ErrOrganizationNotEmpty) {
+			return nil, status.Error(codes.FailedPrecondition, "organization still has users or oauth clients")
+		}
+
+		s.log.Error("failed to delete organization",
+			"method", "DeleteOrganization",
+			"organization_id", req.GetId(),
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	s.log.Info("organization deleted successfully",
+		"method", "DeleteOrganization",
+		"organization_id", req.GetId(),
+	)
+
+	return &pb.DeleteOrganizationRes{}, nil
+}
+
+func (s *Server) ListOrganizationMembers(ctx context.Context, req *pb.ListOrganizationMembersReq) (*pb.ListOrganizationMembersRes, error) {
+	s.log.Info("starting list organization members",
+		"method", "ListOrganizationMembers",
+		"organization_id", req.GetOrganizationId(),
+	)
+
+	if req.GetOrganizationId() == 0 {
+		err := status.Error(codes.InvalidArgument, "organization id required")
+		s.log.Error("invalid arguments for list organization members",
+			"method", "ListOrganizationMembers",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	org, err := s.getOrganization(ctx, int(req.GetOrganizationId()), "ListOrganizationMembers")
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := s.storer.GetUsers(ctx, org.Id)
+	if err != nil {
+		s.log.Error("failed to list organization members",
+			"method", "ListOrganizationMembers",
+			"organization_id", org.Id,
+			"error", err,
+		)
+		return nil, errOrganizationInternalFailure
+	}
+
+	res := &pb.ListOrganizationMembersRes{
+		Users: make([]*pb.User, 0, len(users)),
+	}
+
+	for _, user := range users {
+		res.Users = append(res.Users, toPBUser(user))
+	}
+
+	return res, nil
+}
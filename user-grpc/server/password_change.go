@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// challengePasswordChange назначение challenge-токена, который завершается вызовом ChangePassword
+const challengePasswordChange = "password_change"
+
+var errPasswordChangeNeedsSession = status.Error(codes.FailedPrecondition, "two-factor authentication is enabled, change the password from a signed-in session")
+
+// passwordMaxAge возвращает срок действия пароля пользователя. 0 - бессрочно
+func (s *Server) passwordMaxAge(user *db.User) time.Duration {
+	if user.IsAdmin {
+		return s.config.AdminPasswordMaxAge
+	}
+
+	return s.config.UserPasswordMaxAge
+}
+
+// passwordChangeRequired сообщает, что перед выдачей сессии пользователь должен сменить пароль:
+// пароль временный, выданный администратором, или истек его срок
+func (s *Server) passwordChangeRequired(user *db.User, now time.Time) (bool, string) {
+	// У сервисных аккаунтов и пользователей внешних провайдеров пароля нет
+	if user.IsServiceAccount || user.Password == "" {
+		return false, ""
+	}
+
+	if user.MustChangePassword {
+		return true, "temporary password"
+	}
+
+	maxAge := s.passwordMaxAge(user)
+	if maxAge > 0 && now.Sub(user.PasswordChangedAt) > maxAge {
+		return true, "password expired"
+	}
+
+	return false, ""
+}
+
+// requirePasswordChange вместо сессии выдает challenge, который можно обменять
+// только на смену пароля
+func (s *Server) requirePasswordChange(user *db.User, reason, method string) (*pb.AuthenticateRes, error) {
+	challenge, expiresAt, err := s.issuer.IssueChallengeToken(user, challengePasswordChange, s.config.ChallengeTTL)
+	if err != nil {
+		s.log.Error("failed to issue challenge",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	s.log.Info("password change required",
+		"method", method,
+		"user_id", user.Id,
+		"reason", reason,
+	)
+
+	return &pb.AuthenticateRes{
+		PasswordChangeRequired: true,
+		ChallengeToken:         challenge,
+		ChallengeExpiresAt:     timestamppb.New(expiresAt),
+	}, nil
+}
+
+// verifyCurrentPassword подтверждает изменение учетных данных текущим паролем.
+// Неверный пароль учитывается в счетчике неудачных входов
+func (s *Server) verifyCurrentPassword(ctx context.Context, user *db.User, plain, method string) error {
+	if plain == "" {
+		return status.Error(codes.InvalidArgument, "current password required")
+	}
+
+	if user.IsLocked(time.Now()) {
+		s.verifyDummy(plain)
+
+		s.log.Warn("credentials change refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "account locked",
+		)
+		s.auditLockedLogin(ctx, user, method)
+		return errInvalidCredentials
+	}
+
+	if ok, _ := s.config.Hasher.Verify(plain, user.Password); !ok {
+		s.log.Warn("credentials change refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "wrong current password",
+		)
+
+		if err := s.registerFailedLogin(ctx, user, method); err != nil {
+			s.log.Error("failed to register failed login",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+		}
+		return errInvalidCredentials
+	}
+
+	return nil
+}
+
+func (s *Server) ChangePassword(ctx context.Context, req *pb.ChangePasswordReq) (*pb.AuthenticateRes, error) {
+	s.log.Info("starting change password",
+		"method", "ChangePassword",
+		"user_id", req.GetUserId(),
+	)
+
+	byChallenge := req.GetChallengeToken() != ""
+	byPassword := req.GetUserId() != 0 && req.GetCurrentPassword() != ""
+
+	if req.GetNewPassword() == "" || byChallenge == byPassword {
+		err := status.Error(codes.InvalidArgument, "new password and either challenge token or user id with current password required")
+		s.log.Error("invalid arguments for change password",
+			"method", "ChangePassword",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	var user *db.User
+
+	if byChallenge {
+		claims, err := s.issuer.ParseChallengeToken(req.GetChallengeToken(), challengePasswordChange)
+		if err != nil {
+			s.log.Warn("invalid challenge token",
+				"method", "ChangePassword",
+				"error", err,
+			)
+			return nil, errInvalidChallenge
+		}
+
+		user, err = s.storer.GetUserByID(ctx, claims.OrgID(), claims.UserID)
+		if err != nil {
+			if errors.Is(err, db.ErrUserNotFound) {
+				return nil, errInvalidChallenge
+			}
+
+			s.log.Error("failed to get user for password change",
+				"method", "ChangePassword",
+				"user_id", claims.UserID,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to change password")
+		}
+	} else {
+		var err error
+		user, err = s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+		if err != nil {
+			if errors.Is(err, db.ErrUserNotFound) {
+				s.verifyDummy(req.GetCurrentPassword())
+				return nil, errInvalidCredentials
+			}
+
+			s.log.Error("failed to get user for password change",
+				"method", "ChangePassword",
+				"user_id", req.GetUserId(),
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to change password")
+		}
+
+		if err := s.verifyCurrentPassword(ctx, user, req.GetCurrentPassword(), "ChangePassword"); err != nil {
+			return nil, err
+		}
+
+		// Одного пароля мало, чтобы сменить его пользователю с 2FA и закрыть
+		// все его сессии: нужен access-токен, выданный после второго фактора
+		enabled, err := s.totpEnabled(ctx, user.Id)
+		if err != nil {
+			s.log.Error("failed to check two-factor status",
+				"method", "ChangePassword",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to change password")
+		}
+
+		if enabled && !signedInAs(ctx, user) {
+			s.log.Warn("password change refused",
+				"method", "ChangePassword",
+				"user_id", user.Id,
+				"reason", "second factor not passed",
+			)
+			return nil, errPasswordChangeNeedsSession
+		}
+	}
+
+	if user.IsServiceAccount {
+		return nil, status.Error(codes.FailedPrecondition, "service account can not have a password")
+	}
+
+	if err := s.checkPasswordPolicy(ctx, "new_password", req.GetNewPassword(), user); err != nil {
+		s.log.Error("password rejected by policy",
+			"method", "ChangePassword",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, err
+	}
+
+	hashedPassword, err := s.config.Hasher.Hash(req.GetNewPassword())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	user.Password = hashedPassword
+	user.PasswordChangedAt = time.Now()
+	user.MustChangePassword = false
+
+	if err := s.storer.UpdatePassword(ctx, user); err != nil {
+		s.log.Error("failed to update password",
+			"method", "ChangePassword",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	if err := s.onPasswordChanged(ctx, user); err != nil {
+		s.log.Error("failed to run password change hooks",
+			"method", "ChangePassword",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	// Сессии, открытые со старым паролем, закрываются
+	if err := s.storer.RevokeUserTokens(ctx, user.Id); err != nil {
+		s.log.Error("failed to revoke sessions after password change",
+			"method", "ChangePassword",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	s.log.Info("password changed successfully",
+		"method", "ChangePassword",
+		"user_id", user.Id,
+	)
+
+	// Смена пароля завершает прерванный вход
+	if byChallenge {
+		return s.startSession(ctx, user, "ChangePassword")
+	}
+
+	return &pb.AuthenticateRes{
+		User: toPBUser(user),
+	}, nil
+}
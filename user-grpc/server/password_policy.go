@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/pkg/password"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ruleReused код нарушения для пароля из истории. Дополняет правила pkg/password
+const ruleReused = "PASSWORD_REUSED"
+
+// checkPasswordPolicy проверяет новый пароль по политике и истории паролей.
+// При нарушениях возвращает InvalidArgument, в деталях которого перечислены
+// все нарушенные правила, чтобы клиент мог показать их сразу, а не по одному.
+// Для нового пользователя (user.Id == 0) история не проверяется
+func (s *Server) checkPasswordPolicy(ctx context.Context, field, plain string, user *db.User) error {
+	// Новые пароли хеширует предпочтительный алгоритм, и его ограничение
+	// в байтах должно проверяться здесь, а не закончиться ошибкой при хешировании
+	policy := s.config.PasswordPolicy
+	if limit := password.MaxBytes(s.config.Hasher); limit > 0 && (policy.MaxBytes == 0 || policy.MaxBytes > limit) {
+		policy.MaxBytes = limit
+	}
+
+	violations := policy.Validate(plain, user.Name, user.Email)
+
+	if user.Id != 0 {
+		reused, err := s.passwordReused(ctx, user, plain)
+		if err != nil {
+			s.log.Error("failed to check password history",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return status.Error(codes.Internal, "failed to check password")
+		}
+
+		if reused {
+			violations = append(violations, password.Violation{
+				Rule:        ruleReused,
+				Description: "password must differ from your recent passwords",
+			})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Description,
+			Reason:      v.Rule,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "password does not meet the password policy")
+
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// passwordReused сверяет пароль с текущим хешем и последними хешами из истории
+func (s *Server) passwordReused(ctx context.Context, user *db.User, plain string) (bool, error) {
+	if s.config.PasswordHistoryDepth == 0 {
+		return false, nil
+	}
+
+	hashes, err := s.storer.GetPasswordHistory(ctx, user.Id, s.config.PasswordHistoryDepth, time.Now().Add(-s.config.PasswordHistoryRetention))
+	if err != nil {
+		return false, err
+	}
+
+	// У пользователей, созданных до появления истории, в ней нет текущего пароля
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+
+	for _, hash := range hashes {
+		if ok, _ := s.config.Hasher.Verify(plain, hash); ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// rememberPassword сохраняет текущий хеш пользователя в историю и удаляет лишние записи
+func (s *Server) rememberPassword(ctx context.Context, user *db.User) error {
+	if s.config.PasswordHistoryDepth == 0 {
+		return nil
+	}
+
+	if err := s.storer.AddPasswordHistory(ctx, user.Id, user.Password); err != nil {
+		return err
+	}
+
+	return s.storer.PrunePasswordHistory(ctx, user.Id, s.config.PasswordHistoryDepth, time.Now().Add(-s.config.PasswordHistoryRetention))
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errInvalidResetToken = status.Error(codes.InvalidArgument, "invalid or expired reset token")
+
+func (s *Server) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetReq) (*pb.RequestPasswordResetRes, error) {
+	s.log.Info("starting request password reset",
+		"method", "RequestPasswordReset",
+		"email", req.GetEmail(),
+	)
+
+	if req.GetEmail() == "" {
+		err := status.Error(codes.InvalidArgument, "email required")
+		s.log.Error("invalid arguments for request password reset",
+			"method", "RequestPasswordReset",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	// Ответ всегда одинаковый, чтобы по нему нельзя было проверить, зарегистрирован ли email
+	user, err := s.storer.GetUserByEmail(ctx, s.tenant(ctx), req.GetEmail())
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			s.log.Info("password reset requested for unknown email",
+				"method", "RequestPasswordReset",
+				"email", req.GetEmail(),
+			)
+			return &pb.RequestPasswordResetRes{}, nil
+		}
+
+		s.log.Error("failed to get user for password reset",
+			"method", "RequestPasswordReset",
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	// У сервисного аккаунта нет пароля, который можно было бы сбросить
+	if user.IsServiceAccount {
+		s.log.Info("password reset requested for service account",
+			"method", "RequestPasswordReset",
+			"user_id", user.Id,
+		)
+		return &pb.RequestPasswordResetRes{}, nil
+	}
+
+	// Действительна только последняя выданная ссылка
+	if err := s.storer.InvalidatePasswordResetTokens(ctx, user.Id); err != nil {
+		s.log.Error("failed to invalidate previous reset tokens",
+			"method", "RequestPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	resetToken := &db.PasswordResetToken{
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(s.config.PasswordResetTTL),
+	}
+
+	if err := s.storer.CreatePasswordResetToken(ctx, resetToken); err != nil {
+		s.log.Error("failed to create reset token",
+			"method", "RequestPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf(
+			"Hello, %s!\n\nTo reset your password follow the link below. It is valid for %s.\n\n%s\n\nIf you did not request a password reset, ignore this message.\n",
+			user.Name,
+			s.config.PasswordResetTTL,
+			s.link("/reset-password", plain),
+		),
+	}
+
+	if err := s.config.Mailer.Send(ctx, msg); err != nil {
+		s.log.Error("failed to send password reset mail",
+			"method", "RequestPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to request password reset")
+	}
+
+	s.log.Info("password reset requested",
+		"method", "RequestPasswordReset",
+		"user_id", user.Id,
+	)
+
+	return &pb.RequestPasswordResetRes{}, nil
+}
+
+func (s *Server) ConfirmPasswordReset(ctx context.Context, req *pb.ConfirmPasswordResetReq) (*pb.ConfirmPasswordResetRes, error) {
+	s.log.Info("starting confirm password reset",
+		"method", "ConfirmPasswordReset",
+	)
+
+	if req.GetToken() == "" || req.GetNewPassword() == "" {
+		err := status.Error(codes.InvalidArgument, "token and new password required")
+		s.log.Error("invalid arguments for confirm password reset",
+			"method", "ConfirmPasswordReset",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	resetToken, err := s.storer.GetPasswordResetTokenByHash(ctx, token.HashToken(req.GetToken()))
+	if err != nil {
+		if errors.Is(err, db.ErrTokenNotFound) {
+			return nil, errInvalidResetToken
+		}
+
+		s.log.Error("failed to get reset token",
+			"method", "ConfirmPasswordReset",
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	// Ссылку открывают без заголовка организации, поэтому она берется из токена
+	ctx = withTenant(ctx, resetToken.OrganizationId)
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), resetToken.UserId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, errInvalidResetToken
+		}
+
+		s.log.Error("failed to get user for password reset",
+			"method", "ConfirmPasswordReset",
+			"user_id", resetToken.UserId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	// Ссылка, отправленная на прежний адрес, не должна сбрасывать пароль после смены email
+	if !strings.EqualFold(user.Email, resetToken.Email) {
+		s.log.Warn("reset token refused",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"reason", "email changed",
+		)
+		return nil, errInvalidResetToken
+	}
+
+	if err := s.checkPasswordPolicy(ctx, "new_password", req.GetNewPassword(), user); err != nil {
+		s.log.Error("password rejected by policy",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, err
+	}
+
+	hashedPassword, err := s.config.Hasher.Hash(req.GetNewPassword())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	// Гасим токен атомарно: из двух параллельных запросов пройдет только один
+	marked, err := s.storer.MarkPasswordResetTokenUsed(ctx, resetToken.Id)
+	if err != nil {
+		s.log.Error("failed to mark reset token used",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	if !marked {
+		s.log.Warn("reset token is used or expired",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+		)
+		return nil, errInvalidResetToken
+	}
+
+	// Пароль выбрал сам владелец, менять его при входе уже не нужно
+	user.Password = hashedPassword
+	user.PasswordChangedAt = time.Now()
+	user.MustChangePassword = false
+
+	if err := s.storer.UpdatePassword(ctx, user); err != nil {
+		s.log.Error("failed to update password",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	if err := s.onPasswordChanged(ctx, user); err != nil {
+		s.log.Error("failed to run password change hooks",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	// Тот, кто сбрасывает пароль, мог его потерять вместе с устройством,
+	// поэтому все открытые сессии закрываются
+	if err := s.storer.RevokeUserTokens(ctx, user.Id); err != nil {
+		s.log.Error("failed to revoke sessions after password reset",
+			"method", "ConfirmPasswordReset",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	s.log.Info("password reset successfully",
+		"method", "ConfirmPasswordReset",
+		"user_id", user.Id,
+	)
+
+	return &pb.ConfirmPasswordResetRes{}, nil
+}
+
+// onPasswordChanged вызывается после любой смены пароля пользователя.
+// Флаг обязательной смены сохраняется вместе с паролем, здесь его не трогают
+func (s *Server) onPasswordChanged(ctx context.Context, user *db.User) error {
+	// Ссылки на сброс, выданные до смены пароля, больше не должны работать
+	if err := s.storer.InvalidatePasswordResetTokens(ctx, user.Id); err != nil {
+		return err
+	}
+
+	if err := s.rememberPassword(ctx, user); err != nil {
+		return err
+	}
+
+	// С новым паролем старые неудачные попытки больше не в счет
+	return s.storer.ResetFailedLogins(ctx, user.OrganizationId, user.Id)
+}
+
+// link собирает ссылку на фронтенд с одноразовым токеном
+func (s *Server) link(path, plainToken string) string {
+	return s.config.AppBaseURL + path + "?token=" + url.QueryEscape(plainToken)
+}
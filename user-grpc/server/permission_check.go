@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxPermissionChecks сколько проверок можно передать в одном CheckPermissions
+	maxPermissionChecks = 100
+	// maxPermissionCacheEntries после стольких записей кеш удаляет истекшие
+	maxPermissionCacheEntries = 10000
+)
+
+var errPermissionCheckInternalFailure = status.Error(codes.Internal, "failed to check permission")
+
+// userAccess роли пользователя, по которым принимаются решения о доступе
+type userAccess struct {
+	organizationID int
+	isAdmin        bool
+	roles          []*db.Role
+	expiresAt      time.Time
+}
+
+// permissionCache хранит роли пользователей в течение ttl. Кеш живет в памяти
+// процесса: изменение ролей сбрасывает записи сразу только в этой реплике.
+// В других репликах и при правках в обход сервиса устаревание ограничено ttl,
+// поэтому ttl должен быть коротким
+type permissionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[int]*userAccess
+	// generation растет при каждом сбросе, чтобы роли, прочитанные до сброса,
+	// не попали в кеш после него
+	generation uint64
+}
+
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		entries: make(map[int]*userAccess),
+	}
+}
+
+// get возвращает роли пользователя и поколение кеша, с которым нужно
+// сохранить их, если в кеше ничего нет
+func (c *permissionCache) get(userID int) (*userAccess, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	access, ok := c.entries[userID]
+	if ok && time.Now().After(access.expiresAt) {
+		delete(c.entries, userID)
+		ok = false
+	}
+
+	return access, c.generation, ok
+}
+
+func (c *permissionCache) set(userID int, access *userAccess, generation uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	now := time.Now()
+
+	if len(c.entries) >= maxPermissionCacheEntries {
+		for id, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+	}
+
+	access.expiresAt = now.Add(c.ttl)
+	c.entries[userID] = access
+}
+
+// invalidate сбрасывает роли одного пользователя
+func (c *permissionCache) invalidate(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.generation++
+}
+
+// purge сбрасывает весь кеш, когда меняются права самих ролей
+func (c *permissionCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.generation++
+}
+
+// userAccess возвращает роли пользователя текущей организации из кеша или
+// хранилища. Для неизвестного пользователя возвращает nil без ошибки
+func (s *Server) userAccess(ctx context.Context, userID int) (*userAccess, error) {
+	orgID := s.tenant(ctx)
+
+	access, generation, ok := s.permissionCache.get(userID)
+	if ok {
+		if access.organizationID != orgID {
+			return nil, nil
+		}
+		return access, nil
+	}
+
+	user, err := s.storer.GetUserByID(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	roles, err := s.storer.ListUserRoles(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	access = &userAccess{
+		organizationID: user.OrganizationId,
+		isAdmin:        user.IsAdmin,
+		roles:          roles,
+	}
+
+	s.permissionCache.set(user.Id, access, generation)
+
+	return access, nil
+}
+
+// decidePermission проверяет одно право и объясняет решение
+func (s *Server) decidePermission(ctx context.Context, check *pb.CheckPermissionReq) (*pb.PermissionDecision, error) {
+	decision := &pb.PermissionDecision{
+		UserId:   check.GetUserId(),
+		Action:   check.GetAction(),
+		Resource: check.GetResource(),
+	}
+
+	access, err := s.userAccess(ctx, int(check.GetUserId()))
+	if err != nil {
+		return nil, err
+	}
+
+	if access == nil {
+		decision.Reason = "user not found"
+		return decision, nil
+	}
+
+	// Роль admin получает все права, в том числе еще не заведенные
+	if access.isAdmin {
+		decision.Allowed = true
+		decision.Role = db.RoleAdmin
+		decision.Reason = "user is an administrator"
+		return decision, nil
+	}
+
+	permission := check.GetResource() + ":" + check.GetAction()
+
+	for _, role := range access.roles {
+		if slices.Contains(role.Permissions, permission) {
+			decision.Allowed = true
+			decision.Role = role.Name
+			decision.Reason = fmt.Sprintf("granted by role %s", role.Name)
+			return decision, nil
+		}
+	}
+
+	if len(access.roles) == 0 {
+		decision.Reason = "user has no roles"
+	} else {
+		decision.Reason = fmt.Sprintf("no role grants %s", permission)
+	}
+
+	return decision, nil
+}
+
+// validatePermissionCheck проверяет аргументы одной проверки
+func validatePermissionCheck(check *pb.CheckPermissionReq) error {
+	if check.GetUserId() == 0 || check.GetAction() == "" || check.GetResource() == "" {
+		return status.Error(codes.InvalidArgument, "user id, action and resource required")
+	}
+	return nil
+}
+
+// CheckPermission отвечает другим сервисам, может ли пользователь выполнить
+// действие над ресурсом, с учетом его ролей и флага администратора
+func (s *Server) CheckPermission(ctx context.Context, req *pb.CheckPermissionReq) (*pb.PermissionDecision, error) {
+	s.log.Info("starting check permission",
+		"method", "CheckPermission",
+		"user_id", req.GetUserId(),
+		"action", req.GetAction(),
+		"resource", req.GetResource(),
+	)
+
+	if err := validatePermissionCheck(req); err != nil {
+		s.log.Error("invalid arguments for check permission",
+			"method", "CheckPermission",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	decision, err := s.decidePermission(ctx, req)
+	if err != nil {
+		s.log.Error("failed to check permission",
+			"method", "CheckPermission",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errPermissionCheckInternalFailure
+	}
+
+	s.log.Info("permission checked",
+		"method", "CheckPermission",
+		"user_id", decision.UserId,
+		"allowed", decision.Allowed,
+		"reason", decision.Reason,
+	)
+
+	return decision, nil
+}
+
+// CheckPermissions проверяет несколько прав за один вызов. Роли каждого
+// пользователя читаются один раз и дальше берутся из кеша
+func (s *Server) CheckPermissions(ctx context.Context, req *pb.CheckPermissionsReq) (*pb.CheckPermissionsRes, error) {
+	s.log.Info("starting check permissions",
+		"method", "CheckPermissions",
+		"checks", len(req.GetChecks()),
+	)
+
+	if len(req.GetChecks()) == 0 || len(req.GetChecks()) > maxPermissionChecks {
+		err := status.Errorf(codes.InvalidArgument, "between 1 and %d checks required", maxPermissionChecks)
+		s.log.Error("invalid arguments for check permissions",
+			"method", "CheckPermissions",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	for i, check := range req.GetChecks() {
+		if err := validatePermissionCheck(check); err != nil {
+			err = status.Errorf(codes.InvalidArgument, "check %d: user id, action and resource required", i)
+			s.log.Error("invalid arguments for check permissions",
+				"method", "CheckPermissions",
+				"error", err,
+			)
+			return nil, err
+		}
+	}
+
+	res := &pb.CheckPermissionsRes{
+		Decisions: make([]*pb.PermissionDecision, 0, len(req.GetChecks())),
+	}
+
+	for _, check := range req.GetChecks() {
+		decision, err := s.decidePermission(ctx, check)
+		if err != nil {
+			s.log.Error("failed to check permission",
+				"method", "CheckPermissions",
+				"user_id", check.GetUserId(),
+				"error", err,
+			)
+			return nil, errPermissionCheckInternalFailure
+		}
+
+		res.Decisions = append(res.Decisions, decision)
+	}
+
+	s.log.Info("permissions checked",
+		"method", "CheckPermissions",
+		"checks", len(res.Decisions),
+	)
+
+	return res, nil
+}
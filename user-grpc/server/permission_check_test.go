@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermissionCacheInvalidate(t *testing.T) {
+	c := newPermissionCache(time.Minute)
+
+	_, generation, _ := c.get(1)
+	c.set(1, &userAccess{organizationID: 1}, generation)
+	c.set(2, &userAccess{organizationID: 1}, generation)
+
+	c.invalidate(1)
+
+	if _, _, ok := c.get(1); ok {
+		t.Error("invalidated user is still cached")
+	}
+
+	if _, _, ok := c.get(2); !ok {
+		t.Error("invalidate dropped another user")
+	}
+}
+
+func TestPermissionCachePurge(t *testing.T) {
+	c := newPermissionCache(time.Minute)
+
+	_, generation, _ := c.get(1)
+	c.set(1, &userAccess{}, generation)
+	c.set(2, &userAccess{}, generation)
+
+	c.purge()
+
+	for _, id := range []int{1, 2} {
+		if _, _, ok := c.get(id); ok {
+			t.Errorf("user %d is cached after purge", id)
+		}
+	}
+}
+
+func TestPermissionCacheSkipsStaleSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset func(c *permissionCache)
+	}{
+		{"invalidate", func(c *permissionCache) { c.invalidate(1) }},
+		{"purge", func(c *permissionCache) { c.purge() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newPermissionCache(time.Minute)
+
+			// Роли прочитаны до сброса и не должны попасть в кеш после него
+			_, generation, _ := c.get(1)
+			tt.reset(c)
+			c.set(1, &userAccess{isAdmin: true}, generation)
+
+			if _, _, ok := c.get(1); ok {
+				t.Error("roles read before the reset were cached")
+			}
+		})
+	}
+}
+
+func TestPermissionCacheExpires(t *testing.T) {
+	c := newPermissionCache(time.Minute)
+
+	_, generation, _ := c.get(1)
+	c.set(1, &userAccess{}, generation)
+
+	c.mu.Lock()
+	c.entries[1].expiresAt = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+
+	if _, _, ok := c.get(1); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestPermissionCacheDisabled(t *testing.T) {
+	c := newPermissionCache(0)
+
+	_, generation, _ := c.get(1)
+	c.set(1, &userAccess{}, generation)
+
+	if _, _, ok := c.get(1); ok {
+		t.Error("cache with zero ttl stored an entry")
+	}
+}
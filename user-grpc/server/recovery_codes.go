@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/pkg/password"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoveryCodeCount сколько кодов восстановления выдается пользователю
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// normalizeRecoveryCode приводит код к виду, в котором хранится его хеш:
+// пользователь может ввести его в любом регистре, с дефисом или без
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// generateRecoveryCodes создает новый набор кодов и заменяет им старый.
+// Возвращает коды в открытом виде для однократного показа пользователю
+func (s *Server) generateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	plain := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		hash, err := password.Hash(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		plain = append(plain, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hash)
+	}
+
+	if err := s.storer.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+// useRecoveryCode ищет среди неиспользованных кодов подходящий и гасит его
+func (s *Server) useRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	stored, err := s.storer.GetUnusedRecoveryCodes(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	normalized := normalizeRecoveryCode(code)
+
+	for _, c := range stored {
+		if !password.Verify(normalized, c.CodeHash) {
+			continue
+		}
+
+		marked, err := s.storer.MarkRecoveryCodeUsed(ctx, c.Id)
+		if err != nil {
+			return false, err
+		}
+
+		if !marked {
+			return false, nil
+		}
+
+		remaining, err := s.storer.CountUnusedRecoveryCodes(ctx, userID)
+		if err != nil {
+			s.log.Error("failed to count recovery codes", "user_id", userID, "error", err)
+		}
+
+		s.audit(ctx, userID, userID, db.AuditRecoveryCodeUsed, map[string]any{
+			"remaining": remaining,
+		})
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// fillTwoFactorInfo дополняет профиль состоянием 2FA и числом оставшихся кодов восстановления
+func (s *Server) fillTwoFactorInfo(ctx context.Context, res *pb.User) error {
+	enabled, err := s.totpEnabled(ctx, int(res.GetId()))
+	if err != nil {
+		return err
+	}
+
+	res.TwoFactorEnabled = enabled
+	if !enabled {
+		return nil
+	}
+
+	remaining, err := s.storer.CountUnusedRecoveryCodes(ctx, int(res.GetId()))
+	if err != nil {
+		return err
+	}
+
+	res.RecoveryCodesRemaining = int32(remaining)
+
+	return nil
+}
+
+// audit пишет запись в журнал аудита. Сбой записи не прерывает операцию,
+// но логируется как ошибка
+func (s *Server) audit(ctx context.Context, userID, actorID int, action string, details map[string]any) {
+	// Действие в сессии входа под пользователем помечается администратором,
+	// который на самом деле его выполнил
+	if p, ok := PrincipalFromContext(ctx); ok && p.IsImpersonated() {
+		tagged := make(map[string]any, len(details)+2)
+		maps.Copy(tagged, details)
+		tagged["impersonator_id"] = p.ActorID
+		tagged["impersonation_session_id"] = p.SessionID
+		details = tagged
+	}
+
+	entry := &db.AuditEntry{
+		UserId:  &userID,
+		ActorId: &actorID,
+		Action:  action,
+		Details: details,
+	}
+
+	if err := s.storer.CreateAuditEntry(ctx, entry); err != nil {
+		s.log.Error("failed to write audit entry",
+			"action", action,
+			"user_id", userID,
+			"actor_id", actorID,
+			"error", err,
+		)
+	}
+}
+
+func (s *Server) RegenerateRecoveryCodes(ctx context.Context, req *pb.RegenerateRecoveryCodesReq) (*pb.RegenerateRecoveryCodesRes, error) {
+	s.log.Info("starting regenerate recovery codes",
+		"method", "RegenerateRecoveryCodes",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 || req.GetCode() == "" {
+		err := status.Error(codes.InvalidArgument, "user id and code required")
+		s.log.Error("invalid arguments for regenerate recovery codes",
+			"method", "RegenerateRecoveryCodes",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errTOTPNotConfigured
+	}
+
+	t, err := s.storer.GetTOTP(ctx, int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPNotFound) {
+			return nil, errTOTPNotEnabled
+		}
+
+		s.log.Error("failed to get totp",
+			"method", "RegenerateRecoveryCodes",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if t.ConfirmedAt == nil {
+		return nil, errTOTPNotEnabled
+	}
+
+	ok, err := s.checkTOTPCode(ctx, t, req.GetCode())
+	if err != nil {
+		s.log.Error("failed to check totp code",
+			"method", "RegenerateRecoveryCodes",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if !ok {
+		return nil, errInvalidTOTPCode
+	}
+
+	recoveryCodes, err := s.generateRecoveryCodes(ctx, t.UserId)
+	if err != nil {
+		s.log.Error("failed to generate recovery codes",
+			"method", "RegenerateRecoveryCodes",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	s.audit(ctx, t.UserId, t.UserId, db.AuditRecoveryCodesRegenerated, nil)
+
+	s.log.Info("recovery codes regenerated",
+		"method", "RegenerateRecoveryCodes",
+		"user_id", t.UserId,
+	)
+
+	return &pb.RegenerateRecoveryCodesRes{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
@@ -0,0 +1,558 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	roleNamePattern       = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+	permissionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(:[a-z][a-z0-9_]*)+$`)
+)
+
+var (
+	errRoleNotFound        = status.Error(codes.NotFound, "role not found")
+	errPermissionNotFound  = status.Error(codes.NotFound, "permission not found")
+	errRoleInternalFailure = status.Error(codes.Internal, "failed to process role request")
+)
+
+// callerID возвращает пользователя, от имени которого выполняется запрос, или 0
+func callerID(ctx context.Context) int {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return p.UserID
+	}
+	return 0
+}
+
+// checkPermissionsExist проверяет, что все права заведены
+func (s *Server) checkPermissionsExist(ctx context.Context, names []string) error {
+	permissions, err := s.storer.ListPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		known[p.Name] = true
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			return status.Errorf(codes.InvalidArgument, "unknown permission %q", name)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) CreateRole(ctx context.Context, req *pb.CreateRoleReq) (*pb.Role, error) {
+	s.log.Info("starting create role",
+		"method", "CreateRole",
+		"name", req.GetName(),
+		"permissions", req.GetPermissions(),
+	)
+
+	if !roleNamePattern.MatchString(req.GetName()) {
+		err := status.Error(codes.InvalidArgument, "role name must be lowercase letters, digits, '-' or '_'")
+		s.log.Error("invalid arguments for create role",
+			"method", "CreateRole",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.checkPermissionsExist(ctx, req.GetPermissions()); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			s.log.Error("invalid arguments for create role",
+				"method", "CreateRole",
+				"error", err,
+			)
+			return nil, err
+		}
+
+		s.log.Error("failed to check permissions",
+			"method", "CreateRole",
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	role := &db.Role{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Permissions: req.GetPermissions(),
+	}
+
+	if err := s.storer.CreateRole(ctx, role); err != nil {
+		if errors.Is(err, db.ErrRoleTaken) {
+			return nil, status.Error(codes.AlreadyExists, "role already exists")
+		}
+
+		s.log.Error("failed to create role",
+			"method", "CreateRole",
+			"name", role.Name,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	s.log.Info("role created successfully",
+		"method", "CreateRole",
+		"role_id", role.Id,
+		"name", role.Name,
+	)
+
+	return s.getRole(ctx, role.Name, "CreateRole")
+}
+
+// getRole читает роль и переводит ошибки хранилища в статусы
+func (s *Server) getRole(ctx context.Context, name, method string) (*pb.Role, error) {
+	role, err := s.storer.GetRole(ctx, name)
+	if err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			return nil, errRoleNotFound
+		}
+
+		s.log.Error("failed to get role",
+			"method", method,
+			"name", name,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	return toPBRole(role), nil
+}
+
+func (s *Server) GetRole(ctx context.Context, req *pb.GetRoleReq) (*pb.Role, error) {
+	s.log.Info("starting get role",
+		"method", "GetRole",
+		"name", req.GetName(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for get role",
+			"method", "GetRole",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	return s.getRole(ctx, req.GetName(), "GetRole")
+}
+
+func (s *Server) ListRoles(ctx context.Context, req *pb.ListRolesReq) (*pb.ListRolesRes, error) {
+	s.log.Info("starting list roles",
+		"method", "ListRoles",
+	)
+
+	roles, err := s.storer.ListRoles(ctx)
+	if err != nil {
+		s.log.Error("failed to list roles",
+			"method", "ListRoles",
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	pbRoles := make([]*pb.Role, 0, len(roles))
+
+	for _, role := range roles {
+		pbRoles = append(pbRoles, toPBRole(role))
+	}
+
+	return &pb.ListRolesRes{
+		Roles: pbRoles,
+	}, nil
+}
+
+func (s *Server) UpdateRole(ctx context.Context, req *pb.UpdateRoleReq) (*pb.Role, error) {
+	s.log.Info("starting update role",
+		"method", "UpdateRole",
+		"name", req.GetName(),
+		"permissions", req.GetPermissions(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for update role",
+			"method", "UpdateRole",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	role, err := s.storer.GetRole(ctx, req.GetName())
+	if err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			return nil, errRoleNotFound
+		}
+
+		s.log.Error("failed to get role for update",
+			"method", "UpdateRole",
+			"name", req.GetName(),
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	// Обновляем только заполненные поля
+	if req.GetDescription() != "" {
+		role.Description = req.GetDescription()
+
+		if err := s.storer.UpdateRole(ctx, role); err != nil {
+			s.log.Error("failed to update role",
+				"method", "UpdateRole",
+				"role_id", role.Id,
+				"error", err,
+			)
+			return nil, errRoleInternalFailure
+		}
+	}
+
+	if len(req.GetPermissions()) > 0 {
+		// У admin всегда все права, иначе можно остаться без доступа к управлению ролями
+		if role.Name == db.RoleAdmin {
+			return nil, status.Error(codes.FailedPrecondition, "permissions of the admin role can not be changed")
+		}
+
+		if err := s.checkPermissionsExist(ctx, req.GetPermissions()); err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				s.log.Error("invalid arguments for update role",
+					"method", "UpdateRole",
+					"error", err,
+				)
+				return nil, err
+			}
+
+			s.log.Error("failed to check permissions",
+				"method", "UpdateRole",
+				"error", err,
+			)
+			return nil, errRoleInternalFailure
+		}
+
+		if err := s.storer.SetRolePermissions(ctx, role.Id, req.GetPermissions()); err != nil {
+			s.log.Error("failed to set role permissions",
+				"method", "UpdateRole",
+				"role_id", role.Id,
+				"error", err,
+			)
+			return nil, errRoleInternalFailure
+		}
+
+		s.permissionCache.purge()
+	}
+
+	s.log.Info("role updated successfully",
+		"method", "UpdateRole",
+		"role_id", role.Id,
+	)
+
+	return s.getRole(ctx, role.Name, "UpdateRole")
+}
+
+func (s *Server) DeleteRole(ctx context.Context, req *pb.DeleteRoleReq) (*pb.DeleteRoleRes, error) {
+	s.log.Info("starting delete role",
+		"method", "DeleteRole",
+		"name", req.GetName(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for delete role",
+			"method", "DeleteRole",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if req.GetName() == db.RoleAdmin {
+		return nil, status.Error(codes.FailedPrecondition, "admin role can not be deleted")
+	}
+
+	if err := s.storer.DeleteRole(ctx, req.GetName()); err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			return nil, errRoleNotFound
+		}
+
+		s.log.Error("failed to delete role",
+			"method", "DeleteRole",
+			"name", req.GetName(),
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	s.permissionCache.purge()
+
+	s.log.Info("role deleted successfully",
+		"method", "DeleteRole",
+		"name", req.GetName(),
+	)
+
+	return &pb.DeleteRoleRes{}, nil
+}
+
+// CreatePermission заводит новое право. Права проверяют и другие сервисы,
+// поэтому набор не ограничен правами самого сервиса пользователей
+func (s *Server) CreatePermission(ctx context.Context, req *pb.CreatePermissionReq) (*pb.Permission, error) {
+	s.log.Info("starting create permission",
+		"method", "CreatePermission",
+		"name", req.GetName(),
+	)
+
+	if len(req.GetName()) > 128 || !permissionNamePattern.MatchString(req.GetName()) {
+		err := status.Error(codes.InvalidArgument, "permission name must look like resource:action")
+		s.log.Error("invalid arguments for create permission",
+			"method", "CreatePermission",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	permission := &db.Permission{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+	}
+
+	if err := s.storer.CreatePermission(ctx, permission); err != nil {
+		if errors.Is(err, db.ErrPermissionTaken) {
+			return nil, status.Error(codes.AlreadyExists, "permission already exists")
+		}
+
+		s.log.Error("failed to create permission",
+			"method", "CreatePermission",
+			"name", permission.Name,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	s.log.Info("permission created successfully",
+		"method", "CreatePermission",
+		"permission_id", permission.Id,
+		"name", permission.Name,
+	)
+
+	return toPBPermission(permission), nil
+}
+
+func (s *Server) ListPermissions(ctx context.Context, req *pb.ListPermissionsReq) (*pb.ListPermissionsRes, error) {
+	s.log.Info("starting list permissions",
+		"method", "ListPermissions",
+	)
+
+	permissions, err := s.storer.ListPermissions(ctx)
+	if err != nil {
+		s.log.Error("failed to list permissions",
+			"method", "ListPermissions",
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	pbPermissions := make([]*pb.Permission, 0, len(permissions))
+
+	for _, permission := range permissions {
+		pbPermissions = append(pbPermissions, toPBPermission(permission))
+	}
+
+	return &pb.ListPermissionsRes{
+		Permissions: pbPermissions,
+	}, nil
+}
+
+// DeletePermission удаляет право из всех ролей
+func (s *Server) DeletePermission(ctx context.Context, req *pb.DeletePermissionReq) (*pb.DeletePermissionRes, error) {
+	s.log.Info("starting delete permission",
+		"method", "DeletePermission",
+		"name", req.GetName(),
+	)
+
+	if req.GetName() == "" {
+		err := status.Error(codes.InvalidArgument, "name required")
+		s.log.Error("invalid arguments for delete permission",
+			"method", "DeletePermission",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if err := s.storer.DeletePermission(ctx, req.GetName()); err != nil {
+		if errors.Is(err, db.ErrPermissionNotFound) {
+			return nil, errPermissionNotFound
+		}
+
+		s.log.Error("failed to delete permission",
+			"method", "DeletePermission",
+			"name", req.GetName(),
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	s.permissionCache.purge()
+
+	s.log.Info("permission deleted successfully",
+		"method", "DeletePermission",
+		"name", req.GetName(),
+	)
+
+	return &pb.DeletePermissionRes{}, nil
+}
+
+func (s *Server) AssignRole(ctx context.Context, req *pb.AssignRoleReq) (*pb.User, error) {
+	s.log.Info("starting assign role",
+		"method", "AssignRole",
+		"user_id", req.GetUserId(),
+		"role", req.GetRole(),
+	)
+
+	user, role, err := s.userAndRole(ctx, req.GetUserId(), req.GetRole(), "AssignRole")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storer.AssignRole(ctx, user.Id, role.Id); err != nil {
+		s.log.Error("failed to assign role",
+			"method", "AssignRole",
+			"user_id", user.Id,
+			"role_id", role.Id,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	s.permissionCache.invalidate(user.Id)
+
+	s.audit(ctx, user.Id, callerID(ctx), db.AuditRoleAssigned, map[string]any{
+		"role": role.Name,
+	})
+
+	s.log.Info("role assigned successfully",
+		"method", "AssignRole",
+		"user_id", user.Id,
+		"role", role.Name,
+	)
+
+	return s.userWithRoles(ctx, user.Id, "AssignRole")
+}
+
+func (s *Server) UnassignRole(ctx context.Context, req *pb.UnassignRoleReq) (*pb.User, error) {
+	s.log.Info("starting unassign role",
+		"method", "UnassignRole",
+		"user_id", req.GetUserId(),
+		"role", req.GetRole(),
+	)
+
+	user, role, err := s.userAndRole(ctx, req.GetUserId(), req.GetRole(), "UnassignRole")
+	if err != nil {
+		return nil, err
+	}
+
+	var removed bool
+
+	// Без последнего администратора управлять ролями станет некому. Проверка
+	// и удаление идут одним запросом, чтобы два параллельных снятия не прошли оба
+	if role.Name == db.RoleAdmin {
+		removed, err = s.storer.UnassignRoleKeepingLast(ctx, user.Id, role.Id)
+	} else {
+		removed, err = s.storer.UnassignRole(ctx, user.Id, role.Id)
+	}
+
+	if err != nil {
+		if errors.Is(err, db.ErrLastRoleMember) {
+			return nil, status.Error(codes.FailedPrecondition, "can not remove the last administrator")
+		}
+
+		s.log.Error("failed to unassign role",
+			"method", "UnassignRole",
+			"user_id", user.Id,
+			"role_id", role.Id,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	if removed {
+		s.permissionCache.invalidate(user.Id)
+
+		s.audit(ctx, user.Id, callerID(ctx), db.AuditRoleUnassigned, map[string]any{
+			"role": role.Name,
+		})
+
+		s.log.Info("role unassigned successfully",
+			"method", "UnassignRole",
+			"user_id", user.Id,
+			"role", role.Name,
+		)
+	}
+
+	return s.userWithRoles(ctx, user.Id, "UnassignRole")
+}
+
+// userAndRole проверяет аргументы назначения роли и загружает пользователя и роль
+func (s *Server) userAndRole(ctx context.Context, userID int64, roleName, method string) (*db.User, *db.Role, error) {
+	if userID == 0 || roleName == "" {
+		err := status.Error(codes.InvalidArgument, "user id and role required")
+		s.log.Error("invalid arguments for role assignment",
+			"method", method,
+			"error", err,
+		)
+		return nil, nil, err
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(userID))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user",
+			"method", method,
+			"user_id", userID,
+			"error", err,
+		)
+		return nil, nil, errRoleInternalFailure
+	}
+
+	role, err := s.storer.GetRole(ctx, roleName)
+	if err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			return nil, nil, errRoleNotFound
+		}
+
+		s.log.Error("failed to get role",
+			"method", method,
+			"role", roleName,
+			"error", err,
+		)
+		return nil, nil, errRoleInternalFailure
+	}
+
+	return user, role, nil
+}
+
+// userWithRoles перечитывает пользователя, чтобы ответ содержал актуальные роли
+func (s *Server) userWithRoles(ctx context.Context, userID int, method string) (*pb.User, error) {
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), userID)
+	if err != nil {
+		s.log.Error("failed to reload user",
+			"method", method,
+			"user_id", userID,
+			"error", err,
+		)
+		return nil, errRoleInternalFailure
+	}
+
+	return toPBUser(user), nil
+}
@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/mailer"
+	"github.com/rx3lixir/user-service/internal/token"
 	"github.com/rx3lixir/user-service/pkg/logger"
-	"github.com/rx3lixir/user-service/pkg/password"
 	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
 
 	"google.golang.org/grpc/codes"
@@ -13,38 +17,133 @@ import (
 )
 
 type Server struct {
-	storer db.UserStore
+	config Config
+	storer db.Store
+	issuer *token.Issuer
 	pb.UnimplementedUserServiceServer
 	log logger.Logger
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+
+	permissionCache *permissionCache
 }
 
-func NewServer(storer db.UserStore, log logger.Logger) *Server {
+func NewServer(storer db.Store, issuer *token.Issuer, log logger.Logger, opts ...Option) *Server {
+	config := defaultConfig()
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.Mailer == nil {
+		config.Mailer = mailer.NewLogMailer(log)
+	}
+
 	return &Server{
+		config: config,
 		storer: storer,
+		issuer: issuer,
 		log:    log,
+
+		permissionCache: newPermissionCache(config.PermissionCacheTTL),
 	}
 }
 
-func (s *Server) CreateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, error) {
+func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserReq) (*pb.User, error) {
 	s.log.Info("starting create user",
 		"method", "CreateUser",
 		"name", req.GetName(),
 		"email", req.GetEmail(),
 		"is_admin", req.GetIsAdmin(),
+		"is_service_account", req.GetIsServiceAccount(),
 	)
 
 	user := &db.User{
-		Name:     req.GetName(),
-		Email:    req.GetEmail(),
-		Password: req.GetPassword(),
-		IsAdmin:  req.GetIsAdmin(),
+		OrganizationId:   s.tenant(ctx),
+		Name:             req.GetName(),
+		Email:            req.GetEmail(),
+		IsAdmin:          req.GetIsAdmin(),
+		IsServiceAccount: req.GetIsServiceAccount(),
+	}
+
+	// Регистрация открыта всем, но администратора может создать только тот,
+	// кто управляет ролями, а сервисный аккаунт только тот, кто управляет пользователями
+	if user.IsAdmin {
+		if err := requirePermission(ctx, PermissionRolesManage); err != nil {
+			s.log.Warn("create user denied",
+				"method", "CreateUser",
+				"email", user.Email,
+				"reason", "admin flag",
+			)
+			return nil, err
+		}
+	}
+
+	if user.IsServiceAccount {
+		if err := requirePermission(ctx, PermissionUsersWrite); err != nil {
+			s.log.Warn("create user denied",
+				"method", "CreateUser",
+				"email", user.Email,
+				"reason", "service account flag",
+			)
+			return nil, err
+		}
+	}
+
+	if user.IsServiceAccount {
+		// Сервисный аккаунт работает только через API-ключи
+		if req.GetPassword() != "" {
+			err := status.Error(codes.InvalidArgument, "service account can not have a password")
+			s.log.Error("invalid arguments for create user",
+				"method", "CreateUser",
+				"error", err,
+			)
+			return nil, err
+		}
+	} else {
+		if err := s.checkPasswordPolicy(ctx, "password", req.GetPassword(), user); err != nil {
+			s.log.Error("password rejected by policy",
+				"method", "CreateUser",
+				"email", user.Email,
+				"error", err,
+			)
+			return nil, err
+		}
+
+		// Храним только хеш, иначе Authenticate не сможет проверить пароль
+		hashedPassword, err := s.config.Hasher.Hash(req.GetPassword())
+		if err != nil {
+			s.log.Error("failed to hash password", "method", "CreateUser", "error", err)
+			return nil, status.Error(codes.Internal, "failed to hash password")
+		}
+		user.Password = hashedPassword
+
+		// Пароль, который задал кто-то другой, временный: владелец сменит его при первом входе
+		if _, ok := PrincipalFromContext(ctx); ok {
+			user.MustChangePassword = true
+		}
 	}
 
 	if err := s.storer.CreateUser(ctx, user); err != nil {
 		s.log.Error("error creating user", "user", user.Email, "error", err)
+		if errors.Is(err, db.ErrEmailTaken) {
+			return nil, status.Error(codes.AlreadyExists, "email already exists")
+		}
 		return nil, err
 	}
 
+	// Первый пароль тоже попадает в историю, иначе на него можно будет вернуться
+	if !user.IsServiceAccount {
+		if err := s.rememberPassword(ctx, user); err != nil {
+			s.log.Error("failed to save password history",
+				"method", "CreateUser",
+				"user_id", user.Id,
+				"error", err,
+			)
+		}
+	}
+
 	s.log.Info("user created successfully",
 		"method", "CreateUser",
 		"user_id", user.Id,
@@ -52,10 +151,10 @@ func (s *Server) CreateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		"email", user.Email,
 	)
 
-	return toPBUserRes(user), nil
+	return toPBUser(user), nil
 }
 
-func (s *Server) GetUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, error) {
+func (s *Server) GetUser(ctx context.Context, req *pb.GetUserReq) (*pb.User, error) {
 	s.log.Info("starting get user",
 		"method", "GetUser",
 		"id", req.GetId(),
@@ -67,9 +166,9 @@ func (s *Server) GetUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, err
 
 	// Решаем, как искать пользователя - по ID или email
 	if req.GetId() > 0 {
-		user, err = s.storer.GetUserByID(ctx, int(req.GetId()))
+		user, err = s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetId()))
 	} else if req.GetEmail() != "" {
-		user, err = s.storer.GetUserByEmail(ctx, req.GetEmail())
+		user, err = s.storer.GetUserByEmail(ctx, s.tenant(ctx), req.GetEmail())
 	} else {
 		err := status.Error(codes.InvalidArgument, "id or email required")
 		s.log.Error("invalid arguments for get user",
@@ -89,6 +188,19 @@ func (s *Server) GetUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, err
 		return nil, err
 	}
 
+	res := toPBUser(user)
+
+	// Состояние 2FA показываем только в профиле одного пользователя,
+	// чтобы не делать лишних запросов на каждого пользователя в ListUsers
+	if err := s.fillTwoFactorInfo(ctx, res); err != nil {
+		s.log.Error("failed to get two-factor info",
+			"method", "GetUser",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to get user")
+	}
+
 	s.log.Debug("user retrieved successfully",
 		"method", "GetUser",
 		"user_id", user.Id,
@@ -96,15 +208,15 @@ func (s *Server) GetUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, err
 		"email", user.Email,
 	)
 
-	return toPBUserRes(user), nil
+	return res, nil
 }
 
-func (s *Server) ListUsers(ctx context.Context, req *pb.UserReq) (*pb.ListUserRes, error) {
+func (s *Server) ListUsers(ctx context.Context, req *pb.ListUsersReq) (*pb.ListUsersRes, error) {
 	s.log.Info("starting list users",
 		"method", "ListUsers",
 	)
 
-	users, err := s.storer.GetUsers(ctx)
+	users, err := s.storer.GetUsers(ctx, s.tenant(ctx))
 	if err != nil {
 		s.log.Error("failed to list users",
 			"method", "ListUsers",
@@ -113,10 +225,10 @@ func (s *Server) ListUsers(ctx context.Context, req *pb.UserReq) (*pb.ListUserRe
 		return nil, err
 	}
 
-	pbUsers := make([]*pb.UserRes, 0, len(users))
+	pbUsers := make([]*pb.User, 0, len(users))
 
 	for _, user := range users {
-		pbUsers = append(pbUsers, toPBUserRes(user))
+		pbUsers = append(pbUsers, toPBUser(user))
 	}
 
 	s.log.Info("users listed successfully",
@@ -124,12 +236,12 @@ func (s *Server) ListUsers(ctx context.Context, req *pb.UserReq) (*pb.ListUserRe
 		"count", len(users),
 	)
 
-	return &pb.ListUserRes{
+	return &pb.ListUsersRes{
 		Users: pbUsers,
 	}, nil
 }
 
-func (s *Server) UpdateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, error) {
+func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserReq) (*pb.User, error) {
 	s.log.Info("starting update user",
 		"method", "UpdateUser",
 		"user_id", req.GetId(),
@@ -147,7 +259,38 @@ func (s *Server) UpdateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		return nil, err
 	}
 
-	user, err := s.storer.GetUserByID(ctx, int(req.GetId()))
+	if req.GetIsAdmin() || req.GetIsServiceAccount() {
+		err := status.Error(codes.InvalidArgument, "is_admin and is_service_account can not be updated, use AssignRole and UnassignRole")
+
+		s.log.Error("invalid arguments for update user",
+			"method", "UpdateUser",
+			"error", err,
+		)
+
+		return nil, err
+	}
+
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, errAuthenticationRequired
+	}
+
+	self := principal.UserID == int(req.GetId())
+
+	// Свой пароль меняется только через ChangePassword: там нужен текущий пароль,
+	// а украденного access-токена для этого недостаточно
+	if self && req.GetPassword() != "" {
+		err := status.Error(codes.InvalidArgument, "use ChangePassword to change your own password")
+
+		s.log.Error("invalid arguments for update user",
+			"method", "UpdateUser",
+			"error", err,
+		)
+
+		return nil, err
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetId()))
 	if err != nil {
 		s.log.Error("failed to get user for update",
 			"method", "UpdateUser",
@@ -157,24 +300,71 @@ func (s *Server) UpdateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		return nil, err
 	}
 
+	emailChanged := req.GetEmail() != "" && req.GetEmail() != user.Email
+	credentialsChanged := req.GetPassword() != "" || emailChanged
+
+	// По email восстанавливается доступ к аккаунту, поэтому свой адрес меняется
+	// только с текущим паролем, иначе украденный access-токен дает весь аккаунт
+	if self && emailChanged {
+		if err := s.verifyCurrentPassword(ctx, user, req.GetCurrentPassword(), "UpdateUser"); err != nil {
+			return nil, err
+		}
+	}
+
+	if credentialsChanged && !self && !canManageCredentials(principal, user) {
+		s.log.Warn("access denied",
+			"method", "UpdateUser",
+			"user_id", principal.UserID,
+			"target_user_id", user.Id,
+			"reason", "target has more privileges",
+		)
+		return nil, status.Error(codes.PermissionDenied, "not allowed to change credentials of a user with more privileges")
+	}
+
 	// Обновляем только заполненные поля
 	if req.GetName() != "" {
 		user.Name = req.GetName()
 	}
 
-	if req.GetEmail() != "" {
+	// Новый адрес еще никто не подтверждал
+	if emailChanged {
 		user.Email = req.GetEmail()
+		user.EmailVerifiedAt = nil
 	}
 
+	passwordChanged := false
 	if req.GetPassword() != "" {
-		hashedPassword, err := password.Hash(req.GetPassword())
-		if err != nil {
+		if user.IsServiceAccount {
+			err := status.Error(codes.InvalidArgument, "service account can not have a password")
+			s.log.Error("invalid arguments for update user",
+				"method", "UpdateUser",
+				"error", err,
+			)
 			return nil, err
 		}
+
+		// Имя и email к этому моменту уже обновлены, сверяем пароль с новыми
+		if err := s.checkPasswordPolicy(ctx, "password", req.GetPassword(), user); err != nil {
+			s.log.Error("password rejected by policy",
+				"method", "UpdateUser",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, err
+		}
+
+		hashedPassword, err := s.config.Hasher.Hash(req.GetPassword())
+		if err != nil {
+			s.log.Error("failed to hash password", "method", "UpdateUser", "error", err)
+			return nil, status.Error(codes.Internal, "failed to hash password")
+		}
 		user.Password = hashedPassword
-	}
+		user.PasswordChangedAt = time.Now()
+		passwordChanged = true
 
-	user.IsAdmin = req.GetIsAdmin()
+		// Пароль задан не самим пользователем, его придется сменить при следующем входе
+		user.MustChangePassword = true
+	}
 
 	if err := s.storer.UpdateUser(ctx, user); err != nil {
 		s.log.Error("failed to update user",
@@ -185,15 +375,48 @@ func (s *Server) UpdateUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		return nil, err
 	}
 
+	// Ссылки на сброс пароля ушли на прежний адрес
+	if emailChanged && !passwordChanged {
+		if err := s.storer.InvalidatePasswordResetTokens(ctx, user.Id); err != nil {
+			s.log.Error("failed to invalidate reset tokens after email change",
+				"method", "UpdateUser",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to update user")
+		}
+	}
+
+	if passwordChanged {
+		if err := s.onPasswordChanged(ctx, user); err != nil {
+			s.log.Error("failed to run password change hooks",
+				"method", "UpdateUser",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to update user")
+		}
+
+		// Сессии, открытые со старым паролем, закрываются
+		if err := s.storer.RevokeUserTokens(ctx, user.Id); err != nil {
+			s.log.Error("failed to revoke sessions after password change",
+				"method", "UpdateUser",
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to update user")
+		}
+	}
+
 	s.log.Info("user updated successfully",
 		"method", "UpdateUser",
 		"user_id", user.Id,
 	)
 
-	return toPBUserRes(user), nil
+	return toPBUser(user), nil
 }
 
-func (s *Server) DeleteUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes, error) {
+func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserReq) (*pb.DeleteUserRes, error) {
 	s.log.Info("starting delete user",
 		"method", "DeleteUser",
 		"user_id", req.GetId(),
@@ -208,7 +431,37 @@ func (s *Server) DeleteUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		return nil, err
 	}
 
-	if err := s.storer.DeleteUser(ctx, int(req.GetId())); err != nil {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, errAuthenticationRequired
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetId()))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for delete",
+			"method", "DeleteUser",
+			"user_id", req.GetId(),
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to delete user")
+	}
+
+	// Удалить можно только того, чьи учетные данные разрешено менять
+	if principal.UserID != user.Id && !canManageCredentials(principal, user) {
+		s.log.Warn("access denied",
+			"method", "DeleteUser",
+			"user_id", principal.UserID,
+			"target_user_id", user.Id,
+			"reason", "target has more privileges",
+		)
+		return nil, status.Error(codes.PermissionDenied, "not allowed to delete a user with more privileges")
+	}
+
+	if err := s.storer.DeleteUser(ctx, s.tenant(ctx), user.Id); err != nil {
 		s.log.Error("failed to delete user",
 			"method", "DeleteUser",
 			"user_id", req.GetId(),
@@ -217,5 +470,7 @@ func (s *Server) DeleteUser(ctx context.Context, req *pb.UserReq) (*pb.UserRes,
 		return nil, err
 	}
 
-	return &pb.UserRes{}, nil
+	s.permissionCache.invalidate(int(req.GetId()))
+
+	return &pb.DeleteUserRes{}, nil
 }
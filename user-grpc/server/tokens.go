@@ -0,0 +1,460 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	errInvalidRefreshToken = status.Error(codes.Unauthenticated, "invalid refresh token")
+	errInvalidAccessToken  = status.Error(codes.Unauthenticated, "invalid or expired access token")
+)
+
+// sessionActive проверяет, что сессия access-токена не отозвана. Сессия входа
+// под пользователем хранится отдельно от семейств refresh-токенов
+func (s *Server) sessionActive(ctx context.Context, claims *token.Claims) (bool, error) {
+	if claims.Act != nil {
+		session, err := s.storer.GetImpersonationSession(ctx, claims.SessionID)
+		if err != nil {
+			if errors.Is(err, db.ErrImpersonationNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return session.IsActive(time.Now()) &&
+			session.UserId == claims.UserID &&
+			session.ActorId == claims.Act.UserID, nil
+	}
+
+	revoked, err := s.storer.IsTokenFamilyRevoked(ctx, claims.SessionID)
+	if err != nil {
+		return false, err
+	}
+
+	return !revoked, nil
+}
+
+// authenticateAccessToken проверяет access-токен и возвращает principal.
+// Права берутся из базы, как и в IntrospectToken
+func (s *Server) authenticateAccessToken(ctx context.Context, raw string) (*Principal, error) {
+	claims, err := s.issuer.ParseAccessToken(raw)
+	if err != nil {
+		s.log.Warn("access token rejected", "error", err)
+		return nil, errInvalidAccessToken
+	}
+
+	// Токен стороннего приложения не должен давать ему полный доступ к API
+	if claims.ClientID != "" {
+		s.log.Warn("access token rejected", "user_id", claims.UserID, "reason", "issued to oauth client")
+		return nil, errInvalidAccessToken
+	}
+
+	active, err := s.sessionActive(ctx, claims)
+	if err != nil {
+		s.log.Error("failed to check session", "user_id", claims.UserID, "error", err)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	if !active {
+		s.log.Warn("access token rejected", "user_id", claims.UserID, "reason", "session revoked")
+		return nil, errInvalidAccessToken
+	}
+
+	user, err := s.storer.GetUserByID(ctx, claims.OrgID(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, errInvalidAccessToken
+		}
+
+		s.log.Error("failed to get token subject", "user_id", claims.UserID, "error", err)
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	principal := &Principal{
+		UserID:           user.Id,
+		OrganizationID:   user.OrganizationId,
+		IsAdmin:          user.IsAdmin,
+		IsServiceAccount: user.IsServiceAccount,
+		Permissions:      user.Permissions,
+		SessionID:        claims.SessionID,
+	}
+
+	// Права администратора не переходят в сессию входа под пользователем
+	if claims.Act != nil {
+		principal.ActorID = claims.Act.UserID
+		principal.IsAdmin = false
+	}
+
+	return principal, nil
+}
+
+// issueSession открывает новую сессию (семейство refresh-токенов) для пользователя.
+// clientID и scope задаются для сессий, выданных OAuth-клиенту
+func (s *Server) issueSession(ctx context.Context, user *db.User, clientID *string, scope string) (*pb.TokenPair, error) {
+	familyID, err := token.RandomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, familyID, clientID, scope)
+}
+
+// issueTokens выпускает пару access/refresh токенов в рамках указанной сессии.
+// Access-токен OAuth-клиента несет его client_id и области и не принимается API
+func (s *Server) issueTokens(ctx context.Context, user *db.User, familyID string, clientID *string, scope string) (*pb.TokenPair, error) {
+	plain, hash, err := token.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := &db.RefreshToken{
+		UserId:         user.Id,
+		OrganizationId: user.OrganizationId,
+		FamilyId:       familyID,
+		ClientId:       clientID,
+		Scope:          scope,
+		TokenHash:      hash,
+		ExpiresAt:      time.Now().Add(s.issuer.RefreshTTL()),
+	}
+
+	if err := s.storer.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, err
+	}
+
+	var (
+		access          string
+		accessExpiresAt time.Time
+	)
+
+	if clientID != nil {
+		access, accessExpiresAt, err = s.issuer.IssueClientAccessToken(user, familyID, *clientID, scope)
+	} else {
+		access, accessExpiresAt, err = s.issuer.IssueAccessToken(user, familyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.TokenPair{
+		AccessToken:           access,
+		RefreshToken:          plain,
+		TokenType:             "Bearer",
+		AccessTokenExpiresAt:  timestamppb.New(accessExpiresAt),
+		RefreshTokenExpiresAt: timestamppb.New(refresh.ExpiresAt),
+	}, nil
+}
+
+func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenReq) (*pb.RefreshTokenRes, error) {
+	s.log.Info("starting refresh token",
+		"method", "RefreshToken",
+	)
+
+	if req.GetRefreshToken() == "" {
+		err := status.Error(codes.InvalidArgument, "refresh token required")
+		s.log.Error("invalid arguments for refresh token",
+			"method", "RefreshToken",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	// Токены OAuth-клиентов обмениваются только через token endpoint
+	return s.rotateRefreshToken(ctx, req.GetRefreshToken(), nil)
+}
+
+// rotateRefreshToken обменивает refresh-токен на новую пару в той же сессии.
+// Токен принимается, только если выдан тому же OAuth-клиенту (nil для обычного входа).
+// Временный или истекший пароль закрывает сессию, как и при входе
+func (s *Server) rotateRefreshToken(ctx context.Context, raw string, clientID *string) (*pb.RefreshTokenRes, error) {
+	current, err := s.storer.GetRefreshTokenByHash(ctx, token.HashToken(raw))
+	if err != nil {
+		if errors.Is(err, db.ErrTokenNotFound) {
+			s.log.Warn("refresh token not found",
+				"method", "RefreshToken",
+			)
+			return nil, errInvalidRefreshToken
+		}
+
+		s.log.Error("failed to get refresh token",
+			"method", "RefreshToken",
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		s.log.Warn("refresh token is revoked or expired",
+			"method", "RefreshToken",
+			"user_id", current.UserId,
+			"family_id", current.FamilyId,
+		)
+		return nil, errInvalidRefreshToken
+	}
+
+	if !sameClient(current.ClientId, clientID) {
+		s.log.Warn("refresh token presented by another client",
+			"method", "RefreshToken",
+			"user_id", current.UserId,
+			"family_id", current.FamilyId,
+		)
+		return nil, errInvalidRefreshToken
+	}
+
+	// Повторное предъявление уже ротированного токена означает, что он утек.
+	// Отзываем всю сессию, чтобы украденная цепочка стала бесполезной
+	if current.UsedAt != nil {
+		return nil, s.handleRefreshReuse(ctx, current)
+	}
+
+	marked, err := s.storer.MarkRefreshTokenUsed(ctx, current.Id)
+	if err != nil {
+		s.log.Error("failed to mark refresh token used",
+			"method", "RefreshToken",
+			"user_id", current.UserId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	// Параллельный запрос успел использовать этот же токен
+	if !marked {
+		return nil, s.handleRefreshReuse(ctx, current)
+	}
+
+	// Сессия продлевается в организации, в которой открыта, а не в той,
+	// что указана в запросе
+	user, err := s.storer.GetUserByID(ctx, current.OrganizationId, current.UserId)
+	if err != nil {
+		s.log.Warn("failed to load user for refresh",
+			"method", "RefreshToken",
+			"user_id", current.UserId,
+			"error", err,
+		)
+		if err := s.storer.RevokeTokenFamily(ctx, current.FamilyId); err != nil {
+			s.log.Error("failed to revoke token family",
+				"method", "RefreshToken",
+				"family_id", current.FamilyId,
+				"error", err,
+			)
+		}
+		return nil, errInvalidRefreshToken
+	}
+
+	// Без этой проверки сессия жила бы с истекшим или сброшенным администратором
+	// паролем до конца жизни refresh-токена
+	if required, reason := s.passwordChangeRequired(user, time.Now()); required {
+		if err := s.storer.RevokeTokenFamily(ctx, current.FamilyId); err != nil {
+			s.log.Error("failed to revoke token family",
+				"method", "RefreshToken",
+				"family_id", current.FamilyId,
+				"error", err,
+			)
+			return nil, status.Error(codes.Internal, "failed to refresh token")
+		}
+
+		res, err := s.requirePasswordChange(user, reason, "RefreshToken")
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.RefreshTokenRes{
+			PasswordChangeRequired: true,
+			ChallengeToken:         res.GetChallengeToken(),
+			ChallengeExpiresAt:     res.GetChallengeExpiresAt(),
+		}, nil
+	}
+
+	tokens, err := s.issueTokens(ctx, user, current.FamilyId, current.ClientId, current.Scope)
+	if err != nil {
+		s.log.Error("failed to issue tokens",
+			"method", "RefreshToken",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	s.log.Info("token refreshed successfully",
+		"method", "RefreshToken",
+		"user_id", user.Id,
+	)
+
+	return &pb.RefreshTokenRes{
+		Tokens: tokens,
+	}, nil
+}
+
+// sameClient сравнивает OAuth-клиентов, которым выданы токены
+func sameClient(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// handleRefreshReuse отзывает сессию, в которой обнаружено повторное использование токена
+func (s *Server) handleRefreshReuse(ctx context.Context, t *db.RefreshToken) error {
+	s.log.Warn("refresh token reuse detected, revoking session",
+		"method", "RefreshToken",
+		"user_id", t.UserId,
+		"family_id", t.FamilyId,
+	)
+
+	if err := s.storer.RevokeTokenFamily(ctx, t.FamilyId); err != nil {
+		s.log.Error("failed to revoke token family",
+			"method", "RefreshToken",
+			"family_id", t.FamilyId,
+			"error", err,
+		)
+		return status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	return errInvalidRefreshToken
+}
+
+func (s *Server) RevokeToken(ctx context.Context, req *pb.RevokeTokenReq) (*pb.RevokeTokenRes, error) {
+	s.log.Info("starting revoke token",
+		"method", "RevokeToken",
+	)
+
+	if req.GetRefreshToken() == "" {
+		err := status.Error(codes.InvalidArgument, "refresh token required")
+		s.log.Error("invalid arguments for revoke token",
+			"method", "RevokeToken",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	current, err := s.storer.GetRefreshTokenByHash(ctx, token.HashToken(req.GetRefreshToken()))
+	if err != nil {
+		// Как и в RFC 7009, неизвестный токен не считается ошибкой
+		if errors.Is(err, db.ErrTokenNotFound) {
+			return &pb.RevokeTokenRes{}, nil
+		}
+
+		s.log.Error("failed to get refresh token",
+			"method", "RevokeToken",
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+
+	if err := s.storer.RevokeTokenFamily(ctx, current.FamilyId); err != nil {
+		s.log.Error("failed to revoke token family",
+			"method", "RevokeToken",
+			"family_id", current.FamilyId,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+
+	s.log.Info("token revoked successfully",
+		"method", "RevokeToken",
+		"user_id", current.UserId,
+	)
+
+	return &pb.RevokeTokenRes{}, nil
+}
+
+func (s *Server) IntrospectToken(ctx context.Context, req *pb.IntrospectTokenReq) (*pb.IntrospectTokenRes, error) {
+	s.log.Info("starting introspect token",
+		"method", "IntrospectToken",
+	)
+
+	if req.GetToken() == "" {
+		err := status.Error(codes.InvalidArgument, "token required")
+		s.log.Error("invalid arguments for introspect token",
+			"method", "IntrospectToken",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	claims, err := s.issuer.ParseAccessToken(req.GetToken())
+	if err != nil {
+		s.log.Debug("token is not active",
+			"method", "IntrospectToken",
+			"error", err,
+		)
+		return &pb.IntrospectTokenRes{Active: false}, nil
+	}
+
+	// Токены OAuth-клиентов действуют только в userinfo
+	if claims.ClientID != "" {
+		s.log.Debug("token is issued to oauth client",
+			"method", "IntrospectToken",
+			"user_id", claims.UserID,
+			"client_id", claims.ClientID,
+		)
+		return &pb.IntrospectTokenRes{Active: false}, nil
+	}
+
+	active, err := s.sessionActive(ctx, claims)
+	if err != nil {
+		s.log.Error("failed to check session revocation",
+			"method", "IntrospectToken",
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to introspect token")
+	}
+
+	if !active {
+		s.log.Debug("token session is revoked",
+			"method", "IntrospectToken",
+			"user_id", claims.UserID,
+		)
+		return &pb.IntrospectTokenRes{Active: false, Revoked: true}, nil
+	}
+
+	// Данные берем из базы, а не из claims: удаление пользователя или снятие
+	// прав администратора должно отражаться сразу, а не после истечения токена.
+	// Токен пользователя другой организации для вызывающего неактивен
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), claims.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			s.log.Debug("token subject no longer exists",
+				"method", "IntrospectToken",
+				"user_id", claims.UserID,
+			)
+			return &pb.IntrospectTokenRes{Active: false}, nil
+		}
+
+		s.log.Error("failed to load token subject",
+			"method", "IntrospectToken",
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		return nil, status.Error(codes.Internal, "failed to introspect token")
+	}
+
+	res := &pb.IntrospectTokenRes{
+		Active:         true,
+		Subject:        claims.Subject,
+		UserId:         int64(user.Id),
+		OrganizationId: int64(user.OrganizationId),
+		Email:          user.Email,
+		IsAdmin:        user.IsAdmin,
+		ExpiresAt:      timestamppb.New(claims.ExpiresAt.Time),
+		IssuedAt:       timestamppb.New(claims.IssuedAt.Time),
+	}
+
+	// Сервисы, которые доверяют интроспекции, должны видеть, что за пользователем стоит администратор
+	if claims.Act != nil {
+		res.ActorId = int64(claims.Act.UserID)
+		res.IsAdmin = false
+	}
+
+	return res, nil
+}
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rx3lixir/user-service/internal/db"
+	"github.com/rx3lixir/user-service/internal/token"
+	"github.com/rx3lixir/user-service/pkg/totp"
+	pb "github.com/rx3lixir/user-service/user-grpc/gen/go"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// challengeTOTP назначение challenge-токена, который завершается вводом TOTP-кода
+	challengeTOTP = "totp"
+	// totpSkew допустимое расхождение часов в шагах
+	totpSkew = 1
+	// maxChallengeFailures после стольких неверных кодов challenge перестает приниматься
+	maxChallengeFailures = 5
+)
+
+var (
+	errInvalidTOTPCode     = status.Error(codes.Unauthenticated, "invalid two-factor code")
+	errInvalidChallenge    = status.Error(codes.Unauthenticated, "invalid or expired challenge")
+	errTOTPNotConfigured   = status.Error(codes.FailedPrecondition, "two-factor authentication is not configured on the server")
+	errTOTPNotEnabled      = status.Error(codes.FailedPrecondition, "two-factor authentication is not enabled")
+	errTOTPAlreadyEnabled  = status.Error(codes.AlreadyExists, "two-factor authentication is already enabled")
+	errTOTPNotEnrolled     = status.Error(codes.FailedPrecondition, "two-factor enrollment is not started")
+	errTOTPInternalFailure = status.Error(codes.Internal, "failed to process two-factor request")
+)
+
+// totpEnabled сообщает, подтверждена ли у пользователя 2FA
+func (s *Server) totpEnabled(ctx context.Context, userID int) (bool, error) {
+	t, err := s.storer.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return t.ConfirmedAt != nil, nil
+}
+
+// checkTOTPCode проверяет код и запрещает его повторное использование
+func (s *Server) checkTOTPCode(ctx context.Context, t *db.UserTOTP, code string) (bool, error) {
+	plainSecret, err := s.config.SecretBox.Open(t.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(string(plainSecret), code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	return s.storer.UseTOTPStep(ctx, t.UserId, step)
+}
+
+// checkAccountTOTPCode проверяет код при подтверждении и отключении 2FA.
+// Неверные коды учитываются в счетчике неудачных входов, как и на втором
+// факторе, иначе владелец access-токена мог бы перебрать код без ограничений
+func (s *Server) checkAccountTOTPCode(ctx context.Context, t *db.UserTOTP, code, method string) error {
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), t.UserId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for totp check",
+			"method", method,
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return errTOTPInternalFailure
+	}
+
+	if user.IsLocked(time.Now()) {
+		s.log.Warn("totp code refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "account locked",
+			"locked_until", *user.LockedUntil,
+		)
+		s.auditLockedLogin(ctx, user, method)
+		return errInvalidTOTPCode
+	}
+
+	ok, err := s.checkTOTPCode(ctx, t, code)
+	if err != nil {
+		s.log.Error("failed to check totp code",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return errTOTPInternalFailure
+	}
+
+	if !ok {
+		s.log.Warn("totp code refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "wrong code",
+		)
+
+		if err := s.registerFailedLogin(ctx, user, method); err != nil {
+			s.log.Error("failed to register failed login",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return errTOTPInternalFailure
+		}
+
+		return errInvalidTOTPCode
+	}
+
+	return nil
+}
+
+func (s *Server) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPReq) (*pb.EnrollTOTPRes, error) {
+	s.log.Info("starting enroll totp",
+		"method", "EnrollTOTP",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 {
+		err := status.Error(codes.InvalidArgument, "user id required")
+		s.log.Error("invalid arguments for enroll totp",
+			"method", "EnrollTOTP",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errTOTPNotConfigured
+	}
+
+	user, err := s.storer.GetUserByID(ctx, s.tenant(ctx), int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		s.log.Error("failed to get user for totp enrollment",
+			"method", "EnrollTOTP",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	enabled, err := s.totpEnabled(ctx, user.Id)
+	if err != nil {
+		s.log.Error("failed to check two-factor status",
+			"method", "EnrollTOTP",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if enabled {
+		return nil, errTOTPAlreadyEnabled
+	}
+
+	plainSecret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, errTOTPInternalFailure
+	}
+
+	sealed, err := s.config.SecretBox.Seal([]byte(plainSecret))
+	if err != nil {
+		s.log.Error("failed to encrypt totp secret",
+			"method", "EnrollTOTP",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if err := s.storer.UpsertTOTP(ctx, &db.UserTOTP{UserId: user.Id, Secret: sealed}); err != nil {
+		s.log.Error("failed to save totp secret",
+			"method", "EnrollTOTP",
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	s.log.Info("totp enrollment started",
+		"method", "EnrollTOTP",
+		"user_id", user.Id,
+	)
+
+	return &pb.EnrollTOTPRes{
+		Secret:          plainSecret,
+		ProvisioningUri: totp.ProvisioningURI(s.config.TOTPIssuer, user.Email, plainSecret),
+	}, nil
+}
+
+func (s *Server) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPReq) (*pb.ConfirmTOTPRes, error) {
+	s.log.Info("starting confirm totp",
+		"method", "ConfirmTOTP",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 || req.GetCode() == "" {
+		err := status.Error(codes.InvalidArgument, "user id and code required")
+		s.log.Error("invalid arguments for confirm totp",
+			"method", "ConfirmTOTP",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errTOTPNotConfigured
+	}
+
+	t, err := s.storer.GetTOTP(ctx, int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPNotFound) {
+			return nil, errTOTPNotEnrolled
+		}
+
+		s.log.Error("failed to get totp",
+			"method", "ConfirmTOTP",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if t.ConfirmedAt != nil {
+		return nil, errTOTPAlreadyEnabled
+	}
+
+	if err := s.checkAccountTOTPCode(ctx, t, req.GetCode(), "ConfirmTOTP"); err != nil {
+		return nil, err
+	}
+
+	if err := s.storer.ConfirmTOTP(ctx, t.UserId); err != nil {
+		s.log.Error("failed to confirm totp",
+			"method", "ConfirmTOTP",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	recoveryCodes, err := s.generateRecoveryCodes(ctx, t.UserId)
+	if err != nil {
+		s.log.Error("failed to generate recovery codes",
+			"method", "ConfirmTOTP",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	s.log.Info("two-factor authentication enabled",
+		"method", "ConfirmTOTP",
+		"user_id", t.UserId,
+	)
+
+	return &pb.ConfirmTOTPRes{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *Server) DisableTOTP(ctx context.Context, req *pb.DisableTOTPReq) (*pb.DisableTOTPRes, error) {
+	s.log.Info("starting disable totp",
+		"method", "DisableTOTP",
+		"user_id", req.GetUserId(),
+	)
+
+	if req.GetUserId() == 0 || req.GetCode() == "" {
+		err := status.Error(codes.InvalidArgument, "user id and code required")
+		s.log.Error("invalid arguments for disable totp",
+			"method", "DisableTOTP",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	if s.config.SecretBox == nil {
+		return nil, errTOTPNotConfigured
+	}
+
+	t, err := s.storer.GetTOTP(ctx, int(req.GetUserId()))
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPNotFound) {
+			return nil, errTOTPNotEnabled
+		}
+
+		s.log.Error("failed to get totp",
+			"method", "DisableTOTP",
+			"user_id", req.GetUserId(),
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if t.ConfirmedAt == nil {
+		return nil, errTOTPNotEnabled
+	}
+
+	if err := s.checkAccountTOTPCode(ctx, t, req.GetCode(), "DisableTOTP"); err != nil {
+		return nil, err
+	}
+
+	if err := s.storer.DeleteTOTP(ctx, t.UserId); err != nil {
+		s.log.Error("failed to delete totp",
+			"method", "DisableTOTP",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if err := s.storer.DeleteRecoveryCodes(ctx, t.UserId); err != nil {
+		s.log.Error("failed to delete recovery codes",
+			"method", "DisableTOTP",
+			"user_id", t.UserId,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	s.log.Info("two-factor authentication disabled",
+		"method", "DisableTOTP",
+		"user_id", t.UserId,
+	)
+
+	return &pb.DisableTOTPRes{}, nil
+}
+
+func (s *Server) VerifyTOTP(ctx context.Context, req *pb.VerifyTOTPReq) (*pb.AuthenticateRes, error) {
+	s.log.Info("starting verify totp",
+		"method", "VerifyTOTP",
+	)
+
+	if req.GetChallengeToken() == "" || (req.GetCode() == "" && req.GetRecoveryCode() == "") {
+		err := status.Error(codes.InvalidArgument, "challenge token and code or recovery code required")
+		s.log.Error("invalid arguments for verify totp",
+			"method", "VerifyTOTP",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	user, err := s.verifySecondFactor(ctx, req.GetChallengeToken(), req.GetCode(), req.GetRecoveryCode(), "VerifyTOTP")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, user, "VerifyTOTP")
+}
+
+// verifySecondFactor проверяет TOTP-код или код восстановления по challenge,
+// выданному после пароля, и возвращает пользователя, прошедшего оба фактора
+func (s *Server) verifySecondFactor(ctx context.Context, challenge, code, recoveryCode, method string) (*db.User, error) {
+	if s.config.SecretBox == nil {
+		return nil, errTOTPNotConfigured
+	}
+
+	claims, err := s.issuer.ParseChallengeToken(challenge, challengeTOTP)
+	if err != nil {
+		s.log.Warn("invalid challenge token",
+			"method", method,
+			"error", err,
+		)
+		return nil, errInvalidChallenge
+	}
+
+	user, err := s.storer.GetUserByID(ctx, claims.OrgID(), claims.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, errInvalidChallenge
+		}
+
+		s.log.Error("failed to get user for totp verification",
+			"method", method,
+			"user_id", claims.UserID,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	t, err := s.storer.GetTOTP(ctx, user.Id)
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPNotFound) {
+			return nil, errInvalidChallenge
+		}
+
+		s.log.Error("failed to get totp",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if t.ConfirmedAt == nil {
+		return nil, errInvalidChallenge
+	}
+
+	// Без ограничения по одному challenge можно было бы перебрать все коды
+	// за время его жизни. Блокировка аккаунта действует и на второй фактор
+	if user.IsLocked(time.Now()) {
+		s.log.Warn("authentication refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "account locked",
+			"locked_until", *user.LockedUntil,
+		)
+		s.auditLockedLogin(ctx, user, method)
+		return nil, errInvalidChallenge
+	}
+
+	failures, err := s.storer.CountChallengeFailures(ctx, claims.ID)
+	if err != nil {
+		s.log.Error("failed to count challenge failures",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if failures >= maxChallengeFailures {
+		s.log.Warn("authentication refused",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "too many wrong codes for challenge",
+		)
+		return nil, errInvalidChallenge
+	}
+
+	// Код восстановления заменяет второй фактор, если устройство утеряно
+	var ok bool
+	if recoveryCode != "" {
+		ok, err = s.useRecoveryCode(ctx, user.Id, recoveryCode)
+	} else {
+		ok, err = s.checkTOTPCode(ctx, t, code)
+	}
+
+	if err != nil {
+		s.log.Error("failed to check second factor",
+			"method", method,
+			"user_id", user.Id,
+			"error", err,
+		)
+		return nil, errTOTPInternalFailure
+	}
+
+	if !ok {
+		s.log.Warn("authentication failed",
+			"method", method,
+			"user_id", user.Id,
+			"reason", "wrong second factor",
+		)
+
+		if err := s.registerSecondFactorFailure(ctx, user, claims, method); err != nil {
+			s.log.Error("failed to register second factor failure",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, errTOTPInternalFailure
+		}
+
+		return nil, errInvalidTOTPCode
+	}
+
+	// Счетчик неудачных попыток при включенной 2FA сбрасывается только здесь
+	if user.FailedLoginAttempts > 0 {
+		if err := s.storer.ResetFailedLogins(ctx, user.OrganizationId, user.Id); err != nil {
+			s.log.Error("failed to reset failed logins",
+				"method", method,
+				"user_id", user.Id,
+				"error", err,
+			)
+			return nil, errTOTPInternalFailure
+		}
+	}
+
+	if recoveryCode != "" {
+		s.log.Warn("recovery code used for login",
+			"method", method,
+			"user_id", user.Id,
+		)
+	}
+
+	return user, nil
+}
+
+// registerSecondFactorFailure учитывает неверный код и в счетчике challenge,
+// и в счетчике неудачных входов пользователя, от которого зависит блокировка
+func (s *Server) registerSecondFactorFailure(ctx context.Context, user *db.User, claims *token.ChallengeClaims, method string) error {
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	if _, err := s.storer.RecordChallengeFailure(ctx, claims.ID, user.Id, expiresAt); err != nil {
+		return err
+	}
+
+	if err := s.storer.PruneChallengeFailures(ctx, time.Now()); err != nil {
+		s.log.Error("failed to prune challenge failures", "error", err)
+	}
+
+	return s.registerFailedLogin(ctx, user, method)
+}